@@ -4,6 +4,7 @@ go 1.12
 
 require (
 	github.com/google/go-github/v31 v31.0.0
+	github.com/mattermost/mattermost-plugin-api v0.0.12-0.20200908143138-66edf222f7ea
 	github.com/mattermost/mattermost-plugin-github v1.0.1-0.20200918060824-e9247eec4282
 	github.com/mattermost/mattermost-server/v5 v5.27.0
 	github.com/mholt/archiver/v3 v3.3.0
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// gitHubBotType is the type of GitHub users that belong to a GitHub App, e.g. dependabot[bot].
+const gitHubBotType = "Bot"
+
+// gitHubBotEmailSuffix ends the noreply email address of GitHub Apps, e.g. 49699333+dependabot[bot]@users.noreply.github.com.
+const gitHubBotEmailSuffix = "[bot]@users.noreply.github.com"
+
+// parseBotPatterns compiles a comma separated list of regular expressions for the logins of bots.
+func parseBotPatterns(patterns string) ([]*regexp.Regexp, error) {
+	var result []*regexp.Regexp
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bot login pattern %q: %v", pattern, err)
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
+
+// botFilter detects bots and automation accounts, which are left out of every report.
+type botFilter struct {
+	patterns []*regexp.Regexp
+}
+
+func (p *Plugin) getBotFilter() *botFilter {
+	return &botFilter{patterns: p.getConfiguration().botPatterns}
+}
+
+// isBotLogin checks if a login ends with [bot], like the logins of GitHub Apps, or matches one of the configured patterns.
+func (f *botFilter) isBotLogin(login string) bool {
+	if strings.HasSuffix(strings.ToLower(login), "[bot]") {
+		return true
+	}
+	for _, re := range f.patterns {
+		if re.MatchString(login) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBot checks if a user is a bot.
+func (f *botFilter) isBot(user *github.User) bool {
+	if user == nil {
+		return false
+	}
+	return user.GetType() == gitHubBotType || f.isBotLogin(user.GetLogin())
+}
+
+// isBotCommit checks if a commit was authored by a bot and returns the name the bot is listed with.
+// Commits that aren't linked to a user account are checked by the name and email address of their author.
+func (f *botFilter) isBotCommit(c *github.RepositoryCommit) (string, bool) {
+	if user := c.GetAuthor(); user != nil {
+		return user.GetLogin(), f.isBot(user)
+	}
+
+	author := c.GetCommit().GetAuthor()
+	name, email := author.GetName(), author.GetEmail()
+	if name == "" {
+		name = email
+	}
+	if strings.HasSuffix(strings.ToLower(email), gitHubBotEmailSuffix) || (author.GetName() != "" && f.isBotLogin(author.GetName())) {
+		return name, true
+	}
+	return "", false
+}
+
+// filteredBots counts what was left out of a report, and by which bots.
+type filteredBots struct {
+	count  int
+	logins map[string]bool
+}
+
+func (b *filteredBots) add(login string) {
+	if b.logins == nil {
+		b.logins = map[string]bool{}
+	}
+	b.count++
+	b.logins[login] = true
+}
+
+// field returns the attachment fields that show what was filtered, e.g. "dependabot[bot]: 12 commits".
+// If unit is empty, only the bots are listed. No field is returned if nothing was filtered.
+func (b filteredBots) field(unit string) []*model.SlackAttachmentField {
+	if b.count == 0 {
+		return nil
+	}
+
+	var logins []string
+	for login := range b.logins {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+
+	value := strings.Join(logins, ", ")
+	if unit != "" {
+		value += fmt.Sprintf(": %v %v", b.count, unit)
+	}
+	return []*model.SlackAttachmentField{{
+		Title: "Filtered bots",
+		Value: value,
+	}}
+}
+
+func (f *botFilter) filterCommits(commits []*github.RepositoryCommit) ([]*github.RepositoryCommit, filteredBots) {
+	var result []*github.RepositoryCommit
+	var filtered filteredBots
+	for _, c := range commits {
+		if name, ok := f.isBotCommit(c); ok {
+			filtered.add(name)
+			continue
+		}
+		result = append(result, c)
+	}
+	return result, filtered
+}
+
+func (f *botFilter) filterPullRequests(pullRequests []*github.PullRequest) ([]*github.PullRequest, filteredBots) {
+	var result []*github.PullRequest
+	var filtered filteredBots
+	for _, pullRequest := range pullRequests {
+		if f.isBot(pullRequest.GetUser()) {
+			filtered.add(pullRequest.GetUser().GetLogin())
+			continue
+		}
+		result = append(result, pullRequest)
+	}
+	return result, filtered
+}
+
+func (f *botFilter) filterIssues(issues []*github.Issue) ([]*github.Issue, filteredBots) {
+	var result []*github.Issue
+	var filtered filteredBots
+	for _, issue := range issues {
+		if f.isBot(issue.GetUser()) {
+			filtered.add(issue.GetUser().GetLogin())
+			continue
+		}
+		result = append(result, issue)
+	}
+	return result, filtered
+}
+
+func (f *botFilter) filterReviews(activities []reviewActivity) ([]reviewActivity, filteredBots) {
+	var result []reviewActivity
+	var filtered filteredBots
+	for _, activity := range activities {
+		if f.isBotLogin(activity.login) {
+			filtered.add(activity.login)
+			continue
+		}
+		result = append(result, activity)
+	}
+	return result, filtered
+}
+
+func (f *botFilter) filterContributions(contributions []contribution) ([]contribution, filteredBots) {
+	var result []contribution
+	var filtered filteredBots
+	for _, c := range contributions {
+		if f.isBotLogin(c.author) {
+			filtered.add(c.author)
+			continue
+		}
+		result = append(result, c)
+	}
+	return result, filtered
+}
+
+func (f *botFilter) filterFirstContributions(firstContributions []firstContributionInfo) ([]firstContributionInfo, filteredBots) {
+	var result []firstContributionInfo
+	var filtered filteredBots
+	for _, e := range firstContributions {
+		if f.isBotLogin(e.author) {
+			filtered.add(e.author)
+			continue
+		}
+		result = append(result, e)
+	}
+	return result, filtered
+}
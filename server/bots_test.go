@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBotPatterns(t *testing.T) {
+	patterns, err := parseBotPatterns("")
+	require.NoError(t, err)
+	assert.Empty(t, patterns)
+
+	patterns, err = parseBotPatterns("^mattermod$, -bot$ ,")
+	require.NoError(t, err)
+	assert.Len(t, patterns, 2)
+
+	_, err = parseBotPatterns("^mattermod$, (")
+	assert.Error(t, err)
+}
+
+func TestBotFilter(t *testing.T) {
+	patterns, err := parseBotPatterns("^mattermod$, -bot$")
+	require.NoError(t, err)
+	f := &botFilter{patterns: patterns}
+
+	assert.True(t, f.isBotLogin("dependabot[bot]"))
+	assert.True(t, f.isBotLogin("Renovate[Bot]"))
+	assert.True(t, f.isBotLogin("mattermod"))
+	assert.True(t, f.isBotLogin("release-bot"))
+	assert.False(t, f.isBotLogin("mattermodder"))
+	assert.False(t, f.isBotLogin("alice"))
+
+	assert.False(t, f.isBot(nil))
+	assert.True(t, f.isBot(&github.User{Login: github.String("ci"), Type: github.String(gitHubBotType)}))
+	assert.False(t, f.isBot(&github.User{Login: github.String("alice"), Type: github.String("User")}))
+
+	commit := func(login string) *github.RepositoryCommit {
+		c := &github.RepositoryCommit{SHA: github.String(login)}
+		if login != "" {
+			c.Author = &github.User{Login: github.String(login)}
+		}
+		return c
+	}
+	unlinkedCommit := func(name, email string) *github.RepositoryCommit {
+		return &github.RepositoryCommit{
+			SHA:    github.String(email),
+			Commit: &github.Commit{Author: &github.CommitAuthor{Name: github.String(name), Email: github.String(email)}},
+		}
+	}
+	commits, filtered := f.filterCommits([]*github.RepositoryCommit{
+		commit("alice"),
+		commit("dependabot[bot]"),
+		commit("dependabot[bot]"),
+		commit("mattermod"),
+		// Unlinked commits are checked by the name and email address of their author
+		unlinkedCommit("jane", "jane@example.com"),
+		unlinkedCommit("renovate[bot]", "bot@renovateapp.com"),
+		unlinkedCommit("Release", "41898282+github-actions[bot]@users.noreply.github.com"),
+		unlinkedCommit("", "49699333+dependabot[bot]@users.noreply.github.com"),
+		unlinkedCommit("docs-bot", "docs@example.com"),
+	})
+	require.Len(t, commits, 2)
+	assert.Equal(t, "alice", commits[0].GetSHA())
+	assert.Equal(t, "jane@example.com", commits[1].GetSHA())
+	assert.Equal(t, 7, filtered.count)
+
+	fields := filtered.field("commits")
+	require.Len(t, fields, 1)
+	assert.Equal(t, "49699333+dependabot[bot]@users.noreply.github.com, Release, dependabot[bot], docs-bot, mattermod, renovate[bot]: 7 commits", fields[0].Value)
+
+	_, filtered = f.filterCommits([]*github.RepositoryCommit{commit("alice")})
+	assert.Empty(t, filtered.field("commits"))
+}
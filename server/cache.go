@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+)
+
+const (
+	commitCacheKeyPrefix = "commits_"
+	commitShardKeyPrefix = "commits_m_"
+
+	// commitShardFormat formats the months of the shards of the commit cache.
+	commitShardFormat = "200601"
+
+	// commitCacheOverlap is the time span before the end of the cached range that gets fetched again on every sync.
+	// The APIs only filter commits by their commit date, which can be older than the time they were pushed,
+	// e.g. for branches that are merged without squashing or rebasing. Such commits are only found if they were pushed within the overlap.
+	commitCacheOverlap = 14 * 24 * time.Hour
+)
+
+type timeRange struct {
+	since time.Time
+	until time.Time
+}
+
+// commitCache holds the commits of a repository with a commit date between From and To.
+// Only the commits of the loaded months are held.
+type commitCache struct {
+	From    time.Time
+	To      time.Time
+	Commits []*github.RepositoryCommit
+
+	// months have a stored shard, loaded ones are held, and commits were added to dirty ones.
+	months map[string]bool
+	loaded map[string]bool
+	dirty  map[string]bool
+}
+
+// missing returns the time ranges that have to be fetched, so that the cache covers since until until.
+// Ranges are never extended past now.
+func (c *commitCache) missing(since, until, now time.Time) []timeRange {
+	if until.After(now) {
+		until = now
+	}
+	if !since.Before(until) {
+		return nil
+	}
+
+	if c.From.IsZero() && c.To.IsZero() {
+		return []timeRange{{since, until}}
+	}
+
+	var result []timeRange
+	if since.Before(c.From) {
+		result = append(result, timeRange{since, c.From})
+	}
+	if until.After(c.To) {
+		from := c.To.Add(-commitCacheOverlap)
+		if from.Before(c.From) {
+			from = c.From
+		}
+		result = append(result, timeRange{from, until})
+	}
+	return result
+}
+
+// add merges commits fetched for r into the cache.
+func (c *commitCache) add(r timeRange, commits []*github.RepositoryCommit) {
+	known := make(map[string]bool, len(c.Commits))
+	for _, commit := range c.Commits {
+		known[commit.GetSHA()] = true
+	}
+	for _, commit := range commits {
+		if known[commit.GetSHA()] {
+			continue
+		}
+		known[commit.GetSHA()] = true
+		c.Commits = append(c.Commits, trimCommit(commit))
+
+		if c.dirty == nil {
+			c.dirty = map[string]bool{}
+		}
+		c.dirty[commitMonth(commit)] = true
+	}
+
+	if c.From.IsZero() || r.since.Before(c.From) {
+		c.From = r.since
+	}
+	if r.until.After(c.To) {
+		c.To = r.until
+	}
+}
+
+// between returns the cached commits with a commit date between since and until, newest first.
+func (c *commitCache) between(since, until time.Time) []*github.RepositoryCommit {
+	var result []*github.RepositoryCommit
+	for _, commit := range c.Commits {
+		date := commitDate(commit)
+		if date.Before(since) || date.After(until) {
+			continue
+		}
+		result = append(result, commit)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return commitDate(result[i]).After(commitDate(result[j]))
+	})
+	return result
+}
+
+func commitDate(c *github.RepositoryCommit) time.Time {
+	return c.GetCommit().GetCommitter().GetDate()
+}
+
+// trimCommit drops every field of a commit that isn't used to render a report, to keep the cache small.
+func trimCommit(c *github.RepositoryCommit) *github.RepositoryCommit {
+	commit := c.GetCommit()
+	trimmed := &github.RepositoryCommit{
+		SHA:     c.SHA,
+		HTMLURL: c.HTMLURL,
+		Commit: &github.Commit{
+			Author:    commit.GetAuthor(),
+			Committer: commit.GetCommitter(),
+			Message:   commit.Message,
+		},
+	}
+
+	if author := c.GetAuthor(); author != nil {
+		trimmed.Author = &github.User{
+			ID:     author.ID,
+			NodeID: author.NodeID,
+			Login:  author.Login,
+			Type:   author.Type,
+		}
+	}
+	return trimmed
+}
+
+// commitCacheHash identifies a repository in the keys of its commit cache. source identifies the forge that hosts it.
+func commitCacheHash(source, owner, repo string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(source + "/" + owner + "/" + repo)))
+	return hex.EncodeToString(hash[:])
+}
+
+// getCommitCacheKey returns the KV key of the header of a repository.
+func getCommitCacheKey(source, owner, repo string) string {
+	return commitCacheKeyPrefix + commitCacheHash(source, owner, repo)[:40]
+}
+
+// getCommitShardKey returns the KV key of the commits of a repository in month, formatted with commitShardFormat.
+func getCommitShardKey(source, owner, repo, month string) string {
+	return commitShardKeyPrefix + commitCacheHash(source, owner, repo)[:32] + "_" + month
+}
+
+// commitCacheHeader is stored under the key of a repository. Its commits are stored in one shard per month,
+// so that no value grows with the history of a repository and reports only load the months they need.
+type commitCacheHeader struct {
+	From time.Time
+	To   time.Time
+	// Months are the months with a stored shard.
+	Months []string
+	// Commits are only set by caches that were stored before they were sharded.
+	Commits []*github.RepositoryCommit `json:",omitempty"`
+}
+
+func commitMonth(c *github.RepositoryCommit) string {
+	return commitDate(c).UTC().Format(commitShardFormat)
+}
+
+// getCommitCache loads the cached range of a repository and the cached commits of the months between since and until.
+// An empty cache is returned if none is stored yet.
+func (p *Plugin) getCommitCache(source, owner, repo string, since, until time.Time) *commitCache {
+	cache := &commitCache{}
+
+	data, appErr := p.API.KVGet(getCommitCacheKey(source, owner, repo))
+	if appErr != nil {
+		p.API.LogWarn("Failed to load commit cache", "repo", owner+"/"+repo, "error", appErr.Error())
+		return cache
+	}
+	if data == nil {
+		return cache
+	}
+
+	var header commitCacheHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		p.API.LogWarn("Failed to decode commit cache", "repo", owner+"/"+repo, "error", err.Error())
+		return cache
+	}
+	cache.From = header.From
+	cache.To = header.To
+	cache.months = map[string]bool{}
+	for _, month := range header.Months {
+		cache.months[month] = true
+	}
+
+	if len(header.Commits) > 0 {
+		// Shard the commits of the old format when the cache is stored the next time
+		cache.add(timeRange{header.From, header.To}, header.Commits)
+		cache.loaded = map[string]bool{}
+		for month := range cache.dirty {
+			cache.loaded[month] = true
+		}
+		return cache
+	}
+
+	first := since.UTC().Format(commitShardFormat)
+	last := until.UTC().Format(commitShardFormat)
+	for _, month := range header.Months {
+		if month >= first && month <= last {
+			if err := p.loadCommitShard(source, owner, repo, month, cache); err != nil {
+				p.API.LogWarn("Failed to load commit cache", "repo", owner+"/"+repo, "month", month, "error", err.Error())
+				return &commitCache{}
+			}
+		}
+	}
+	return cache
+}
+
+// loadCommitShard adds the stored commits of month to cache.
+func (p *Plugin) loadCommitShard(source, owner, repo, month string, cache *commitCache) error {
+	data, appErr := p.API.KVGet(getCommitShardKey(source, owner, repo, month))
+	if appErr != nil {
+		return appErr
+	}
+
+	var commits []*github.RepositoryCommit
+	if data != nil {
+		if err := json.Unmarshal(data, &commits); err != nil {
+			return err
+		}
+	}
+
+	known := make(map[string]bool, len(cache.Commits))
+	for _, commit := range cache.Commits {
+		known[commit.GetSHA()] = true
+	}
+	for _, commit := range commits {
+		if !known[commit.GetSHA()] {
+			cache.Commits = append(cache.Commits, commit)
+		}
+	}
+	if cache.loaded == nil {
+		cache.loaded = map[string]bool{}
+	}
+	cache.loaded[month] = true
+	return nil
+}
+
+// setCommitCache stores the shards of the months that commits were added to, and then the header.
+// Shards that weren't loaded are merged with the stored commits first.
+func (p *Plugin) setCommitCache(source, owner, repo string, cache *commitCache) error {
+	byMonth := map[string][]*github.RepositoryCommit{}
+	for month := range cache.dirty {
+		if !cache.loaded[month] && cache.months[month] {
+			if err := p.loadCommitShard(source, owner, repo, month, cache); err != nil {
+				return err
+			}
+		}
+	}
+	for _, commit := range cache.Commits {
+		if month := commitMonth(commit); cache.dirty[month] {
+			byMonth[month] = append(byMonth[month], commit)
+		}
+	}
+
+	header := commitCacheHeader{From: cache.From, To: cache.To}
+	for month := range cache.months {
+		header.Months = append(header.Months, month)
+	}
+	for month, commits := range byMonth {
+		data, err := json.Marshal(commits)
+		if err != nil {
+			return err
+		}
+		if appErr := p.API.KVSet(getCommitShardKey(source, owner, repo, month), data); appErr != nil {
+			return appErr
+		}
+		if !cache.months[month] {
+			header.Months = append(header.Months, month)
+		}
+	}
+	sort.Strings(header.Months)
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if appErr := p.API.KVSet(getCommitCacheKey(source, owner, repo), data); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// updateCommitCache adds commits to the cache of a repository with update, and returns the cache with the months between since and until.
+// The cache is loaded again and stored while holding the lock of the repository, so that concurrent updates on any node aren't lost.
+// If the lock can't be acquired, the commits are only added to the returned cache.
+func (p *Plugin) updateCommitCache(ctx context.Context, source, owner, repo string, since, until time.Time, update func(cache *commitCache)) *commitCache {
+	mutex, err := cluster.NewMutex(p.API, commitCacheKeyPrefix+commitCacheHash(source, owner, repo)[:32])
+	if err == nil {
+		err = mutex.LockWithContext(ctx)
+	}
+	if err != nil {
+		p.API.LogWarn("Failed to lock commit cache", "repo", owner+"/"+repo, "error", err.Error())
+		cache := p.getCommitCache(source, owner, repo, since, until)
+		update(cache)
+		return cache
+	}
+	defer mutex.Unlock()
+
+	cache := p.getCommitCache(source, owner, repo, since, until)
+	update(cache)
+	if err := p.setCommitCache(source, owner, repo, cache); err != nil {
+		p.API.LogWarn("Failed to store commit cache", "repo", owner+"/"+repo, "error", err.Error())
+	}
+	return cache
+}
+
+// fetchCachedCommits returns the commits of a repository between since and until.
+// Only commits that aren't in the commit cache yet are fetched with fetch.
+func (p *Plugin) fetchCachedCommits(ctx context.Context, source, owner, repo string, since, until time.Time, fetch func(since, until time.Time) ([]*github.RepositoryCommit, error)) ([]*github.RepositoryCommit, error) {
+	cache := p.getCommitCache(source, owner, repo, since, until)
+
+	// Caches of the old format are dirty, and get sharded even if nothing is missing
+	missing := cache.missing(since, until, time.Now())
+	if len(missing) == 0 && len(cache.dirty) == 0 {
+		return cache.between(since, until), nil
+	}
+
+	fetched := make([][]*github.RepositoryCommit, len(missing))
+	for i, r := range missing {
+		commits, err := fetch(r.since, r.until)
+		if err != nil {
+			return nil, err
+		}
+		fetched[i] = commits
+	}
+
+	cache = p.updateCommitCache(ctx, source, owner, repo, since, until, func(cache *commitCache) {
+		for i, r := range missing {
+			cache.add(r, fetched[i])
+		}
+	})
+	return cache.between(since, until), nil
+}
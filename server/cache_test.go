@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCommit(sha string, date time.Time) *github.RepositoryCommit {
+	return &github.RepositoryCommit{
+		SHA: github.String(sha),
+		Commit: &github.Commit{
+			Committer: &github.CommitAuthor{Date: &date},
+		},
+	}
+}
+
+func TestCommitCacheMissing(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, time.January, d, 0, 0, 0, 0, time.UTC)
+	}
+	now := day(31)
+
+	empty := &commitCache{}
+	assert.Equal(t, []timeRange{{day(1), day(10)}}, empty.missing(day(1), day(10), now))
+	assert.Equal(t, []timeRange{{day(20), now}}, empty.missing(day(20), day(40), now))
+	assert.Empty(t, empty.missing(day(40), day(50), now))
+
+	cache := &commitCache{From: day(10), To: day(28)}
+	assert.Empty(t, cache.missing(day(12), day(18), now))
+	assert.Equal(t, []timeRange{{day(5), day(10)}}, cache.missing(day(5), day(15), now))
+	assert.Equal(t, []timeRange{{day(14), day(30)}}, cache.missing(day(15), day(30), now))
+	assert.Equal(t, []timeRange{{day(1), day(10)}, {day(14), now}}, cache.missing(day(1), day(40), now))
+
+	// The overlap doesn't reach before the cached range
+	cache = &commitCache{From: day(10), To: day(20)}
+	assert.Equal(t, []timeRange{{day(10), day(25)}}, cache.missing(day(15), day(25), now))
+}
+
+func TestCommitCacheAdd(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, time.January, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	cache := &commitCache{}
+	cache.add(timeRange{day(10), day(20)}, []*github.RepositoryCommit{
+		newTestCommit("a", day(11)),
+		newTestCommit("b", day(15)),
+	})
+	cache.add(timeRange{day(14), day(25)}, []*github.RepositoryCommit{
+		newTestCommit("b", day(15)),
+		newTestCommit("c", day(24)),
+	})
+
+	assert.Equal(t, day(10), cache.From)
+	assert.Equal(t, day(25), cache.To)
+	assert.Len(t, cache.Commits, 3)
+
+	between := cache.between(day(12), day(24))
+	if assert.Len(t, between, 2) {
+		assert.Equal(t, "c", between[0].GetSHA())
+		assert.Equal(t, "b", between[1].GetSHA())
+	}
+}
+
+func TestFetchCachedCommits(t *testing.T) {
+	month := func(m time.Month, d int) time.Time {
+		return time.Date(2020, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	commits := []*github.RepositoryCommit{
+		newTestCommit("a", month(time.January, 10)),
+		newTestCommit("b", month(time.February, 10)),
+		newTestCommit("c", month(time.March, 10)),
+	}
+
+	api := &plugintest.API{}
+	allowLogs(api)
+	kv := newTestKVStore(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	var fetched []timeRange
+	fetch := func(since, until time.Time) ([]*github.RepositoryCommit, error) {
+		fetched = append(fetched, timeRange{since, until})
+		var result []*github.RepositoryCommit
+		for _, c := range commits {
+			if !commitDate(c).Before(since) && !commitDate(c).After(until) {
+				result = append(result, c)
+			}
+		}
+		return result, nil
+	}
+
+	result, err := p.fetchCachedCommits(context.Background(), "github.com", "org", "repo", month(time.January, 1), month(time.April, 1), fetch)
+	require.NoError(t, err)
+	assert.Len(t, result, 3)
+	assert.Len(t, fetched, 1)
+
+	// Every month is stored in its own shard
+	for _, m := range []string{"202001", "202002", "202003"} {
+		var shard []*github.RepositoryCommit
+		require.NoError(t, json.Unmarshal(kv.get(getCommitShardKey("github.com", "org", "repo", m)), &shard))
+		assert.Len(t, shard, 1, m)
+	}
+	var header commitCacheHeader
+	require.NoError(t, json.Unmarshal(kv.get(getCommitCacheKey("github.com", "org", "repo")), &header))
+	assert.Equal(t, []string{"202001", "202002", "202003"}, header.Months)
+	assert.Empty(t, header.Commits)
+
+	// Only the shards of the requested months are loaded
+	cache := p.getCommitCache("github.com", "org", "repo", month(time.February, 1), month(time.February, 20))
+	assert.Equal(t, map[string]bool{"202002": true}, cache.loaded)
+	result, err = p.fetchCachedCommits(context.Background(), "github.com", "org", "repo", month(time.February, 1), month(time.February, 20), fetch)
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	assert.Equal(t, "b", result[0].GetSHA())
+	assert.Len(t, fetched, 1)
+
+	t.Run("caches stored before they were sharded", func(t *testing.T) {
+		data, err := json.Marshal(commitCacheHeader{From: month(time.January, 1), To: month(time.April, 1), Commits: commits})
+		require.NoError(t, err)
+		kv.set(getCommitCacheKey("github.com", "org", "legacy"), data)
+
+		result, err := p.fetchCachedCommits(context.Background(), "github.com", "org", "legacy", month(time.January, 1), month(time.April, 1), fetch)
+		require.NoError(t, err)
+		assert.Len(t, result, 3)
+
+		var header commitCacheHeader
+		require.NoError(t, json.Unmarshal(kv.get(getCommitCacheKey("github.com", "org", "legacy")), &header))
+		assert.Equal(t, []string{"202001", "202002", "202003"}, header.Months)
+		assert.Empty(t, header.Commits)
+	})
+}
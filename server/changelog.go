@@ -9,6 +9,7 @@ import (
 
 	"github.com/google/go-github/v31/github"
 	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
 
 	"github.com/mattermost/mattermost-plugin-community/server/util"
 )
@@ -24,7 +25,7 @@ func (p *Plugin) executeChangelogCommand(commandArgs []string, args *model.Comma
 		}
 	}
 
-	owner, repo, err := util.ParseOwnerAndRepository(commandArgs[0])
+	target, repo, err := util.ParseOwnerAndRepository(commandArgs[0])
 	if err != nil {
 		return &model.AppError{
 			Id:         err.Error(),
@@ -42,18 +43,12 @@ func (p *Plugin) executeChangelogCommand(commandArgs []string, args *model.Comma
 		}
 	}
 
-	client, err := p.getGitHubClient(args.UserId)
-	if err != nil {
-		p.API.LogWarn("Failed to create GitHub client", "error", err.Error())
-
-		return &model.AppError{
-			Id:         "Failed to connect to GitHub.",
-			StatusCode: http.StatusBadRequest,
-			Where:      "p.ExecuteCommand",
-		}
+	forge, owner, appErr := p.getProvider(args.UserId, target)
+	if appErr != nil {
+		return appErr
 	}
 
-	org, _, err := client.Organizations.Get(context.Background(), owner)
+	avatarLogo, err := forge.avatarURL(context.Background(), owner, true)
 	if err != nil {
 		p.API.LogWarn("Failed to fetch organization", "error", err.Error())
 		return &model.AppError{
@@ -72,45 +67,44 @@ func (p *Plugin) executeChangelogCommand(commandArgs []string, args *model.Comma
 		Title:      fmt.Sprintf("Fetching changelog for %v %v", month.Month().String(), month.Year()),
 		Text:       waitText,
 		AuthorName: topic,
-		AuthorIcon: org.GetAvatarURL(),
-		AuthorLink: fmt.Sprintf("https://github.com/%v", topic),
+		AuthorIcon: avatarLogo,
+		AuthorLink: forge.webURL(topic),
 	}}
 
-	loadingPost := &model.Post{
-		ChannelId: args.ChannelId,
-		UserId:    p.botUserID,
-	}
-	model.ParseSlackAttachment(loadingPost, attachments)
-
-	loadingPost, appErr := p.API.CreatePost(loadingPost)
-	if appErr != nil {
-		return appErr
-	}
-
-	go p.updateChangelogPost(client, loadingPost, args.UserId, owner, repo, month)
-
-	return nil
+	return p.startJob(args, &job{
+		Type:   jobTypeChangelog,
+		Target: target,
+		Repo:   repo,
+		IsOrg:  true,
+		Since:  month,
+	}, attachments)
 }
 
-func (p *Plugin) updateChangelogPost(client *github.Client, post *model.Post, userID, org, repo string, month time.Time) {
+func (p *Plugin) updateChangelogPost(ctx context.Context, forge provider, post *model.Post, userID, org, repo string, month time.Time) error {
 	// Fetch commits until the end of this month
 	nextMonth := month.AddDate(0, 1, 0).Add(-time.Microsecond)
 
-	var commits []*github.RepositoryCommit
-	var err error
-	if repo != "" {
-		commits, err = p.fetchCommitsFromRepo(client, org, repo, month, nextMonth)
-	} else {
-		commits, err = p.fetchCommitsFromOrg(client, org, month, nextMonth)
+	commits, err := forge.fetchCommits(ctx, org, repo, true, month, nextMonth)
+	var staff map[string]bool
+	var coAuthored []*github.RepositoryCommit
+	if err == nil {
+		staff = p.fetchStaffForReport(ctx, forge, org, true)
+		coAuthored, err = p.fetchCoAuthoredCommits(ctx, forge, commits)
 	}
+	err = finishFetching(ctx, err)
 	if err != nil {
 		p.API.LogError("Failed to fetch data", "err", err.Error())
 
 		message := githubErrorHandle(err)
 		post.Props["attachments"].([]*model.SlackAttachment)[0].Text = message
 	} else {
+		bots := p.getBotFilter()
+		commits, filtered := bots.filterCommits(p.resolveIdentities(commits))
+		coAuthored, _ = bots.filterCommits(p.resolveIdentities(coAuthored))
+		credited, separate := p.creditCoAuthors(commits, coAuthored)
+
 		var committer []string
-		for _, c := range commits {
+		for _, c := range credited {
 			author := c.GetAuthor()
 			if author == nil {
 				continue
@@ -122,40 +116,58 @@ func (p *Plugin) updateChangelogPost(client *github.Client, post *model.Post, us
 		}
 		util.SortSlice(committer)
 
-		const userPerPost = 150
-		committerTexts := make([]string, len(committer)/userPerPost+1)
-		for i, c := range committer {
-			profile := fmt.Sprintf("[%[1]s](https://github.com/%[1]v)", c)
-			if i+1 != len(committer) {
-				profile += ", "
-			}
-			committerTexts[i/150] += profile
-		}
-
 		attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
 		attachment.Title = fmt.Sprintf("Committer list for %v %v changelog", month.Month().String(), month.Year())
 		attachment.Text = ""
 		attachment.Fields = []*model.SlackAttachmentField{{
 			Title: "Number of Committer",
 			Value: strconv.Itoa(len(committer)),
-		}, {
-			Title: "Committer",
-			Value: "```\n" + committerTexts[0] + "\n```",
 		}}
 
-		for i := 1; i < len(committerTexts); i++ {
-			attachment := *attachment
-			attachment.Title += fmt.Sprintf(" (Part %v)", i+1)
-			attachment.Fields = []*model.SlackAttachmentField{{
-				Title: "Committer",
-				Value: "```\n" + committerTexts[i] + "\n```",
-			}}
+		type section struct {
+			title  string
+			logins []string
+		}
+		sections := []section{{"Committer", committer}}
+		if staff != nil {
+			staffCommitter, communityCommitter := splitStaff(committer, staff)
+			sections = []section{{"Community", communityCommitter}, {"Staff", staffCommitter}}
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: "External contributors",
+				Value: formatRatio(len(communityCommitter), len(committer), "committers"),
+			})
+		}
+
+		attachment.Fields = append(attachment.Fields, coAuthorsField(forge, separate, nil)...)
+		attachment.Fields = append(attachment.Fields, unlinkedField(credited)...)
+		attachment.Fields = append(attachment.Fields, filtered.field("commits")...)
+
+		// Long lists are continued in additional posts
+		var additionalAttachments []*model.SlackAttachment
+		for _, s := range sections {
+			committerTexts := formatChangelogCommitters(forge, s.logins)
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: s.title,
+				Value: "```\n" + committerTexts[0] + "\n```",
+			})
+
+			for i := 1; i < len(committerTexts); i++ {
+				additionalAttachment := *attachment
+				additionalAttachment.Title += fmt.Sprintf(" (%v, part %v)", s.title, i+1)
+				additionalAttachment.Fields = []*model.SlackAttachmentField{{
+					Title: s.title,
+					Value: "```\n" + committerTexts[i] + "\n```",
+				}}
+				additionalAttachments = append(additionalAttachments, &additionalAttachment)
+			}
+		}
 
+		for _, attachment := range additionalAttachments {
 			additionalPost := &model.Post{
 				ChannelId: post.ChannelId,
 				UserId:    post.UserId,
 			}
-			model.ParseSlackAttachment(additionalPost, []*model.SlackAttachment{&attachment})
+			model.ParseSlackAttachment(additionalPost, []*model.SlackAttachment{attachment})
 
 			_, appErr := p.API.CreatePost(additionalPost)
 			if appErr != nil {
@@ -167,14 +179,34 @@ func (p *Plugin) updateChangelogPost(client *github.Client, post *model.Post, us
 	if _, appErr := p.API.UpdatePost(post); appErr != nil {
 		p.SendEphemeralPost(post.ChannelId, userID, "Something went bad. Please try again.")
 		p.API.LogError("Failed to update post", "err", appErr.Error())
-		return
+		return appErr
+	}
+
+	return err
+}
+
+// formatChangelogCommitters formats the links to the profiles of committers, split into parts of at most userPerPost.
+func formatChangelogCommitters(forge forgeInfo, committer []string) []string {
+	const userPerPost = 150
+	committerTexts := make([]string, len(committer)/userPerPost+1)
+	for i, c := range committer {
+		profile := fmt.Sprintf("[%s](%s)", c, forge.webURL(c))
+		if i+1 != len(committer) && (i+1)%userPerPost != 0 {
+			profile += ", "
+		}
+		committerTexts[i/userPerPost] += profile
 	}
+	return committerTexts
 }
 
 func githubErrorHandle(err error) string {
 	var message string
 	if _, ok := err.(*github.RateLimitError); ok {
 		message = rateLimitMessage
+	} else if errors.Cause(err) == context.Canceled {
+		message = cancelledMessage
+	} else if errors.Cause(err) == context.DeadlineExceeded {
+		message = timeoutMessage
 	} else {
 		message = "Failed to fetch data:" + err.Error()
 	}
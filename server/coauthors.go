@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	coAuthorCacheKeyPrefix = "coauthors_"
+
+	// coAuthorCacheTTL is the time the account of a co-author is kept, so that the cache doesn't keep every address ever seen.
+	coAuthorCacheTTL = 90 * 24 * time.Hour
+
+	// Values of the CoAuthors setting. Co-authors are credited, if it's empty.
+	coAuthorsCredit   = "credit"
+	coAuthorsSeparate = "separate"
+	coAuthorsIgnore   = "ignore"
+)
+
+var coAuthorTrailer = regexp.MustCompile(`(?mi)^co-authored-by:[ \t]*(.*?)[ \t]*<([^<>\s]+)>[ \t]*$`)
+
+// coAuthor is a person credited in a Co-authored-by trailer of a commit message.
+type coAuthor struct {
+	name  string
+	email string
+}
+
+// parseCoAuthors returns the co-authors in the trailers of a commit message. Every email address is returned once.
+func parseCoAuthors(message string) []coAuthor {
+	var result []coAuthor
+	seen := map[string]bool{}
+	for _, match := range coAuthorTrailer.FindAllStringSubmatch(message, -1) {
+		email := strings.ToLower(match[2])
+		if seen[email] {
+			continue
+		}
+		seen[email] = true
+		result = append(result, coAuthor{name: match[1], email: match[2]})
+	}
+	return result
+}
+
+// getCoAuthorCacheKey returns the KV key of the account of a co-author. Every email address has its own key,
+// so that concurrent reports don't overwrite what the others found.
+func getCoAuthorCacheKey(source, email string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(source + "/" + email)))
+	return coAuthorCacheKeyPrefix + hex.EncodeToString(hash[:])[:40]
+}
+
+// getCachedCoAuthor returns the cached account of a co-author, and false if the email address wasn't looked up yet.
+func (p *Plugin) getCachedCoAuthor(key string) (cachedAuthor, bool) {
+	var author cachedAuthor
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		p.API.LogWarn("Failed to load co-author cache", "error", appErr.Error())
+		return author, false
+	}
+	if data == nil {
+		return author, false
+	}
+	if err := json.Unmarshal(data, &author); err != nil {
+		p.API.LogWarn("Failed to decode co-author cache", "error", err.Error())
+		return author, false
+	}
+	return author, true
+}
+
+// setCachedCoAuthor caches the account of a co-author. Addresses without an account are looked up again after unresolvedAuthorTTL.
+func (p *Plugin) setCachedCoAuthor(key string, author cachedAuthor) {
+	data, err := json.Marshal(author)
+	if err != nil {
+		p.API.LogWarn("Failed to encode co-author cache", "error", err.Error())
+		return
+	}
+
+	ttl := coAuthorCacheTTL
+	if author.Login == "" {
+		ttl = unresolvedAuthorTTL
+	}
+	if _, appErr := p.API.KVSetWithOptions(key, data, model.PluginKVSetOptions{
+		ExpireInSeconds: int64(ttl / time.Second),
+	}); appErr != nil {
+		p.API.LogWarn("Failed to store co-author cache", "error", appErr.Error())
+	}
+}
+
+func (p *Plugin) getCoAuthorsMode() string {
+	mode := p.getConfiguration().CoAuthors
+	if mode == "" {
+		return coAuthorsCredit
+	}
+	return mode
+}
+
+// fetchCoAuthoredCommits returns a copy of every commit per co-author, with the co-author as author.
+// Co-authors are looked up by their email address, and cached for coAuthorCacheTTL.
+// Co-authors that can't be found are kept as unlinked authors, so that they can still be linked with the mailmap.
+// No commits are returned if co-authors are ignored. An error is only returned if ctx is done.
+func (p *Plugin) fetchCoAuthoredCommits(ctx context.Context, forge provider, commits []*github.RepositoryCommit) ([]*github.RepositoryCommit, error) {
+	if p.getCoAuthorsMode() == coAuthorsIgnore {
+		return nil, nil
+	}
+
+	type coAuthoredCommit struct {
+		commit   *github.RepositoryCommit
+		coAuthor coAuthor
+	}
+	var coAuthored []coAuthoredCommit
+	for _, c := range commits {
+		authorEmail := strings.ToLower(c.GetCommit().GetAuthor().GetEmail())
+		for _, a := range parseCoAuthors(c.GetCommit().GetMessage()) {
+			if strings.ToLower(a.email) != authorEmail {
+				coAuthored = append(coAuthored, coAuthoredCommit{c, a})
+			}
+		}
+	}
+	if len(coAuthored) == 0 {
+		return nil, nil
+	}
+
+	// authors are the co-authors of this report, so that every email address is loaded or looked up once
+	authors := map[string]cachedAuthor{}
+	var result []*github.RepositoryCommit
+	for _, e := range coAuthored {
+		email := strings.ToLower(e.coAuthor.email)
+
+		author, ok := authors[email]
+		if !ok {
+			key := getCoAuthorCacheKey(forge.id(), email)
+			author, ok = p.getCachedCoAuthor(key)
+			if !ok {
+				user, err := forge.lookupUserByEmail(ctx, email)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
+					}
+					p.API.LogWarn("Failed to resolve co-author", "sha", e.commit.GetSHA(), "error", err.Error())
+					continue
+				}
+
+				author = cachedAuthor{CheckedAt: time.Now()}
+				if user != nil {
+					author.ID = user.GetID()
+					author.Login = user.GetLogin()
+				}
+				p.setCachedCoAuthor(key, author)
+			}
+			authors[email] = author
+		}
+
+		commit := *e.commit.GetCommit()
+		commit.Author = &github.CommitAuthor{
+			Name:  github.String(e.coAuthor.name),
+			Email: github.String(e.coAuthor.email),
+			Date:  commit.GetAuthor().Date,
+		}
+		c := *e.commit
+		c.Commit = &commit
+		c.Author = nil
+		if author.Login != "" {
+			if strings.EqualFold(author.Login, e.commit.GetAuthor().GetLogin()) {
+				continue
+			}
+			c.Author = &github.User{Login: github.String(author.Login)}
+			if author.ID != 0 {
+				c.Author.ID = github.Int64(author.ID)
+			}
+		}
+		result = append(result, &c)
+	}
+	return result, nil
+}
+
+// creditCoAuthors adds co-authored commits to commits, if co-authors are credited like authors.
+// Otherwise, they are returned separately, to be listed on their own.
+func (p *Plugin) creditCoAuthors(commits, coAuthored []*github.RepositoryCommit) (credited, separate []*github.RepositoryCommit) {
+	if p.getCoAuthorsMode() == coAuthorsSeparate {
+		return commits, coAuthored
+	}
+
+	credited = make([]*github.RepositoryCommit, 0, len(commits)+len(coAuthored))
+	credited = append(credited, commits...)
+	return append(credited, coAuthored...), nil
+}
+
+// coAuthorsField returns the attachment fields that list co-authors separately from committers.
+// No field is returned if there are none. stats are the stats of the co-authored commits, if the report has them.
+func coAuthorsField(forge forgeInfo, coAuthored []*github.RepositoryCommit, stats map[string]commitStats) []*model.SlackAttachmentField {
+	coAuthors := countCommitterStats(coAuthored, stats, measureCommits)
+	if len(coAuthors) == 0 {
+		return nil
+	}
+	return []*model.SlackAttachmentField{{
+		Title: "Co-authors",
+		Value: formatCommitterStats(forge, coAuthors),
+	}}
+}
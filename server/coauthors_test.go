@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testProvider finds users by email, and commit stats by SHA, in maps. Every method that isn't overridden panics.
+type testProvider struct {
+	provider
+	usersByEmail map[string]*github.User
+	stats        map[string]commitStats
+}
+
+func (t *testProvider) id() string {
+	return "forge.example.com"
+}
+
+func (t *testProvider) webURL(path string) string {
+	return "https://forge.example.com/" + path
+}
+
+func (t *testProvider) lookupUserByEmail(ctx context.Context, email string) (*github.User, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return t.usersByEmail[email], nil
+}
+
+func TestParseCoAuthors(t *testing.T) {
+	message := `Add feature (#123)
+
+Co-authored-by: Jane Doe <jane@example.com>
+co-authored-by:John <john@example.com>
+Co-authored-by: Jane Doe <JANE@example.com>
+Not a trailer Co-authored-by: Someone <someone@example.com>`
+
+	assert.Equal(t, []coAuthor{
+		{name: "Jane Doe", email: "jane@example.com"},
+		{name: "John", email: "john@example.com"},
+	}, parseCoAuthors(message))
+	assert.Empty(t, parseCoAuthors("Fix typo"))
+}
+
+func TestGitHubNoReplyEmail(t *testing.T) {
+	assert.Equal(t, "jane", gitHubNoReplyEmail.FindStringSubmatch("12345+jane@users.noreply.github.com")[1])
+	assert.Equal(t, "jane", gitHubNoReplyEmail.FindStringSubmatch("jane@users.noreply.github.com")[1])
+	assert.Nil(t, gitHubNoReplyEmail.FindStringSubmatch("jane@example.com"))
+}
+
+func TestFetchCoAuthoredCommits(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	kv := newTestKVStore(api)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	forge := &testProvider{usersByEmail: map[string]*github.User{
+		"jane@example.com":  {Login: github.String("jane")},
+		"alice@example.com": {Login: github.String("alice")},
+	}}
+
+	commit := &github.RepositoryCommit{
+		SHA:    github.String("abc"),
+		Author: &github.User{Login: github.String("alice")},
+		Commit: &github.Commit{
+			Author: &github.CommitAuthor{Name: github.String("Alice"), Email: github.String("alice@work.example.com")},
+			Message: github.String("Pair on feature\n\n" +
+				"Co-authored-by: Jane <jane@example.com>\n" +
+				"Co-authored-by: Alice <alice@example.com>\n" +
+				"Co-authored-by: Unknown <unknown@example.com>"),
+		},
+	}
+
+	coAuthored, err := p.fetchCoAuthoredCommits(context.Background(), forge, []*github.RepositoryCommit{commit})
+	require.NoError(t, err)
+
+	// Alice is the author of the commit already
+	require.Len(t, coAuthored, 2)
+	assert.Equal(t, "jane", coAuthored[0].GetAuthor().GetLogin())
+	assert.Equal(t, "abc", coAuthored[0].GetSHA())
+	assert.Nil(t, coAuthored[1].GetAuthor())
+	assert.Equal(t, "unknown@example.com", coAuthored[1].GetCommit().GetAuthor().GetEmail())
+	// The original commit isn't changed
+	assert.Equal(t, "alice", commit.GetAuthor().GetLogin())
+	assert.Equal(t, "Alice", commit.GetCommit().GetAuthor().GetName())
+
+	// Every email address is cached on its own, addresses without an account as well
+	var cached cachedAuthor
+	require.NoError(t, json.Unmarshal(kv.get(getCoAuthorCacheKey("forge.example.com", "jane@example.com")), &cached))
+	assert.Equal(t, "jane", cached.Login)
+	require.NoError(t, json.Unmarshal(kv.get(getCoAuthorCacheKey("forge.example.com", "unknown@example.com")), &cached))
+	assert.Empty(t, cached.Login)
+
+	// Cached co-authors aren't looked up again
+	forge.usersByEmail = nil
+	coAuthored, err = p.fetchCoAuthoredCommits(context.Background(), forge, []*github.RepositoryCommit{commit})
+	require.NoError(t, err)
+	require.Len(t, coAuthored, 2)
+	assert.Equal(t, "jane", coAuthored[0].GetAuthor().GetLogin())
+
+	// Cancelled reports don't get partial results
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	newCommit := *commit
+	newCommit.Commit = &github.Commit{Message: github.String("Co-authored-by: Bob <bob@example.com>")}
+	_, err = p.fetchCoAuthoredCommits(ctx, forge, []*github.RepositoryCommit{&newCommit})
+	assert.Equal(t, context.Canceled, err)
+
+	p.setConfiguration(&configuration{CoAuthors: coAuthorsSeparate})
+	credited, separate := p.creditCoAuthors([]*github.RepositoryCommit{commit}, coAuthored)
+	assert.Len(t, credited, 1)
+	assert.Len(t, separate, 2)
+
+	p.setConfiguration(&configuration{CoAuthors: coAuthorsIgnore})
+	coAuthored, err = p.fetchCoAuthoredCommits(context.Background(), forge, []*github.RepositoryCommit{commit})
+	require.NoError(t, err)
+	assert.Empty(t, coAuthored)
+}
+
+func TestRepoFromCommitURL(t *testing.T) {
+	forge := &testProvider{}
+	assert.Equal(t, "server", repoFromCommitURL(forge, "mattermost", "https://forge.example.com/mattermost/server/commit/abc"))
+	assert.Equal(t, "server", repoFromCommitURL(forge, "Mattermost", "https://forge.example.com/mattermost/server/commit/abc"))
+	assert.Equal(t, "sub/server", repoFromCommitURL(forge, "mattermost", "https://forge.example.com/mattermost/sub/server/-/commit/abc"))
+	assert.Equal(t, "", repoFromCommitURL(forge, "other", "https://forge.example.com/mattermost/server/commit/abc"))
+}
+
+// coAuthorshipTestProvider knows which co-authors contributed before. Commits aren't fetched, they are passed in.
+type coAuthorshipTestProvider struct {
+	testProvider
+	earlier map[string]bool
+}
+
+func (c *coAuthorshipTestProvider) listRepositories(_ context.Context, _ string, _ bool) ([]string, error) {
+	return []string{"server"}, nil
+}
+
+func (c *coAuthorshipTestProvider) contributedBefore(_ context.Context, _ string, _, _ []string, _ time.Time) (map[string]bool, error) {
+	return c.earlier, nil
+}
+
+func TestFindFirstCoAuthorships(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	newTestKVStore(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	since := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	coAuthored := func(sha, login string, day int) *github.RepositoryCommit {
+		date := since.AddDate(0, 0, day)
+		return &github.RepositoryCommit{
+			SHA:     github.String(sha),
+			HTMLURL: github.String("https://forge.example.com/org/server/commit/" + sha),
+			Author:  &github.User{Login: github.String(login)},
+			Commit:  &github.Commit{Committer: &github.CommitAuthor{Date: &date}},
+		}
+	}
+
+	forge := &coAuthorshipTestProvider{earlier: map[string]bool{"veteran": true}}
+	first, err := p.findFirstCoAuthorships(context.Background(), forge, "org", since, []*github.RepositoryCommit{
+		coAuthored("b", "jane", 3),
+		coAuthored("a", "jane", 2),
+		coAuthored("c", "veteran", 1),
+		coAuthored("d", "known", 1),
+	}, map[string]firstContributionInfo{"known": {}})
+	require.NoError(t, err)
+
+	require.Len(t, first, 1)
+	assert.Equal(t, "jane", first[0].author)
+	assert.Equal(t, "server", first[0].repo)
+	assert.Equal(t, "https://forge.example.com/org/server/commit/a", first[0].commit)
+}
@@ -29,8 +29,34 @@ func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*mo
 		appErr = p.executeChangelogCommand(commandArgs, args)
 	case "hackfest":
 		appErr = p.executeHackfestCommand(commandArgs, args)
+	case "prs":
+		appErr = p.executePullRequestsCommand(commandArgs, args)
+	case "issues":
+		appErr = p.executeIssuesCommand(commandArgs, args)
+	case "reviewers":
+		appErr = p.executeReviewersCommand(commandArgs, args)
+	case "response":
+		appErr = p.executeResponseTimeCommand(commandArgs, args)
 	case "new-committer":
 		appErr = p.executeNewCommitterCommand(commandArgs, args)
+	case "contributor":
+		appErr = p.executeContributorCommand(commandArgs, args)
+	case "retention":
+		appErr = p.executeRetentionCommand(commandArgs, args)
+	case "cancel":
+		appErr = p.executeCancelCommand(commandArgs, args)
+	case "jobs":
+		appErr = p.executeJobsCommand(commandArgs, args)
+	case "schedule":
+		appErr = p.executeScheduleCommand(commandArgs, args)
+	case "digest":
+		appErr = p.executeDigestCommand(commandArgs, args)
+	case "mailmap":
+		appErr = p.executeMailmapCommand(commandArgs, args)
+	case "subscribe":
+		appErr = p.executeSubscribeCommand(commandArgs, args)
+	case "unsubscribe":
+		appErr = p.executeUnsubscribeCommand(commandArgs, args)
 	default:
 		return nil, &model.AppError{
 			Id:         fmt.Sprintf("Unknown command %v", command),
@@ -53,7 +79,7 @@ func getCommand() *model.Command {
 		DisplayName:      "Community",
 		Description:      "Do community stuff",
 		AutoComplete:     true,
-		AutoCompleteDesc: "Available commands: committer, changelog, hackfest, new-committer",
+		AutoCompleteDesc: "Available commands: committer, changelog, prs, issues, reviewers, response, hackfest, new-committer, contributor, retention, cancel, jobs, schedule, digest, subscribe, unsubscribe, mailmap",
 		AutoCompleteHint: "[command]",
 	}
 }
@@ -4,8 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v31/github"
@@ -16,7 +16,28 @@ import (
 
 const shortFormWithDay = "2006-01-02"
 
+// executeCommitterCommand handles /community committer [organization or user]/[repo] [since] [until] [measure].
+// The leaderboard is sorted by the measure, which defaults to the number of commits.
 func (p *Plugin) executeCommitterCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	measure := measureCommits
+	if len(commandArgs) == 4 {
+		measure = strings.ToLower(commandArgs[3])
+		if !util.Contains(committerMeasures, measure) {
+			return &model.AppError{
+				Id:         fmt.Sprintf("Can't sort by %v. Use one of %v.", commandArgs[3], strings.Join(committerMeasures, ", ")),
+				StatusCode: http.StatusBadRequest,
+				Where:      "p.ExecuteCommand",
+			}
+		}
+		commandArgs = commandArgs[:3]
+	}
+
+	return p.startRangeJob(commandArgs, args, &job{Type: jobTypeCommitter, Sort: measure}, "Fetching committer stats")
+}
+
+// startRangeJob parses the arguments [organization or user]/[repo] [since] [until] of a report and starts j as job.
+// j has its type and report specific parameters set already. The title of the loading post is followed by the time range.
+func (p *Plugin) startRangeJob(commandArgs []string, args *model.CommandArgs, j *job, title string) *model.AppError {
 	if len(commandArgs) != 3 {
 		return &model.AppError{
 			Id:         "Need three arguments",
@@ -25,7 +46,7 @@ func (p *Plugin) executeCommitterCommand(commandArgs []string, args *model.Comma
 		}
 	}
 
-	owner, repo, err := util.ParseOwnerAndRepository(commandArgs[0])
+	target, repo, err := util.ParseOwnerAndRepository(commandArgs[0])
 	if err != nil {
 		return &model.AppError{
 			Id:         err.Error(),
@@ -52,18 +73,12 @@ func (p *Plugin) executeCommitterCommand(commandArgs []string, args *model.Comma
 		}
 	}
 
-	client, err := p.getGitHubClient(args.UserId)
-	if err != nil {
-		p.API.LogWarn("Failed to create GitHub client", "error", err.Error())
-
-		return &model.AppError{
-			Id:         "Failed to connect to GitHub.",
-			StatusCode: http.StatusBadRequest,
-			Where:      "p.ExecuteCommand",
-		}
+	forge, owner, appErr := p.getProvider(args.UserId, target)
+	if appErr != nil {
+		return appErr
 	}
 
-	isOrg, err := p.verifyOrg(client, owner)
+	isOrg, err := forge.verifyOwner(context.Background(), owner)
 	if err != nil {
 		return &model.AppError{
 			Id:         "Failed to fetch data",
@@ -77,91 +92,68 @@ func (p *Plugin) executeCommitterCommand(commandArgs []string, args *model.Comma
 		topic += "/" + repo
 	}
 
-	avatarLogo, err := p.GetAvatarLogo(client, owner, isOrg)
+	avatarLogo, err := forge.avatarURL(context.Background(), owner, isOrg)
 	if err != nil {
 		avatarLogo = ""
 		p.API.LogError(err.Error())
 	}
 
 	attachments := []*model.SlackAttachment{{
-		Title:      "Fetching committer stats between " + since.Format(shortFormWithDay) + " and " + until.Format(shortFormWithDay),
+		Title:      title + " between " + since.Format(shortFormWithDay) + " and " + until.Format(shortFormWithDay),
 		Text:       waitText,
 		AuthorName: topic,
 		AuthorIcon: avatarLogo,
-		AuthorLink: fmt.Sprintf("https://github.com/%v", topic),
+		AuthorLink: forge.webURL(topic),
 	}}
 
-	loadingPost := &model.Post{
-		ChannelId: args.ChannelId,
-		UserId:    p.botUserID,
-	}
-	model.ParseSlackAttachment(loadingPost, attachments)
-
-	loadingPost, appErr := p.API.CreatePost(loadingPost)
-	if appErr != nil {
-		return appErr
-	}
-
-	go p.updateCommittersPost(client, loadingPost, args.UserId, owner, repo, isOrg, since, until)
-
-	return nil
+	j.Target = target
+	j.Repo = repo
+	j.IsOrg = isOrg
+	j.Since = since
+	j.Until = until
+	return p.startJob(args, j, attachments)
 }
 
-func (p *Plugin) updateCommittersPost(client *github.Client, post *model.Post, userID, org, repo string, isOrg bool, since, until time.Time) {
+func (p *Plugin) updateCommittersPost(ctx context.Context, forge provider, post *model.Post, userID, org, repo string, isOrg bool, since, until time.Time, measure string) error {
 	// Fetch commits until one day after at midnight
 	fetchUntil := until.AddDate(0, 0, 1).Add(-time.Microsecond)
-
-	var commits []*github.RepositoryCommit
-	var err error
-
-	switch {
-	case repo != "":
-		commits, err = p.fetchCommitsFromRepo(client, org, repo, since, fetchUntil)
-	case isOrg:
-		commits, err = p.fetchCommitsFromOrg(client, org, since, fetchUntil)
-	case !isOrg:
-		commits, err = p.fetchCommitsFromUser(client, org, since, fetchUntil)
+	if measure == "" {
+		measure = measureCommits
 	}
 
+	commits, err := forge.fetchCommits(ctx, org, repo, isOrg, since, fetchUntil)
+	var staff map[string]bool
+	var coAuthored []*github.RepositoryCommit
+	var stats map[string]commitStats
+	var missingStats int
+	if err == nil {
+		staff = p.fetchStaffForReport(ctx, forge, org, isOrg)
+		coAuthored, err = p.fetchCoAuthoredCommits(ctx, forge, commits)
+	}
+	// Stats take a request per commit on GitHub, so they are only fetched for leaderboards sorted by them
+	if err == nil && needsCommitStats(measure) {
+		stats, missingStats, err = p.fetchCommitStats(ctx, forge, org, commits)
+	}
+	err = finishFetching(ctx, err)
 	if err != nil {
 		p.API.LogError("failed to fetch data", "err", err.Error())
 
 		message := githubErrorHandle(err)
 		post.Props["attachments"].([]*model.SlackAttachment)[0].Text = message
 	} else {
-		committer := map[string]int{}
-		for _, c := range commits {
-			author := c.GetAuthor()
-			if author == nil {
-				continue
-			}
-			u := author.GetLogin()
-			committer[u]++
-		}
-
-		type kv struct {
-			Key   string
-			Value int
-		}
-
-		var ss []kv
-		for k, v := range committer {
-			ss = append(ss, kv{k, v})
-		}
-
-		sort.Slice(ss, func(i, j int) bool {
-			return ss[i].Value > ss[j].Value
-		})
-
-		var committerText string
-		for _, e := range ss {
-			var c string
-			if e.Value > 1 {
-				c = "commits"
-			} else {
-				c = "commit"
-			}
-			committerText += fmt.Sprintf("- [%[1]s](https://github.com/%[1]v): %v %v\n", e.Key, e.Value, c)
+		bots := p.getBotFilter()
+		commits, filtered := bots.filterCommits(p.resolveIdentities(commits))
+		coAuthored, _ = bots.filterCommits(p.resolveIdentities(coAuthored))
+		credited, separate := p.creditCoAuthors(commits, coAuthored)
+		committers := countCommitterStats(credited, stats, measure)
+
+		var sortedBy string
+		switch measure {
+		case measureCommits:
+		case measureFiles:
+			sortedBy = " by file changes"
+		default:
+			sortedBy = " by " + measure
 		}
 
 		attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
@@ -172,26 +164,85 @@ func (p *Plugin) updateCommittersPost(client *github.Client, post *model.Post, u
 			Value: strconv.Itoa(len(commits)),
 		}, {
 			Title: "Number of Committer",
-			Value: strconv.Itoa(len(committer)),
-		}, {
-			Title: "Committer",
-			Value: committerText,
+			Value: strconv.Itoa(len(committers)),
 		}}
+		if needsCommitStats(measure) {
+			linesChanged := formatLinesChanged(sumCommitStats(commits, stats))
+			if missingStats > 0 {
+				linesChanged += fmt.Sprintf("\nStats of %v commits couldn't be fetched.", missingStats)
+			}
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: "Lines changed",
+				Value: linesChanged,
+			})
+		}
+
+		if staff == nil {
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: "Committer" + sortedBy,
+				Value: formatCommitterStats(forge, committers),
+			})
+		} else {
+			staffCommitters, communityCommitters := splitCommitters(committers, staff)
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: "External contributions",
+				Value: formatRatio(sumCommits(communityCommitters), sumCommits(committers), "commits"),
+			}, &model.SlackAttachmentField{
+				Title: "Community" + sortedBy,
+				Value: formatCommitterStats(forge, communityCommitters),
+			}, &model.SlackAttachmentField{
+				Title: "Staff" + sortedBy,
+				Value: formatCommitterStats(forge, staffCommitters),
+			})
+		}
+		attachment.Fields = append(attachment.Fields, coAuthorsField(forge, separate, stats)...)
+		attachment.Fields = append(attachment.Fields, unlinkedField(credited)...)
+		attachment.Fields = append(attachment.Fields, filtered.field("commits")...)
 	}
 
 	if _, appErr := p.API.UpdatePost(post); appErr != nil {
 		p.SendEphemeralPost(post.ChannelId, userID, "Something went bad. Please try again.")
 		p.API.LogError("failed to update post", "err", appErr.Error())
-		return
+		return appErr
+	}
+
+	return err
+}
+
+type committerCount struct {
+	login   string
+	commits int
+	// changed are the lines and files changed by the commits. hasStats is set if the stats of any of them are known.
+	changed  commitStats
+	hasStats bool
+}
+
+// splitCommitters splits committers into staff and community. The order of committers is kept.
+func splitCommitters(committers []committerCount, staff map[string]bool) (staffCommitters, communityCommitters []committerCount) {
+	for _, c := range committers {
+		if staff[strings.ToLower(c.login)] {
+			staffCommitters = append(staffCommitters, c)
+		} else {
+			communityCommitters = append(communityCommitters, c)
+		}
+	}
+	return staffCommitters, communityCommitters
+}
+
+func sumCommits(committers []committerCount) int {
+	var sum int
+	for _, c := range committers {
+		sum += c.commits
 	}
+	return sum
 }
 
-func (p *Plugin) verifyOrg(client *github.Client, owner string) (bool, error) {
-	_, _, err := client.Organizations.Get(context.Background(), owner)
+func (p *Plugin) verifyOrg(ctx context.Context, client *github.Client, owner string) (bool, error) {
+	_, _, err := client.Organizations.Get(ctx, owner)
 	if err == nil {
 		return true, nil
 	}
-	_, _, err = client.Users.Get(context.Background(), owner)
+	_, _, err = client.Users.Get(ctx, owner)
 	if err == nil {
 		return false, nil
 	}
@@ -200,16 +251,16 @@ func (p *Plugin) verifyOrg(client *github.Client, owner string) (bool, error) {
 }
 
 // GetAvatarLogo fetches the AvatarLogo from respective Github Organization or User
-func (p *Plugin) GetAvatarLogo(client *github.Client, owner string, isOrg bool) (string, error) {
+func (p *Plugin) GetAvatarLogo(ctx context.Context, client *github.Client, owner string, isOrg bool) (string, error) {
 	if isOrg {
-		org, _, err := client.Organizations.Get(context.Background(), owner)
+		org, _, err := client.Organizations.Get(ctx, owner)
 		if err != nil {
 			return "", fmt.Errorf("unable to find GitHub Organization with matching owner: %s", owner)
 		}
 		return org.GetAvatarURL(), nil
 	}
 
-	user, _, err := client.Users.Get(context.Background(), owner)
+	user, _, err := client.Users.Get(ctx, owner)
 	if err != nil {
 		return "", fmt.Errorf("unable to find GitHub User with matching owner: %s", owner)
 	}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"reflect"
+	"regexp"
 
 	"github.com/pkg/errors"
 )
@@ -19,12 +20,37 @@ import (
 // copy appropriate for your types.
 type configuration struct {
 	Token                string
+	GitHubAPIURL         string
+	GitHubUploadURL      string
+	GitHubWebURL         string
 	HackfestStart        string
 	HackfestEnd          string
 	HackfestOrg          string
 	HackfestRepo         string
 	HackfestExcludeTeams string
 	HackfestExcludeUsers string
+
+	StaffTeams string
+
+	DefaultOrganization string
+
+	BotLoginPatterns string
+
+	CoAuthors string
+
+	MaxConcurrentRequests int
+	JobTimeout            int
+
+	GitLabURL   string
+	GitLabToken string
+
+	LocalGitDirectory    string
+	LocalGitRepositories string
+
+	WebhookSecret string
+
+	// botPatterns are the compiled BotLoginPatterns.
+	botPatterns []*regexp.Regexp
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -84,7 +110,19 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	botPatterns, err := parseBotPatterns(configuration.BotLoginPatterns)
+	if err != nil {
+		return err
+	}
+	configuration.botPatterns = botPatterns
+
 	p.setConfiguration(configuration)
 
+	if p.scheduler == nil {
+		p.scheduler = newScheduler(configuration.MaxConcurrentRequests, p.API.LogInfo)
+	} else {
+		p.scheduler.setWorkers(configuration.MaxConcurrentRequests)
+	}
+
 	return nil
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cadences are the names that can be used instead of a cron expression.
+var cadences = map[string]string{
+	"daily":   "0 0 * * *",
+	"weekly":  "0 0 * * 1",
+	"monthly": "0 0 1 * *",
+}
+
+// cronSchedule is a parsed cron expression with the fields minute, hour, day of month, month and day of week.
+// Every field supports *, numbers, ranges, lists and steps, e.g. */15 or 1-5,7. Times are evaluated in UTC.
+type cronSchedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+
+	// If both days and weekdays are restricted, a time matches if either of them matches. Like in cron, a field that
+	// starts with *, e.g. */2, doesn't count as restricted, and a time then has to match both fields.
+	anyDay     bool
+	anyWeekday bool
+}
+
+// parseCron parses a cron expression or one of the cadences.
+func parseCron(expr string) (*cronSchedule, error) {
+	if cadence, ok := cadences[strings.ToLower(expr)]; ok {
+		expr = cadence
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron expression %q needs five fields", expr)
+	}
+
+	c := &cronSchedule{
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}
+
+	var err error
+	if c.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, errors.Wrap(err, "invalid minute")
+	}
+	if c.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, errors.Wrap(err, "invalid hour")
+	}
+	if c.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, errors.Wrap(err, "invalid day of month")
+	}
+	if c.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, errors.Wrap(err, "invalid month")
+	}
+	if c.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, errors.Wrap(err, "invalid day of week")
+	}
+	// Both 0 and 7 are Sunday
+	if c.weekdays&(1<<7) != 0 {
+		c.weekdays |= 1
+	}
+
+	if c.next(time.Now()).IsZero() {
+		return nil, errors.Errorf("cron expression %q never matches", expr)
+	}
+	return c, nil
+}
+
+// parseCronField returns the values of a field as a bitset.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var result uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid range %q", part)
+			}
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, errors.Errorf("invalid range %q", part)
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, errors.Errorf("invalid value %q", part)
+			}
+			start, end = value, value
+		}
+
+		if start < min || end > max || start > end {
+			return 0, errors.Errorf("%q is out of range %v-%v", part, min, max)
+		}
+		for value := start; value <= end; value += step {
+			result |= 1 << uint(value)
+		}
+	}
+	return result, nil
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// next returns the first time after after that matches the schedule, or the zero time if there is none within five years.
+func (c *cronSchedule) next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.hours&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// prev returns the last time before before that matches the schedule, or the zero time if there is none within five years.
+func (c *cronSchedule) prev(before time.Time) time.Time {
+	for _, window := range []time.Duration{24 * time.Hour, 32 * 24 * time.Hour, 367 * 24 * time.Hour, 5 * 367 * 24 * time.Hour} {
+		var result time.Time
+		for t := c.next(before.Add(-window)); !t.IsZero() && t.Before(before); t = c.next(t) {
+			result = t
+		}
+		if !result.IsZero() {
+			return result
+		}
+	}
+	return time.Time{}
+}
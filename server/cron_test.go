@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"daily", "Weekly", "monthly", "*/15 9-17 * * 1-5", "0 0 1,15 * *", "30 6 * * 7"} {
+		_, err := parseCron(expr)
+		assert.NoError(t, err, expr)
+	}
+
+	for _, expr := range []string{"", "yearly", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 *"} {
+		_, err := parseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	now := time.Date(2020, 1, 31, 10, 20, 30, 0, time.UTC) // A Friday
+
+	tcs := []struct {
+		Expr     string
+		Expected time.Time
+	}{
+		{Expr: "daily", Expected: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{Expr: "weekly", Expected: time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC)},
+		{Expr: "monthly", Expected: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{Expr: "*/15 * * * *", Expected: time.Date(2020, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{Expr: "0 9 * * 1-5", Expected: time.Date(2020, 2, 3, 9, 0, 0, 0, time.UTC)},
+		{Expr: "0 0 29 2 *", Expected: time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{Expr: "0 0 31 * *", Expected: time.Date(2020, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{Expr: "0 12 13 * 5", Expected: time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC)},
+		// Steps of every day don't restrict the days, so the day has to match the weekday, too
+		{Expr: "0 0 */2 * 1", Expected: time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tcs {
+		c, err := parseCron(tc.Expr)
+		require.NoError(t, err, tc.Expr)
+		assert.Equal(t, tc.Expected, c.next(now), tc.Expr)
+	}
+}
+
+func TestCronSchedulePrev(t *testing.T) {
+	c, err := parseCron("monthly")
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), c.prev(time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), c.prev(time.Date(2020, 2, 1, 0, 1, 0, 0, time.UTC)))
+
+	c, err = parseCron("0 0 29 2 *")
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Date(2016, 2, 29, 0, 0, 0, 0, time.UTC), c.prev(time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)))
+}
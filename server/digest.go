@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-community/server/util"
+)
+
+const digestKeyPrefix = "digest_"
+
+const (
+	digestSectionCommitters         = "committers"
+	digestSectionNewCommitters      = "new-committers"
+	digestSectionFirstContributions = "first-contributions"
+)
+
+var digestSections = []string{digestSectionCommitters, digestSectionNewCommitters, digestSectionFirstContributions}
+
+// digest is the weekly summary of the contributions to an organization, posted to a channel.
+// Every channel has at most one digest.
+type digest struct {
+	ChannelID string
+	UserID    string
+	// Target is the organization, including the prefix of its forge.
+	Target string
+	// Repos limits the digest to some repositories of the organization. If it's empty, all repositories are included.
+	Repos     []string
+	Weekday   time.Weekday
+	Sections  []string
+	Paused    bool
+	UpdatedAt time.Time
+	LastRunAt time.Time
+	NextRunAt time.Time
+}
+
+func (d *digest) topic() string {
+	if len(d.Repos) == 0 {
+		return d.Target
+	}
+	return d.Target + "/" + strings.Join(d.Repos, ",")
+}
+
+// cadence returns the cron expression of the digest. Digests are posted at midnight UTC.
+func (d *digest) cadence() string {
+	return fmt.Sprintf("0 0 * * %d", d.Weekday)
+}
+
+func (p *Plugin) getDigest(channelID string) (*digest, error) {
+	data, appErr := p.API.KVGet(digestKeyPrefix + channelID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to load digest")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	d := &digest{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, errors.Wrap(err, "failed to decode digest")
+	}
+	return d, nil
+}
+
+func (p *Plugin) saveDigest(d *digest) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode digest")
+	}
+
+	if appErr := p.API.KVSet(digestKeyPrefix+d.ChannelID, data); appErr != nil {
+		return errors.Wrap(appErr, "failed to store digest")
+	}
+	return p.addToIndex(digestKeyPrefix, d.ChannelID)
+}
+
+// runDigests starts the digests that are due. Like schedules, a missed digest is posted once, for the week before it was due.
+func (p *Plugin) runDigests() {
+	channelIDs, err := p.getIndex(digestKeyPrefix)
+	if err != nil {
+		p.API.LogError("Failed to list digests", "error", err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, channelID := range channelIDs {
+		d, err := p.getDigest(channelID)
+		if err != nil {
+			p.API.LogWarn("Failed to load digest", "channel", channelID, "error", err.Error())
+			continue
+		}
+		if d == nil || d.Paused || d.NextRunAt.After(now) {
+			continue
+		}
+
+		c, err := parseCron(d.cadence())
+		if err != nil {
+			p.API.LogError("Invalid digest cadence", "channel", channelID, "error", err.Error())
+			continue
+		}
+
+		runAt := d.NextRunAt
+		d.LastRunAt = runAt
+		d.NextRunAt = c.next(now)
+		if err = p.saveDigest(d); err != nil {
+			p.API.LogError("Failed to update digest", "channel", channelID, "error", err.Error())
+			continue
+		}
+
+		if err = p.runDigest(d, runAt); err != nil {
+			p.API.LogError("Failed to run digest", "channel", channelID, "error", err.Error())
+		}
+	}
+}
+
+// runDigest queues the digest job for the week before runAt.
+func (p *Plugin) runDigest(d *digest, runAt time.Time) error {
+	until := truncateToDay(runAt.AddDate(0, 0, -1))
+	since := until.AddDate(0, 0, -6)
+
+	forge, owner, appErr := p.getProvider(d.UserID, d.Target)
+	if appErr != nil {
+		return appErr
+	}
+
+	avatarLogo, err := forge.avatarURL(context.Background(), owner, true)
+	if err != nil {
+		p.API.LogWarn("Failed to fetch avatar", "error", err.Error())
+		avatarLogo = ""
+	}
+
+	topic := owner
+	if len(d.Repos) == 1 {
+		topic += "/" + d.Repos[0]
+	}
+
+	attachments := []*model.SlackAttachment{{
+		Title:      "Fetching weekly digest between " + since.Format(shortFormWithDay) + " and " + until.Format(shortFormWithDay),
+		Text:       waitText,
+		AuthorName: topic,
+		AuthorIcon: avatarLogo,
+		AuthorLink: forge.webURL(topic),
+	}}
+
+	if appErr := p.queueJob(&job{
+		Type:      jobTypeDigest,
+		UserID:    d.UserID,
+		ChannelID: d.ChannelID,
+		Command:   fmt.Sprintf("/%v digest", trigger),
+		Target:    d.Target,
+		Repos:     d.Repos,
+		Sections:  d.Sections,
+		IsOrg:     true,
+		Since:     since,
+		Until:     until,
+	}, attachments); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func (p *Plugin) updateDigestPost(ctx context.Context, forge provider, post *model.Post, userID, org string, repos, sections []string, since, until time.Time) error {
+	// Fetch commits until one day after at midnight
+	fetchUntil := until.AddDate(0, 0, 1).Add(-time.Microsecond)
+
+	var fields []*model.SlackAttachmentField
+	var filteredCommits, filteredNewCommitters filteredBots
+	err := func() error {
+		if util.Contains(sections, digestSectionCommitters) {
+			commits, err := p.fetchDigestCommits(ctx, forge, org, repos, since, fetchUntil)
+			if err != nil {
+				return err
+			}
+
+			commits, filteredCommits = p.getBotFilter().filterCommits(p.resolveIdentities(commits))
+			committers := countCommitterStats(commits, nil, measureCommits)
+			fields = append(fields, &model.SlackAttachmentField{
+				Title: "Number of commits",
+				Value: strconv.Itoa(len(commits)),
+				Short: true,
+			}, &model.SlackAttachmentField{
+				Title: "Number of Committer",
+				Value: strconv.Itoa(len(committers)),
+				Short: true,
+			}, &model.SlackAttachmentField{
+				Title: "Committer",
+				Value: formatCommitterStats(forge, committers),
+			})
+			fields = append(fields, unlinkedField(commits)...)
+		}
+
+		if util.Contains(sections, digestSectionNewCommitters) || util.Contains(sections, digestSectionFirstContributions) {
+			firstContributions, err := forge.findFirstContributions(ctx, org, repos, since)
+			if err != nil {
+				return err
+			}
+
+			var result []firstContributionInfo
+			for _, contribution := range firstContributions {
+				if contribution.date.After(fetchUntil) {
+					continue
+				}
+				result = append(result, contribution)
+			}
+			result, filteredNewCommitters = p.getBotFilter().filterFirstContributions(result)
+			sort.Slice(result, func(i, j int) bool {
+				return result[i].date.Before(result[j].date)
+			})
+
+			if util.Contains(sections, digestSectionNewCommitters) {
+				var names []string
+				for _, e := range result {
+					names = append(names, fmt.Sprintf("[%s](%s)", e.author, forge.webURL(e.author)))
+				}
+				fields = append(fields, &model.SlackAttachmentField{
+					Title: "Number of new committers",
+					Value: strconv.Itoa(len(result)),
+				})
+				if len(names) > 0 {
+					fields = append(fields, &model.SlackAttachmentField{
+						Title: "New committers",
+						Value: strings.Join(names, ", "),
+					})
+				}
+			}
+
+			if util.Contains(sections, digestSectionFirstContributions) && len(result) > 0 {
+				fields = append(fields, &model.SlackAttachmentField{
+					Title: "First contributions",
+					Value: formatFirstContributions(forge, result),
+				})
+			}
+		}
+		// Bots are listed once, with their number of commits if committers are included
+		if filteredCommits.count == 0 {
+			fields = append(fields, filteredNewCommitters.field("")...)
+		} else {
+			fields = append(fields, filteredCommits.field("commits")...)
+		}
+		return nil
+	}()
+	err = finishFetching(ctx, err)
+
+	attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
+	if err != nil {
+		p.API.LogError("failed to fetch data", "err", err.Error())
+		attachment.Text = githubErrorHandle(err)
+	} else {
+		attachment.Title = "Weekly digest between " + since.Format(shortFormWithDay) + " and " + until.Format(shortFormWithDay)
+		attachment.Text = ""
+		attachment.Fields = fields
+	}
+
+	if _, appErr := p.API.UpdatePost(post); appErr != nil {
+		p.SendEphemeralPost(post.ChannelId, userID, "Something went bad. Please try again.")
+		p.API.LogError("failed to update post", "err", appErr.Error())
+		return appErr
+	}
+
+	return err
+}
+
+func (p *Plugin) fetchDigestCommits(ctx context.Context, forge provider, org string, repos []string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	if len(repos) == 0 {
+		return forge.fetchCommits(ctx, org, "", true, since, until)
+	}
+	return forge.fetchCommitsFromRepos(ctx, org, repos, since, until)
+}
+
+func (p *Plugin) executeDigestCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	if len(commandArgs) == 0 {
+		return &model.AppError{
+			Id:         "Need a subcommand: set, show, pause, resume or delete",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	d, err := p.getDigest(args.ChannelId)
+	if err != nil {
+		p.API.LogError("Failed to load digest", "channel", args.ChannelId, "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to load digest",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	switch commandArgs[0] {
+	case "set":
+		return p.setDigest(d, commandArgs[1:], args)
+	case "show":
+		if d == nil {
+			p.SendEphemeralPost(args.ChannelId, args.UserId, "There is no digest in this channel.")
+			return nil
+		}
+		p.SendEphemeralPost(args.ChannelId, args.UserId, p.describeDigest(d))
+		return nil
+	case "pause", "resume", "delete":
+		return p.changeDigest(d, commandArgs[0], args)
+	default:
+		return &model.AppError{
+			Id:         fmt.Sprintf("Unknown command %v", commandArgs[0]),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+}
+
+// setDigest handles /community digest set [organization]/[repo,...] [day of week] [sections...].
+// If no sections are given, the digest contains all of them.
+func (p *Plugin) setDigest(d *digest, commandArgs []string, args *model.CommandArgs) *model.AppError {
+	if len(commandArgs) < 2 {
+		return &model.AppError{
+			Id:         "Usage: /community digest set [organization]/[repo,...] [monday|...|sunday] [" + strings.Join(digestSections, "|") + "]...",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	if d != nil && d.UserID != args.UserId && !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return &model.AppError{
+			Id:         "Only the creator of the digest or a system administrator can change it.",
+			StatusCode: http.StatusForbidden,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	target, repo, err := util.ParseOwnerAndRepository(commandArgs[0])
+	if err != nil {
+		return &model.AppError{
+			Id:         err.Error(),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	var repos []string
+	if repo != "" {
+		for _, r := range strings.Split(repo, ",") {
+			if r == "" {
+				return &model.AppError{
+					Id:         "invalid input",
+					StatusCode: http.StatusBadRequest,
+					Where:      "p.ExecuteCommand",
+				}
+			}
+			repos = append(repos, r)
+		}
+	}
+
+	weekday, ok := parseWeekday(commandArgs[1])
+	if !ok {
+		return &model.AppError{
+			Id:         fmt.Sprintf("Unknown day of week %v", commandArgs[1]),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	sections := digestSections
+	if len(commandArgs) > 2 {
+		sections = nil
+		for _, section := range commandArgs[2:] {
+			section = strings.ToLower(section)
+			if !util.Contains(digestSections, section) {
+				return &model.AppError{
+					Id:         fmt.Sprintf("Unknown section %v. Available sections: %v", section, strings.Join(digestSections, ", ")),
+					StatusCode: http.StatusBadRequest,
+					Where:      "p.ExecuteCommand",
+				}
+			}
+			if !util.Contains(sections, section) {
+				sections = append(sections, section)
+			}
+		}
+	}
+
+	forge, owner, appErr := p.getProvider(args.UserId, target)
+	if appErr != nil {
+		return appErr
+	}
+	isOrg, err := forge.verifyOwner(context.Background(), owner)
+	if err != nil {
+		return &model.AppError{
+			Id:         err.Error(),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	if !isOrg {
+		return &model.AppError{
+			Id:         "Digests can only be posted for organizations",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	userID := args.UserId
+	if d != nil {
+		userID = d.UserID
+	}
+	d = &digest{
+		ChannelID: args.ChannelId,
+		UserID:    userID,
+		Target:    target,
+		Repos:     repos,
+		Weekday:   weekday,
+		Sections:  sections,
+		UpdatedAt: time.Now(),
+	}
+
+	c, err := parseCron(d.cadence())
+	if err != nil {
+		return &model.AppError{
+			Id:         fmt.Sprintf("Invalid cadence: %v", err.Error()),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	d.NextRunAt = c.next(d.UpdatedAt)
+
+	if err = p.saveDigest(d); err != nil {
+		p.API.LogError("Failed to store digest", "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to store digest",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, p.describeDigest(d))
+	return nil
+}
+
+func (p *Plugin) describeDigest(d *digest) string {
+	text := fmt.Sprintf("The weekly digest for %v is posted to this channel every %v with the sections %v.", d.topic(), d.Weekday, strings.Join(d.Sections, ", "))
+	if d.Paused {
+		return text + " It's paused."
+	}
+	return text + fmt.Sprintf(" The next digest is posted at %v.", d.NextRunAt.Format("2006-01-02 15:04 MST"))
+}
+
+// changeDigest pauses, resumes or deletes the digest of a channel. Only its creator and system administrators can change it.
+func (p *Plugin) changeDigest(d *digest, action string, args *model.CommandArgs) *model.AppError {
+	if d == nil {
+		return &model.AppError{
+			Id:         "There is no digest in this channel.",
+			StatusCode: http.StatusNotFound,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	if d.UserID != args.UserId && !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return &model.AppError{
+			Id:         "Only the creator of the digest or a system administrator can change it.",
+			StatusCode: http.StatusForbidden,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	switch action {
+	case "delete":
+		if appErr := p.API.KVDelete(digestKeyPrefix + d.ChannelID); appErr != nil {
+			return appErr
+		}
+		if err := p.removeFromIndex(digestKeyPrefix, d.ChannelID); err != nil {
+			p.API.LogWarn("Failed to remove digest from index", "channel", d.ChannelID, "error", err.Error())
+		}
+		p.SendEphemeralPost(args.ChannelId, args.UserId, "Deleted the digest of this channel.")
+		return nil
+	case "pause":
+		d.Paused = true
+	case "resume":
+		c, err := parseCron(d.cadence())
+		if err != nil {
+			return &model.AppError{
+				Id:         fmt.Sprintf("Invalid cadence: %v", err.Error()),
+				StatusCode: http.StatusBadRequest,
+				Where:      "p.ExecuteCommand",
+			}
+		}
+		d.Paused = false
+		d.NextRunAt = c.next(time.Now())
+	}
+
+	if err := p.saveDigest(d); err != nil {
+		p.API.LogError("Failed to update digest", "channel", d.ChannelID, "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to update digest",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, p.describeDigest(d))
+	return nil
+}
+
+// parseWeekday parses the English name of a day of the week, e.g. monday or Mon.
+func parseWeekday(s string) (time.Weekday, bool) {
+	s = strings.ToLower(s)
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := strings.ToLower(weekday.String())
+		if s == name || s == name[:3] {
+			return weekday, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// digestTestProvider serves the commits and first contributions of a digest and records what was fetched.
+type digestTestProvider struct {
+	testProvider
+	commits            []*github.RepositoryCommit
+	firstContributions map[string]firstContributionInfo
+
+	fetchedCommits            bool
+	fetchedFirstContributions bool
+}
+
+func (d *digestTestProvider) fetchCommits(_ context.Context, _, _ string, _ bool, _, _ time.Time) ([]*github.RepositoryCommit, error) {
+	d.fetchedCommits = true
+	return d.commits, nil
+}
+
+func (d *digestTestProvider) findFirstContributions(_ context.Context, _ string, _ []string, _ time.Time) (map[string]firstContributionInfo, error) {
+	d.fetchedFirstContributions = true
+	return d.firstContributions, nil
+}
+
+func TestParseWeekday(t *testing.T) {
+	for input, expected := range map[string]time.Weekday{
+		"monday": time.Monday,
+		"Sunday": time.Sunday,
+		"SAT":    time.Saturday,
+		"wed":    time.Wednesday,
+	} {
+		weekday, ok := parseWeekday(input)
+		assert.True(t, ok, input)
+		assert.Equal(t, expected, weekday, input)
+	}
+
+	for _, input := range []string{"", "mo", "someday", "1"} {
+		_, ok := parseWeekday(input)
+		assert.False(t, ok, input)
+	}
+}
+
+func TestDigestCadence(t *testing.T) {
+	now := time.Date(2020, 1, 31, 10, 20, 30, 0, time.UTC) // A Friday
+
+	for weekday, expected := range map[time.Weekday]time.Time{
+		time.Monday:   time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC),
+		time.Thursday: time.Date(2020, 2, 6, 0, 0, 0, 0, time.UTC),
+		time.Friday:   time.Date(2020, 2, 7, 0, 0, 0, 0, time.UTC),
+		time.Saturday: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC),
+		time.Sunday:   time.Date(2020, 2, 2, 0, 0, 0, 0, time.UTC),
+	} {
+		d := &digest{Weekday: weekday}
+		c, err := parseCron(d.cadence())
+		require.NoError(t, err)
+		assert.Equal(t, expected, c.next(now), weekday.String())
+	}
+}
+
+func TestUpdateDigestPostSections(t *testing.T) {
+	since := time.Date(2020, 6, 8, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2020, 6, 14, 0, 0, 0, 0, time.UTC)
+
+	commit := func(login string) *github.RepositoryCommit {
+		date := since.AddDate(0, 0, 1)
+		return &github.RepositoryCommit{
+			SHA:    github.String(login),
+			Author: &github.User{Login: github.String(login)},
+			Commit: &github.Commit{
+				Author:    &github.CommitAuthor{Email: github.String(login + "@example.com"), Date: &date},
+				Committer: &github.CommitAuthor{Email: github.String(login + "@example.com"), Date: &date},
+			},
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		sections                  []string
+		expectedFields            []string
+		fetchesCommits            bool
+		fetchesFirstContributions bool
+	}{
+		"every section": {
+			sections:                  digestSections,
+			expectedFields:            []string{"Number of commits", "Number of Committer", "Committer", "Number of new committers", "New committers", "First contributions"},
+			fetchesCommits:            true,
+			fetchesFirstContributions: true,
+		},
+		"committers": {
+			sections:       []string{digestSectionCommitters},
+			expectedFields: []string{"Number of commits", "Number of Committer", "Committer"},
+			fetchesCommits: true,
+		},
+		"first contributions": {
+			sections:                  []string{digestSectionFirstContributions},
+			expectedFields:            []string{"First contributions"},
+			fetchesFirstContributions: true,
+		},
+		"new committers": {
+			sections:                  []string{digestSectionNewCommitters},
+			expectedFields:            []string{"Number of new committers", "New committers"},
+			fetchesFirstContributions: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			allowLogs(api)
+			newTestKVStore(api)
+			var updated *model.Post
+			api.On("UpdatePost", mock.Anything).Run(func(args mock.Arguments) {
+				updated = args.Get(0).(*model.Post)
+			}).Return(nil, (*model.AppError)(nil))
+
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			forge := &digestTestProvider{
+				commits: []*github.RepositoryCommit{commit("jane"), commit("john"), commit("jane")},
+				firstContributions: map[string]firstContributionInfo{
+					"john": {author: "john", date: since.AddDate(0, 0, 1), org: "org", repo: "server"},
+					// Contributions after the week aren't included
+					"alice": {author: "alice", date: until.AddDate(0, 0, 2), org: "org", repo: "server"},
+				},
+			}
+
+			err := p.updateDigestPost(context.Background(), forge, newTestJobPost(), "user", "org", nil, tc.sections, since, until)
+			require.NoError(t, err)
+			require.NotNil(t, updated)
+
+			attachment := updated.Attachments()[0]
+			assert.Equal(t, "Weekly digest between 2020-06-08 and 2020-06-14", attachment.Title)
+			var titles []string
+			for _, field := range attachment.Fields {
+				titles = append(titles, field.Title)
+			}
+			assert.Equal(t, tc.expectedFields, titles)
+			assert.Equal(t, tc.fetchesCommits, forge.fetchedCommits)
+			assert.Equal(t, tc.fetchesFirstContributions, forge.fetchedFirstContributions)
+
+			for _, field := range attachment.Fields {
+				switch field.Title {
+				case "Number of commits":
+					assert.Equal(t, "3", field.Value)
+				case "Number of Committer":
+					assert.Equal(t, "2", field.Value)
+				case "Number of new committers":
+					assert.Equal(t, "1", field.Value)
+				case "New committers", "First contributions":
+					assert.Contains(t, field.Value, "john")
+					assert.NotContains(t, field.Value, "alice")
+				}
+			}
+		})
+	}
+}
+
+func TestRunDigests(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	kv := newTestKVStore(api)
+	var created []*model.Post
+	api.On("CreatePost", mock.Anything).Return(func(post *model.Post) *model.Post {
+		created = append(created, post)
+		post.Id = "post"
+		return post
+	}, func(*model.Post) *model.AppError {
+		return nil
+	})
+	api.On("GetPost", "post").Return(func(string) *model.Post {
+		return newTestJobPost()
+	}, func(string) *model.AppError {
+		return nil
+	}).Maybe()
+	api.On("UpdatePost", mock.Anything).Return(nil, (*model.AppError)(nil)).Maybe()
+
+	g := newTestGitLabProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/groups/group":
+			writeJSON(t, w, gitLabNamespace{FullPath: "group"})
+		case "/groups/group/projects":
+			writeJSON(t, w, []gitLabProject{})
+		default:
+			t.Errorf("unexpected request %v", r.URL)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	p := &Plugin{scheduler: newScheduler(2, func(string, ...interface{}) {})}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{GitLabURL: strings.TrimSuffix(g.baseURL.String(), "/")})
+
+	now := time.Now().UTC()
+	// The digest was due three weeks ago, while the plugin wasn't running
+	missedRunAt := truncateToDay(now.AddDate(0, 0, -21))
+	missed := &digest{ChannelID: "missed", UserID: "user", Target: gitLabPrefix + "group", Weekday: missedRunAt.Weekday(), Sections: []string{digestSectionCommitters}, NextRunAt: missedRunAt}
+	paused := &digest{ChannelID: "paused", UserID: "user", Target: gitLabPrefix + "group", Weekday: missedRunAt.Weekday(), Paused: true, NextRunAt: missedRunAt}
+	upcoming := &digest{ChannelID: "upcoming", UserID: "user", Target: gitLabPrefix + "group", Weekday: missedRunAt.Weekday(), NextRunAt: now.Add(time.Hour)}
+	for _, d := range []*digest{missed, paused, upcoming} {
+		require.NoError(t, p.saveDigest(d))
+	}
+
+	p.runDigests()
+
+	// The missed digest is posted once, for the week before it was due
+	require.Len(t, created, 1)
+	assert.Equal(t, "missed", created[0].ChannelId)
+
+	d, err := p.getDigest("missed")
+	require.NoError(t, err)
+	assert.True(t, missedRunAt.Equal(d.LastRunAt))
+	assert.True(t, d.NextRunAt.After(now))
+	assert.Equal(t, missedRunAt.Weekday(), d.NextRunAt.Weekday())
+
+	jobIDs, err := p.listKeys(jobKeyPrefix)
+	require.NoError(t, err)
+	require.Len(t, jobIDs, 1)
+	j := waitForJob(t, kv, jobIDs[0], jobStatusFinished)
+	assert.Equal(t, jobTypeDigest, j.Type)
+	assert.Equal(t, []string{digestSectionCommitters}, j.Sections)
+	assert.True(t, missedRunAt.AddDate(0, 0, -7).Equal(j.Since), "since %v", j.Since)
+	assert.True(t, missedRunAt.AddDate(0, 0, -1).Equal(j.Until), "until %v", j.Until)
+
+	for _, channelID := range []string{"paused", "upcoming"} {
+		data := kv.get(digestKeyPrefix + channelID)
+		var unchanged digest
+		require.NoError(t, json.Unmarshal(data, &unchanged))
+		assert.True(t, unchanged.LastRunAt.IsZero(), channelID)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,39 +24,31 @@ type contributorsResult struct {
 
 const resultsPerPage = 100
 
-func (p *Plugin) fetchCommitsFromOrg(client *github.Client, org string, since, until time.Time) ([]*github.RepositoryCommit, error) {
-	var result []*github.RepositoryCommit
-	opts := &github.RepositoryListByOrgOptions{
-		ListOptions: github.ListOptions{
-			PerPage: resultsPerPage,
-		},
-		Type: "sources",
+// fetchRepositories returns the names of all source repositories of an organization or a user.
+func (p *Plugin) fetchRepositories(ctx context.Context, client *github.Client, owner string, isOrg bool) ([]string, error) {
+	var result []string
+	opts := github.ListOptions{
+		PerPage: resultsPerPage,
 	}
 
 	for {
-		repos, resp, err := client.Repositories.ListByOrg(context.Background(), org, opts)
+		var repos []*github.Repository
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			if isOrg {
+				repos, resp, err = client.Repositories.ListByOrg(ctx, owner, &github.RepositoryListByOrgOptions{ListOptions: opts, Type: "sources"})
+			} else {
+				repos, resp, err = client.Repositories.List(ctx, owner, &github.RepositoryListOptions{ListOptions: opts, Type: "sources"})
+			}
+			return resp, err
+		})
 		if err != nil {
 			return nil, err
 		}
 
-		var wg sync.WaitGroup
-		var jobResults = make(chan commitsResult, len(repos))
-
 		for _, repo := range repos {
-			wg.Add(1)
-			go p.fetchCommitsFromRepoJob(&wg, jobResults, client, org, *repo.Name, since, until)
-		}
-		go func() {
-			wg.Wait()
-			close(jobResults)
-		}()
-
-		for jr := range jobResults {
-			if jr.err != nil {
-				p.API.LogWarn("Failed to fetch commits ", "error", jr.err.Error())
-			} else {
-				result = append(result, jr.commits...)
-			}
+			result = append(result, repo.GetName())
 		}
 
 		if resp.NextPage == 0 {
@@ -67,58 +60,86 @@ func (p *Plugin) fetchCommitsFromOrg(client *github.Client, org string, since, u
 	return result, nil
 }
 
-func (p *Plugin) fetchCommitsFromUser(client *github.Client, user string, since, until time.Time) ([]*github.RepositoryCommit, error) {
-	var result []*github.RepositoryCommit
-	opts := &github.RepositoryListOptions{
-		ListOptions: github.ListOptions{
-			PerPage: resultsPerPage,
-		},
-		Type: "sources",
+func (p *Plugin) fetchCommitsFromOrg(ctx context.Context, client *github.Client, org string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	repos, err := p.fetchRepositories(ctx, client, org, true)
+	if err != nil {
+		return nil, err
 	}
 
-	for {
-		repos, resp, err := client.Repositories.List(context.Background(), user, opts)
+	return p.fetchCommitsFromRepos(ctx, client, org, repos, since, until)
+}
+
+func (p *Plugin) fetchCommitsFromUser(ctx context.Context, client *github.Client, user string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	repos, err := p.fetchRepositories(ctx, client, user, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.fetchCommitsFromRepos(ctx, client, user, repos, since, until)
+}
+
+// fetchCommitsFromRepos fetches the commits of multiple repositories of the same owner.
+// Repositories that fail to be fetched are skipped, unless ctx is done.
+func (p *Plugin) fetchCommitsFromRepos(ctx context.Context, client *github.Client, owner string, repos []string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	var result []*github.RepositoryCommit
+
+	if p.useGraphQL() {
+		histories, failed, err := p.fetchCommitHistoriesGraphQL(ctx, client, owner, repos, since, until)
 		if err != nil {
 			return nil, err
 		}
-
-		var wg sync.WaitGroup
-		var jobResults = make(chan commitsResult, len(repos))
-
+		for repo, repoErr := range failed {
+			p.API.LogWarn("Failed to fetch commits ", "repo", owner+"/"+repo, "error", repoErr.Error())
+		}
 		for _, repo := range repos {
-			wg.Add(1)
-			go p.fetchCommitsFromRepoJob(&wg, jobResults, client, user, *repo.Name, since, until)
+			result = append(result, histories[repo]...)
 		}
-		go func() {
-			wg.Wait()
-			close(jobResults)
-		}()
+		return result, ctx.Err()
+	}
 
-		for jr := range jobResults {
-			if jr.err != nil {
-				p.API.LogWarn("Failed to fetch commits ", "error", jr.err.Error())
-			} else {
-				result = append(result, jr.commits...)
-			}
-		}
+	pr := getProgress(ctx)
+	pr.addRepos(len(repos))
 
-		if resp.NextPage == 0 {
-			break
+	var wg sync.WaitGroup
+	var jobResults = make(chan commitsResult, len(repos))
+
+	for _, repo := range repos {
+		wg.Add(1)
+		go p.fetchCommitsFromRepoJob(ctx, &wg, jobResults, client, owner, repo, since, until)
+	}
+	go func() {
+		wg.Wait()
+		close(jobResults)
+	}()
+
+	for jr := range jobResults {
+		pr.repoDone(len(jr.commits), jr.err)
+		if jr.err != nil {
+			p.API.LogWarn("Failed to fetch commits ", "error", jr.err.Error())
+		} else {
+			result = append(result, jr.commits...)
 		}
-		opts.Page = resp.NextPage
 	}
 
-	return result, nil
+	return result, ctx.Err()
 }
 
-func (p *Plugin) fetchCommitsFromRepoJob(wg *sync.WaitGroup, result chan<- commitsResult, client *github.Client, org, repo string, since, until time.Time) {
-	commits, err := p.fetchCommitsFromRepo(client, org, repo, since, until)
+func (p *Plugin) fetchCommitsFromRepoJob(ctx context.Context, wg *sync.WaitGroup, result chan<- commitsResult, client *github.Client, org, repo string, since, until time.Time) {
+	commits, err := p.fetchCommitsFromRepo(ctx, client, org, repo, since, until)
 	output := commitsResult{commits, err}
 	result <- output
 	wg.Done()
 }
 
-func (p *Plugin) fetchCommitsFromRepo(client *github.Client, org, repo string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+// fetchCommitsFromRepo returns the commits of a repository between since and until.
+// Only commits that aren't in the commit cache yet are fetched from GitHub.
+func (p *Plugin) fetchCommitsFromRepo(ctx context.Context, client *github.Client, org, repo string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	return p.fetchCachedCommits(ctx, client.BaseURL.Host, org, repo, since, until, func(since, until time.Time) ([]*github.RepositoryCommit, error) {
+		return p.fetchUncachedCommitsFromRepo(ctx, client, org, repo, since, until)
+	})
+}
+
+func (p *Plugin) fetchUncachedCommitsFromRepo(ctx context.Context, client *github.Client, org, repo string, since, until time.Time) ([]*github.RepositoryCommit, error) {
 	var result []*github.RepositoryCommit
 	opts := &github.CommitsListOptions{
 		ListOptions: github.ListOptions{
@@ -129,8 +150,14 @@ func (p *Plugin) fetchCommitsFromRepo(client *github.Client, org, repo string, s
 	}
 
 	for {
-		commits, resp, err := client.Repositories.ListCommits(context.Background(), org, repo, opts)
-		if resp.StatusCode == http.StatusNotFound {
+		var commits []*github.RepositoryCommit
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			commits, resp, err = client.Repositories.ListCommits(ctx, org, repo, opts)
+			return resp, err
+		})
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
 			return nil, fmt.Errorf("repository %v/%v not found", org, repo)
 		}
 		if err != nil {
@@ -146,55 +173,42 @@ func (p *Plugin) fetchCommitsFromRepo(client *github.Client, org, repo string, s
 	return result, nil
 }
 
-func (p *Plugin) fetchContributors(client *github.Client, org string) (map[string][]*github.Contributor, error) {
+func (p *Plugin) fetchContributorsFromRepos(ctx context.Context, client *github.Client, org string, repos []string) map[string][]*github.Contributor {
 	var result = map[string][]*github.Contributor{}
-	opts := &github.RepositoryListByOrgOptions{
-		ListOptions: github.ListOptions{
-			PerPage: resultsPerPage,
-		},
-		Type: "sources",
-	}
 
-	for {
-		repos, resp, err := client.Repositories.ListByOrg(context.Background(), org, opts)
-		if err != nil {
-			return nil, err
-		}
-
-		var wg sync.WaitGroup
-		var jobResults = make(chan contributorsResult, len(repos))
-
-		for _, repo := range repos {
-			wg.Add(1)
-			go p.fetchContributorsFromRepoJob(&wg, jobResults, client, org, *repo.Name)
-		}
-		go func() {
-			wg.Wait()
-			close(jobResults)
-		}()
+	pr := getProgress(ctx)
+	pr.addRepos(len(repos))
 
-		for jr := range jobResults {
-			if jr.err == nil {
-				result[jr.repo] = append(result[jr.repo], jr.contributorStats...)
-			}
-		}
+	var wg sync.WaitGroup
+	var jobResults = make(chan contributorsResult, len(repos))
 
-		if resp.NextPage == 0 {
-			break
+	for _, repo := range repos {
+		wg.Add(1)
+		go p.fetchContributorsFromRepoJob(ctx, &wg, jobResults, client, org, repo)
+	}
+	go func() {
+		wg.Wait()
+		close(jobResults)
+	}()
+
+	for jr := range jobResults {
+		pr.repoDone(0, jr.err)
+		if jr.err == nil {
+			result[jr.repo] = append(result[jr.repo], jr.contributorStats...)
 		}
-		opts.Page = resp.NextPage
 	}
-	return result, nil
+
+	return result
 }
 
-func (p *Plugin) fetchContributorsFromRepoJob(wg *sync.WaitGroup, result chan<- contributorsResult, client *github.Client, org, repo string) {
-	contributors, err := p.fetchContributorsFromRepo(client, org, repo)
+func (p *Plugin) fetchContributorsFromRepoJob(ctx context.Context, wg *sync.WaitGroup, result chan<- contributorsResult, client *github.Client, org, repo string) {
+	contributors, err := p.fetchContributorsFromRepo(ctx, client, org, repo)
 	output := contributorsResult{contributors, repo, err}
 	result <- output
 	wg.Done()
 }
 
-func (p *Plugin) fetchContributorsFromRepo(client *github.Client, org, repo string) ([]*github.Contributor, error) {
+func (p *Plugin) fetchContributorsFromRepo(ctx context.Context, client *github.Client, org, repo string) ([]*github.Contributor, error) {
 	var result []*github.Contributor
 
 	opts := &github.ListContributorsOptions{
@@ -204,11 +218,17 @@ func (p *Plugin) fetchContributorsFromRepo(client *github.Client, org, repo stri
 	}
 
 	for {
-		contributors, resp, err := client.Repositories.ListContributors(context.Background(), org, repo, opts)
+		var contributors []*github.Contributor
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			contributors, resp, err = client.Repositories.ListContributors(ctx, org, repo, opts)
+			return resp, err
+		})
 		if err != nil {
 			return nil, err
 		}
-		if resp.StatusCode == http.StatusNotFound {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
 			return nil, fmt.Errorf("repository %v/%v not found", org, repo)
 		}
 		result = append(result, contributors...)
@@ -222,7 +242,7 @@ func (p *Plugin) fetchContributorsFromRepo(client *github.Client, org, repo stri
 	return result, nil
 }
 
-func (p *Plugin) fetchCommitsFromRepoByAuthor(client *github.Client, org, repo, author string) ([]*github.RepositoryCommit, error) {
+func (p *Plugin) fetchCommitsFromRepoByAuthor(ctx context.Context, client *github.Client, org, repo, author string) ([]*github.RepositoryCommit, error) {
 	var result []*github.RepositoryCommit
 	opts := &github.CommitsListOptions{
 		ListOptions: github.ListOptions{
@@ -232,11 +252,17 @@ func (p *Plugin) fetchCommitsFromRepoByAuthor(client *github.Client, org, repo,
 	}
 
 	for {
-		commits, resp, err := client.Repositories.ListCommits(context.Background(), org, repo, opts)
+		var commits []*github.RepositoryCommit
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			commits, resp, err = client.Repositories.ListCommits(ctx, org, repo, opts)
+			return resp, err
+		})
 		if err != nil {
 			return nil, err
 		}
-		if resp.StatusCode == http.StatusNotFound {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
 			return nil, fmt.Errorf("repository %v/%v not found", org, repo)
 		}
 		result = append(result, commits...)
@@ -249,7 +275,224 @@ func (p *Plugin) fetchCommitsFromRepoByAuthor(client *github.Client, org, repo,
 	return result, nil
 }
 
-func (p *Plugin) fetchTeamMemberFromTeam(client *github.Client, orgID, teamID int64) ([]*github.User, error) {
+// fetchFirstAndLastCommitByAuthor returns the oldest and the newest commit of an author to a repository.
+// Both are nil if the author didn't commit to it.
+func (p *Plugin) fetchFirstAndLastCommitByAuthor(ctx context.Context, client *github.Client, org, repo, author string) (first, last *github.RepositoryCommit, err error) {
+	opts := &github.CommitsListOptions{
+		ListOptions: github.ListOptions{
+			PerPage: 1,
+		},
+		Author: author,
+	}
+
+	// Commits are listed newest first, so the last page holds the oldest commit
+	for {
+		var commits []*github.RepositoryCommit
+		var resp *github.Response
+		err = p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			commits, resp, err = client.Repositories.ListCommits(ctx, org, repo, opts)
+			return resp, err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(commits) == 0 {
+			return first, last, nil
+		}
+
+		if last == nil {
+			last = commits[0]
+		}
+		first = commits[0]
+
+		if resp.LastPage == 0 || opts.Page == resp.LastPage {
+			return first, last, nil
+		}
+		opts.Page = resp.LastPage
+	}
+}
+
+// fetchContributorProfile returns the commits of a user to the repositories of an owner, and the number of their pull requests.
+// Commits are counted on the default branches, using the contributors of every repository.
+func (p *Plugin) fetchContributorProfile(ctx context.Context, client *github.Client, owner string, isOrg bool, login string) (*contributorProfile, error) {
+	var user *github.User
+	err := p.scheduler.do(ctx, func() (*github.Response, error) {
+		var err error
+		var resp *github.Response
+		user, resp, err = client.Users.Get(ctx, login)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return resp, fmt.Errorf("user %v not found", login)
+		}
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	profile := newContributorProfile(user.GetLogin(), user.GetName(), user.GetAvatarURL())
+
+	repos, err := p.fetchRepositories(ctx, client, owner, isOrg)
+	if err != nil {
+		return nil, err
+	}
+	contributors := p.fetchContributorsFromRepos(ctx, client, owner, repos)
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for repo, repoContributors := range contributors {
+		for _, contributor := range repoContributors {
+			if strings.EqualFold(contributor.GetLogin(), profile.login) {
+				profile.repos[repo] = contributor.GetContributions()
+				profile.commits += contributor.GetContributions()
+			}
+		}
+	}
+
+	for repo := range profile.repos {
+		first, last, err := p.fetchFirstAndLastCommitByAuthor(ctx, client, owner, repo, profile.login)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			p.API.LogWarn("Failed to fetch commits", "repo", owner+"/"+repo, "error", err.Error())
+			continue
+		}
+		if first != nil {
+			profile.addCommit(owner, repo, first)
+			profile.addCommit(owner, repo, last)
+		}
+	}
+
+	qualifier := "user"
+	if isOrg {
+		qualifier = "org"
+	}
+	var pullRequests *github.IssuesSearchResult
+	err = p.scheduler.do(ctx, func() (*github.Response, error) {
+		var err error
+		var resp *github.Response
+		pullRequests, resp, err = client.Search.Issues(ctx, fmt.Sprintf("type:pr author:%v %v:%v", profile.login, qualifier, owner), &github.SearchOptions{
+			Sort:        "created",
+			Order:       "desc",
+			ListOptions: github.ListOptions{PerPage: 1},
+		})
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	profile.pullRequests = pullRequests.GetTotal()
+	if len(pullRequests.Issues) > 0 {
+		pullRequest := pullRequests.Issues[0]
+		profile.addActivity(pullRequest.GetCreatedAt(), pullRequest.GetHTMLURL(), "Pull request")
+	}
+
+	return profile, nil
+}
+
+func (p *Plugin) fetchCommit(ctx context.Context, client *github.Client, org, repo, sha string) (*github.RepositoryCommit, error) {
+	var commit *github.RepositoryCommit
+	err := p.scheduler.do(ctx, func() (*github.Response, error) {
+		var err error
+		var resp *github.Response
+		commit, resp, err = client.Repositories.GetCommit(ctx, org, repo, sha)
+		return resp, err
+	})
+	return commit, err
+}
+
+// fetchCommitStatsFromRepo fetches the stats of commits of a repository one by one. Commits that fail to be fetched are skipped, unless ctx is done.
+func (p *Plugin) fetchCommitStatsFromRepo(ctx context.Context, client *github.Client, org, repo string, shas []string) (map[string]commitStats, error) {
+	var lock sync.Mutex
+	result := map[string]commitStats{}
+
+	forEachCommit(shas, func(sha string) {
+		commit, err := p.fetchCommit(ctx, client, org, repo, sha)
+		if err != nil {
+			p.API.LogWarn("Failed to fetch commit stats", "repo", org+"/"+repo, "sha", sha, "error", err.Error())
+			return
+		}
+
+		lock.Lock()
+		result[sha] = commitStats{
+			Additions: commit.GetStats().GetAdditions(),
+			Deletions: commit.GetStats().GetDeletions(),
+			Files:     len(commit.Files),
+		}
+		lock.Unlock()
+	})
+
+	return result, ctx.Err()
+}
+
+// checkEarlierContributions returns the authors that committed to a repository before the given time.
+// Checks that fail are skipped.
+func (p *Plugin) checkEarlierContributions(ctx context.Context, client *github.Client, org string, checks []earlierContributionCheck, before time.Time) map[string]bool {
+	earlier := map[string]bool{}
+	for _, check := range checks {
+		if earlier[check.author] {
+			continue
+		}
+
+		opts := &github.CommitsListOptions{
+			ListOptions: github.ListOptions{
+				PerPage: 1,
+			},
+			Author: check.author,
+			Until:  before.Add(-time.Second),
+		}
+
+		var commits []*github.RepositoryCommit
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			var resp *github.Response
+			commits, resp, err = client.Repositories.ListCommits(ctx, org, check.repo, opts)
+			return resp, err
+		})
+		if err != nil {
+			p.API.LogWarn("Failed to check earlier contributions", "repo", org+"/"+check.repo, "author", check.author, "error", err.Error())
+			continue
+		}
+
+		if len(commits) > 0 {
+			earlier[check.author] = true
+		}
+	}
+	return earlier
+}
+
+func (p *Plugin) fetchOrgMembers(ctx context.Context, client *github.Client, org string) ([]string, error) {
+	var result []string
+	opts := &github.ListMembersOptions{
+		ListOptions: github.ListOptions{
+			PerPage: resultsPerPage,
+		},
+	}
+	for {
+		var members []*github.User
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			members, resp, err = client.Organizations.ListMembers(ctx, org, opts)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			result = append(result, member.GetLogin())
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return result, nil
+}
+
+func (p *Plugin) fetchTeamMemberFromTeam(ctx context.Context, client *github.Client, orgID, teamID int64) ([]*github.User, error) {
 	var result []*github.User
 	opts := &github.TeamListTeamMembersOptions{
 		ListOptions: github.ListOptions{
@@ -257,7 +500,13 @@ func (p *Plugin) fetchTeamMemberFromTeam(client *github.Client, orgID, teamID in
 		},
 	}
 	for {
-		member, resp, err := client.Teams.ListTeamMembersByID(context.Background(), orgID, teamID, opts)
+		var member []*github.User
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			member, resp, err = client.Teams.ListTeamMembersByID(ctx, orgID, teamID, opts)
+			return resp, err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -271,13 +520,19 @@ func (p *Plugin) fetchTeamMemberFromTeam(client *github.Client, orgID, teamID in
 	return result, nil
 }
 
-func (p *Plugin) fetchTeams(client *github.Client, org string) ([]*github.Team, error) {
+func (p *Plugin) fetchTeams(ctx context.Context, client *github.Client, org string) ([]*github.Team, error) {
 	var result []*github.Team
 	opts := &github.ListOptions{
 		PerPage: resultsPerPage,
 	}
 	for {
-		teams, resp, err := client.Teams.ListTeams(context.Background(), org, opts)
+		var teams []*github.Team
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			teams, resp, err = client.Teams.ListTeams(ctx, org, opts)
+			return resp, err
+		})
 		if err != nil {
 			return nil, err
 		}
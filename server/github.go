@@ -3,6 +3,10 @@ package main
 import (
 	"context"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/google/go-github/v31/github"
 	"github.com/mattermost/mattermost-plugin-github/server/client"
@@ -12,15 +16,22 @@ import (
 
 const (
 	gitHubPluginID = "github"
+
+	gitHubDefaultWebURL = "https://github.com/"
 )
 
-func (p *Plugin) getGitHubClient(userID string) (*github.Client, error) {
+func (p *Plugin) isGitHubPluginRunning() bool {
 	status, appErr := p.API.GetPluginStatus(gitHubPluginID)
 	if appErr != nil {
 		p.API.LogDebug("Failed to fetch status of GitHub plugin", "error", appErr.Error())
+		return false
 	}
 
-	if appErr != nil || status.State != model.PluginStateRunning {
+	return status.State == model.PluginStateRunning
+}
+
+func (p *Plugin) getGitHubClient(userID string) (*github.Client, error) {
+	if !p.isGitHubPluginRunning() {
 		p.API.LogDebug("GitHub plugin is not running. Falling back to config token.")
 
 		configuration := p.getConfiguration()
@@ -31,7 +42,15 @@ func (p *Plugin) getGitHubClient(userID string) (*github.Client, error) {
 			)
 			tc = oauth2.NewClient(context.Background(), ts)
 		}
-		return github.NewClient(tc), nil
+		if configuration.GitHubAPIURL == "" {
+			return github.NewClient(tc), nil
+		}
+
+		uploadURL := configuration.GitHubUploadURL
+		if uploadURL == "" {
+			uploadURL = configuration.GitHubAPIURL
+		}
+		return github.NewEnterpriseClient(configuration.GitHubAPIURL, uploadURL, tc)
 	}
 
 	p.API.LogDebug("Using token from GitHub plugin.")
@@ -45,3 +64,300 @@ func (p *Plugin) getGitHubClient(userID string) (*github.Client, error) {
 
 	return ghClient, nil
 }
+
+// getGitHubWebURL returns the base URL of the web interface of the GitHub instance client is connected to, with a trailing slash.
+// If none is configured, it's derived from the API URL of the client, e.g. https://[hostname]/ for https://[hostname]/api/v3/.
+func (p *Plugin) getGitHubWebURL(client *github.Client) string {
+	if webURL := p.getConfiguration().GitHubWebURL; webURL != "" {
+		return strings.TrimSuffix(webURL, "/") + "/"
+	}
+
+	if client.BaseURL.Host == "api.github.com" {
+		return gitHubDefaultWebURL
+	}
+
+	webURL := url.URL{Scheme: client.BaseURL.Scheme, Host: client.BaseURL.Host, Path: "/"}
+	return webURL.String()
+}
+
+// gitHubProvider fetches the data of reports from GitHub.
+type gitHubProvider struct {
+	p      *Plugin
+	client *github.Client
+	// userID is the user whose token of the GitHub plugin the client uses, if the plugin is running.
+	userID string
+}
+
+// gitHubNoReplyEmail matches the private email addresses GitHub creates for its users, e.g. 12345+login@users.noreply.github.com.
+var gitHubNoReplyEmail = regexp.MustCompile(`^(?:\d+\+)?([^@+]+)@users\.noreply\.`)
+
+// gitToken returns the token that the client uses: the token of the user from the GitHub plugin, if it's running, or the configured one.
+func (g *gitHubProvider) gitToken() (string, error) {
+	if !g.p.isGitHubPluginRunning() {
+		return g.p.getConfiguration().Token, nil
+	}
+
+	token, err := client.NewPluginClient(g.p.API).GetToken(g.userID)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func (g *gitHubProvider) id() string {
+	return g.client.BaseURL.Host
+}
+
+func (g *gitHubProvider) webURL(path string) string {
+	return g.p.getGitHubWebURL(g.client) + path
+}
+
+func (g *gitHubProvider) verifyOwner(ctx context.Context, owner string) (bool, error) {
+	return g.p.verifyOrg(ctx, g.client, owner)
+}
+
+func (g *gitHubProvider) avatarURL(ctx context.Context, owner string, isOrg bool) (string, error) {
+	return g.p.GetAvatarLogo(ctx, g.client, owner, isOrg)
+}
+
+func (g *gitHubProvider) listRepositories(ctx context.Context, owner string, isOrg bool) ([]string, error) {
+	return g.p.fetchRepositories(ctx, g.client, owner, isOrg)
+}
+
+func (g *gitHubProvider) fetchCommits(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	switch {
+	case repo != "":
+		pr := getProgress(ctx)
+		pr.addRepos(1)
+		commits, err := g.p.fetchCommitsFromRepo(ctx, g.client, owner, repo, since, until)
+		pr.repoDone(len(commits), err)
+		return commits, err
+	case isOrg:
+		return g.p.fetchCommitsFromOrg(ctx, g.client, owner, since, until)
+	default:
+		return g.p.fetchCommitsFromUser(ctx, g.client, owner, since, until)
+	}
+}
+
+func (g *gitHubProvider) fetchCommitsFromRepos(ctx context.Context, owner string, repos []string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	return g.p.fetchCommitsFromRepos(ctx, g.client, owner, repos, since, until)
+}
+
+func (g *gitHubProvider) fetchCommitStats(ctx context.Context, owner, repo string, shas []string) (map[string]commitStats, error) {
+	if g.p.useGraphQL() {
+		stats, err := g.p.fetchCommitStatsGraphQL(ctx, g.client, owner, repo, shas)
+		if !isGraphQLUndefinedFieldError(err) {
+			return stats, err
+		}
+		// GitHub Enterprise Server versions without changedFilesIfAvailable
+		g.p.API.LogDebug("Falling back to the REST API for commit stats", "error", err.Error())
+	}
+	return g.p.fetchCommitStatsFromRepo(ctx, g.client, owner, repo, shas)
+}
+
+func (g *gitHubProvider) fetchPullRequests(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]*github.PullRequest, error) {
+	if repo != "" {
+		pr := getProgress(ctx)
+		pr.addRepos(1)
+		pullRequests, err := g.p.fetchPullRequestsFromRepo(ctx, g.client, owner, repo, since, until)
+		pr.repoDone(0, err)
+		return pullRequests, err
+	}
+
+	repos, err := g.p.fetchRepositories(ctx, g.client, owner, isOrg)
+	if err != nil {
+		return nil, err
+	}
+	return g.p.fetchPullRequestsFromRepos(ctx, g.client, owner, repos, since, until)
+}
+
+func (g *gitHubProvider) fetchIssues(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]*github.Issue, error) {
+	if repo != "" {
+		pr := getProgress(ctx)
+		pr.addRepos(1)
+		issues, err := g.p.fetchIssuesFromRepo(ctx, g.client, owner, repo, since, until)
+		pr.repoDone(0, err)
+		return issues, err
+	}
+
+	repos, err := g.p.fetchRepositories(ctx, g.client, owner, isOrg)
+	if err != nil {
+		return nil, err
+	}
+	return g.p.fetchIssuesFromRepos(ctx, g.client, owner, repos, since, until)
+}
+
+func (g *gitHubProvider) fetchReviews(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]reviewActivity, error) {
+	if repo != "" {
+		pr := getProgress(ctx)
+		pr.addRepos(1)
+		reviews, err := g.p.fetchReviewsFromRepo(ctx, g.client, owner, repo, since, until)
+		pr.repoDone(0, err)
+		return reviews, err
+	}
+
+	repos, err := g.p.fetchRepositories(ctx, g.client, owner, isOrg)
+	if err != nil {
+		return nil, err
+	}
+	return g.p.fetchReviewsFromRepos(ctx, g.client, owner, repos, since, until)
+}
+
+func (g *gitHubProvider) fetchFirstResponses(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]contribution, error) {
+	if repo != "" {
+		pr := getProgress(ctx)
+		pr.addRepos(1)
+		contributions, err := g.p.fetchFirstResponsesFromRepo(ctx, g.client, owner, repo, since, until)
+		pr.repoDone(0, err)
+		return contributions, err
+	}
+
+	repos, err := g.p.fetchRepositories(ctx, g.client, owner, isOrg)
+	if err != nil {
+		return nil, err
+	}
+	return g.p.fetchFirstResponsesFromRepos(ctx, g.client, owner, repos, since, until)
+}
+
+func (g *gitHubProvider) findFirstContributions(ctx context.Context, org string, repos []string, since time.Time) (map[string]firstContributionInfo, error) {
+	if repos == nil {
+		var err error
+		repos, err = g.p.fetchRepositories(ctx, g.client, org, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	contributors := g.p.fetchContributorsFromRepos(ctx, g.client, org, repos)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if g.p.useGraphQL() {
+		return g.p.findFirstContributionsGraphQL(ctx, g.client, contributors, org, since)
+	}
+	return g.p.findFirstContributions(ctx, g.client, contributors, org, since)
+}
+
+func (g *gitHubProvider) contributedBefore(ctx context.Context, org string, repos, logins []string, before time.Time) (map[string]bool, error) {
+	var checks []earlierContributionCheck
+	for _, repo := range repos {
+		for _, login := range logins {
+			checks = append(checks, earlierContributionCheck{repo, login})
+		}
+	}
+
+	if g.p.useGraphQL() {
+		nodeIDs := map[string]string{}
+		if err := g.p.resolveNodeIDs(ctx, g.client, logins, nodeIDs); err != nil {
+			return nil, err
+		}
+		earlier, err := g.p.checkEarlierContributionsGraphQL(ctx, g.client, org, checks, nodeIDs, nil, before)
+		if err != nil {
+			return nil, err
+		}
+
+		// Authors without a node ID, e.g. bots, are checked by their login with the REST API
+		var unresolved []earlierContributionCheck
+		for _, check := range checks {
+			if nodeIDs[check.author] == "" {
+				unresolved = append(unresolved, check)
+			}
+		}
+		for login := range g.p.checkEarlierContributions(ctx, g.client, org, unresolved, before) {
+			earlier[login] = true
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return earlier, nil
+	}
+	earlier := g.p.checkEarlierContributions(ctx, g.client, org, checks, before)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return earlier, nil
+}
+
+func (g *gitHubProvider) resolveAuthor(ctx context.Context, owner, repo, sha, _ string) (*github.User, error) {
+	commit, err := g.p.fetchCommit(ctx, g.client, owner, repo, sha)
+	if err != nil {
+		return nil, err
+	}
+	if commit.GetAuthor() == nil {
+		return nil, nil
+	}
+	return trimCommit(commit).Author, nil
+}
+
+func (g *gitHubProvider) fetchContributorProfile(ctx context.Context, owner string, isOrg bool, login string) (*contributorProfile, error) {
+	return g.p.fetchContributorProfile(ctx, g.client, owner, isOrg, login)
+}
+
+// lookupUserByEmail finds the user of a private noreply email address by its login. Other addresses are searched,
+// first among the public email addresses of users and then among the authors of commits.
+func (g *gitHubProvider) lookupUserByEmail(ctx context.Context, email string) (*github.User, error) {
+	if match := gitHubNoReplyEmail.FindStringSubmatch(strings.ToLower(email)); match != nil {
+		return &github.User{Login: github.String(match[1])}, nil
+	}
+
+	var users *github.UsersSearchResult
+	err := g.p.scheduler.do(ctx, func() (*github.Response, error) {
+		var err error
+		var resp *github.Response
+		users, resp, err = g.client.Search.Users(ctx, email+" in:email", nil)
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(users.Users) == 1 {
+		return users.Users[0], nil
+	}
+
+	var commits *github.CommitsSearchResult
+	err = g.p.scheduler.do(ctx, func() (*github.Response, error) {
+		var err error
+		var resp *github.Response
+		commits, resp, err = g.client.Search.Commits(ctx, "author-email:"+email, &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 1}})
+		return resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range commits.Commits {
+		if c.GetAuthor() != nil {
+			return c.GetAuthor(), nil
+		}
+	}
+	return nil, nil
+}
+
+func (g *gitHubProvider) fetchMembers(ctx context.Context, org string) ([]string, error) {
+	return g.p.fetchOrgMembers(ctx, g.client, org)
+}
+
+func (g *gitHubProvider) fetchTeamMembers(ctx context.Context, org string, teamSlugs []string) ([]string, error) {
+	teams, err := g.p.fetchTeams(ctx, g.client, org)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, slug := range teamSlugs {
+		for _, team := range teams {
+			if team.GetSlug() != slug {
+				continue
+			}
+
+			member, err := g.p.fetchTeamMemberFromTeam(ctx, g.client, team.GetOrganization().GetID(), team.GetID())
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range member {
+				result = append(result, m.GetLogin())
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,1103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/pkg/errors"
+)
+
+const gitLabPrefix = "gitlab:"
+
+type gitLabNamespace struct {
+	FullPath  string `json:"full_path"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type gitLabUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type gitLabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+type gitLabCommit struct {
+	ID             string    `json:"id"`
+	Message        string    `json:"message"`
+	AuthorName     string    `json:"author_name"`
+	AuthorEmail    string    `json:"author_email"`
+	AuthoredDate   time.Time `json:"authored_date"`
+	CommitterName  string    `json:"committer_name"`
+	CommitterEmail string    `json:"committer_email"`
+	CommittedDate  time.Time `json:"committed_date"`
+	WebURL         string    `json:"web_url"`
+	Stats          *struct {
+		Additions int `json:"additions"`
+		Deletions int `json:"deletions"`
+	} `json:"stats"`
+}
+
+type gitLabMergeRequest struct {
+	IID       int        `json:"iid"`
+	ProjectID int64      `json:"project_id"`
+	Title     string     `json:"title"`
+	State     string     `json:"state"`
+	WebURL    string     `json:"web_url"`
+	Author    gitLabUser `json:"author"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	MergedAt  *time.Time `json:"merged_at"`
+	ClosedAt  *time.Time `json:"closed_at"`
+}
+
+type gitLabIssue struct {
+	IID       int        `json:"iid"`
+	ProjectID int64      `json:"project_id"`
+	Title     string     `json:"title"`
+	State     string     `json:"state"`
+	WebURL    string     `json:"web_url"`
+	Author    gitLabUser `json:"author"`
+	Labels    []string   `json:"labels"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ClosedAt  *time.Time `json:"closed_at"`
+}
+
+type gitLabNote struct {
+	Body      string     `json:"body"`
+	Type      string     `json:"type"`
+	System    bool       `json:"system"`
+	Author    gitLabUser `json:"author"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type gitLabContributor struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Commits int    `json:"commits"`
+}
+
+// gitLabError is returned for every failed request to the GitLab API.
+type gitLabError struct {
+	Response *http.Response
+	Message  string
+}
+
+func (e *gitLabError) Error() string {
+	return fmt.Sprintf("%v %v: %d %v", e.Response.Request.Method, e.Response.Request.URL.Path, e.Response.StatusCode, e.Message)
+}
+
+// gitLabProvider fetches the data of reports from a GitLab instance.
+// GitLab commits aren't linked to user accounts. Their authors are looked up by their email address.
+type gitLabProvider struct {
+	p       *Plugin
+	baseURL *url.URL
+	token   string
+	client  *http.Client
+
+	usersLock sync.Mutex
+	// usersByEmail caches the users found for an email address. nil means that no user was found.
+	usersByEmail map[string]*github.User
+}
+
+func (p *Plugin) getGitLabProvider() (*gitLabProvider, error) {
+	config := p.getConfiguration()
+	if config.GitLabURL == "" {
+		return nil, errors.New("GitLab URL not configured")
+	}
+
+	baseURL, err := url.Parse(strings.TrimSuffix(config.GitLabURL, "/") + "/")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse GitLab URL")
+	}
+
+	return &gitLabProvider{
+		p:            p,
+		baseURL:      baseURL,
+		token:        config.GitLabToken,
+		client:       &http.Client{Timeout: time.Minute},
+		usersByEmail: map[string]*github.User{},
+	}, nil
+}
+
+func (g *gitLabProvider) id() string {
+	return g.baseURL.Host
+}
+
+func (g *gitLabProvider) webURL(path string) string {
+	return g.baseURL.String() + path
+}
+
+// get fetches a single page of a GitLab API endpoint and returns the number of the next page, or 0 for the last page.
+func (g *gitLabProvider) get(ctx context.Context, path string, query url.Values, v interface{}) (int, error) {
+	u, err := g.baseURL.Parse("api/v4/" + path)
+	if err != nil {
+		return 0, err
+	}
+	u.RawQuery = query.Encode()
+
+	var nextPage int
+	err = g.p.scheduler.do(ctx, func() (*github.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		if g.token != "" {
+			req.Header.Set("PRIVATE-TOKEN", g.token)
+		}
+
+		resp, err := g.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			var body struct {
+				Message interface{} `json:"message"`
+			}
+			data, _ := ioutil.ReadAll(resp.Body)
+			_ = json.Unmarshal(data, &body)
+			return nil, &gitLabError{resp, fmt.Sprint(body.Message)}
+		}
+
+		nextPage, _ = strconv.Atoi(resp.Header.Get("X-Next-Page"))
+		return nil, json.NewDecoder(resp.Body).Decode(v)
+	})
+	return nextPage, err
+}
+
+func isGitLabNotFound(err error) bool {
+	e, ok := err.(*gitLabError)
+	return ok && e.Response.StatusCode == http.StatusNotFound
+}
+
+func (g *gitLabProvider) getGroup(ctx context.Context, group string) (*gitLabNamespace, error) {
+	var result *gitLabNamespace
+	_, err := g.get(ctx, "groups/"+url.PathEscape(group), url.Values{"with_projects": {"false"}}, &result)
+	return result, err
+}
+
+func (g *gitLabProvider) getUser(ctx context.Context, username string) (*gitLabUser, error) {
+	var users []*gitLabUser
+	if _, err := g.get(ctx, "users", url.Values{"username": {username}}, &users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("unable to find GitLab user %s", username)
+	}
+	return users[0], nil
+}
+
+func (g *gitLabProvider) verifyOwner(ctx context.Context, owner string) (bool, error) {
+	if _, err := g.getGroup(ctx, owner); err == nil {
+		return true, nil
+	}
+	if _, err := g.getUser(ctx, owner); err == nil {
+		return false, nil
+	}
+
+	return true, fmt.Errorf("unable to find GitLab group, or user with matching owner: %s", owner)
+}
+
+func (g *gitLabProvider) avatarURL(ctx context.Context, owner string, isOrg bool) (string, error) {
+	if isOrg {
+		group, err := g.getGroup(ctx, owner)
+		if err != nil {
+			return "", fmt.Errorf("unable to find GitLab group with matching owner: %s", owner)
+		}
+		return group.AvatarURL, nil
+	}
+
+	user, err := g.getUser(ctx, owner)
+	if err != nil {
+		return "", fmt.Errorf("unable to find GitLab user with matching owner: %s", owner)
+	}
+	return user.AvatarURL, nil
+}
+
+// listRepositories returns the paths of the projects of a group, including the ones in subgroups, or of a user.
+// The paths are relative to the owner.
+func (g *gitLabProvider) listRepositories(ctx context.Context, owner string, isOrg bool) ([]string, error) {
+	path := "groups/" + url.PathEscape(owner) + "/projects"
+	query := url.Values{"include_subgroups": {"true"}, "with_shared": {"false"}, "archived": {"false"}}
+	if !isOrg {
+		path = "users/" + url.PathEscape(owner) + "/projects"
+		query = url.Values{"archived": {"false"}}
+	}
+
+	query.Set("per_page", strconv.Itoa(resultsPerPage))
+
+	var result []string
+	for page := 1; page != 0; {
+		query.Set("page", strconv.Itoa(page))
+
+		var projects []*gitLabProject
+		nextPage, err := g.get(ctx, path, query, &projects)
+		if err != nil {
+			return nil, err
+		}
+		for _, project := range projects {
+			result = append(result, strings.TrimPrefix(project.PathWithNamespace, owner+"/"))
+		}
+
+		page = nextPage
+	}
+	return result, nil
+}
+
+func (g *gitLabProvider) fetchCommits(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	if repo != "" {
+		pr := getProgress(ctx)
+		pr.addRepos(1)
+		commits, err := g.fetchCommitsFromProject(ctx, owner, repo, since, until)
+		pr.repoDone(len(commits), err)
+		if isGitLabNotFound(err) {
+			return nil, fmt.Errorf("project %v/%v not found", owner, repo)
+		}
+		return commits, err
+	}
+
+	repos, err := g.listRepositories(ctx, owner, isOrg)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.fetchCommitsFromRepos(ctx, owner, repos, since, until)
+}
+
+func (g *gitLabProvider) fetchCommitsFromRepos(ctx context.Context, owner string, repos []string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	commits := g.fetchCommitsFromProjects(ctx, owner, repos, since, until)
+
+	var result []*github.RepositoryCommit
+	for _, repo := range repos {
+		result = append(result, commits[repo]...)
+	}
+	return result, ctx.Err()
+}
+
+// fetchCommitsFromProjects fetches the commits of many projects concurrently. Projects that fail to be fetched are skipped.
+func (g *gitLabProvider) fetchCommitsFromProjects(ctx context.Context, owner string, repos []string, since, until time.Time) map[string][]*github.RepositoryCommit {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	result := map[string][]*github.RepositoryCommit{}
+
+	pr := getProgress(ctx)
+	pr.addRepos(len(repos))
+
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+
+			commits, err := g.fetchCommitsFromProject(ctx, owner, repo, since, until)
+			pr.repoDone(len(commits), err)
+			if err != nil {
+				g.p.API.LogWarn("Failed to fetch commits ", "error", err.Error())
+				return
+			}
+
+			lock.Lock()
+			result[repo] = commits
+			lock.Unlock()
+		}(repo)
+	}
+	wg.Wait()
+
+	return result
+}
+
+// fetchCommitsFromProject returns the commits of a project between since and until.
+// The commits are cached without their authors, which are resolved for every report.
+func (g *gitLabProvider) fetchCommitsFromProject(ctx context.Context, owner, repo string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	commits, err := g.p.fetchCachedCommits(ctx, g.id(), owner, repo, since, until, func(since, until time.Time) ([]*github.RepositoryCommit, error) {
+		query := url.Values{
+			"since":    {since.Format(time.RFC3339)},
+			"until":    {until.Format(time.RFC3339)},
+			"per_page": {strconv.Itoa(resultsPerPage)},
+		}
+
+		var result []*github.RepositoryCommit
+		for page := 1; page != 0; {
+			query.Set("page", strconv.Itoa(page))
+
+			var commits []gitLabCommit
+			nextPage, err := g.get(ctx, "projects/"+url.PathEscape(owner+"/"+repo)+"/repository/commits", query, &commits)
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range commits {
+				result = append(result, c.toRepositoryCommit())
+			}
+
+			page = nextPage
+		}
+		return result, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g.resolveAuthors(ctx, commits), nil
+}
+
+// fetchCommitStats fetches the stats of commits one by one. The files are counted in the diff of every commit.
+// Commits that fail to be fetched are skipped, unless ctx is done.
+func (g *gitLabProvider) fetchCommitStats(ctx context.Context, owner, repo string, shas []string) (map[string]commitStats, error) {
+	var lock sync.Mutex
+	result := map[string]commitStats{}
+
+	forEachCommit(shas, func(sha string) {
+		stats, err := g.fetchCommitStatsOfCommit(ctx, "projects/"+url.PathEscape(owner+"/"+repo)+"/repository/commits/"+url.PathEscape(sha))
+		if err != nil {
+			g.p.API.LogWarn("Failed to fetch commit stats", "repo", owner+"/"+repo, "sha", sha, "error", err.Error())
+			return
+		}
+
+		lock.Lock()
+		result[sha] = stats
+		lock.Unlock()
+	})
+
+	return result, ctx.Err()
+}
+
+func (g *gitLabProvider) fetchCommitStatsOfCommit(ctx context.Context, path string) (commitStats, error) {
+	var commit gitLabCommit
+	if _, err := g.get(ctx, path, url.Values{"stats": {"true"}}, &commit); err != nil {
+		return commitStats{}, err
+	}
+
+	var stats commitStats
+	if commit.Stats != nil {
+		stats.Additions = commit.Stats.Additions
+		stats.Deletions = commit.Stats.Deletions
+	}
+
+	query := url.Values{"per_page": {strconv.Itoa(resultsPerPage)}}
+	for page := 1; page != 0; {
+		query.Set("page", strconv.Itoa(page))
+
+		var diffs []json.RawMessage
+		nextPage, err := g.get(ctx, path+"/diff", query, &diffs)
+		if err != nil {
+			return commitStats{}, err
+		}
+		stats.Files += len(diffs)
+
+		page = nextPage
+	}
+	return stats, nil
+}
+
+// toRepositoryCommit converts a commit without linking its author, see resolveAuthors.
+func (c gitLabCommit) toRepositoryCommit() *github.RepositoryCommit {
+	authoredDate := c.AuthoredDate
+	committedDate := c.CommittedDate
+
+	return &github.RepositoryCommit{
+		SHA:     github.String(c.ID),
+		HTMLURL: github.String(c.WebURL),
+		Commit: &github.Commit{
+			Message: github.String(c.Message),
+			Author: &github.CommitAuthor{
+				Name:  github.String(c.AuthorName),
+				Email: github.String(c.AuthorEmail),
+				Date:  &authoredDate,
+			},
+			Committer: &github.CommitAuthor{
+				Name:  github.String(c.CommitterName),
+				Email: github.String(c.CommitterEmail),
+				Date:  &committedDate,
+			},
+		},
+	}
+}
+
+// fetchPullRequests returns the merge requests of a project, or of all projects of a group or user.
+// Groups are fetched with a single request, including their subgroups.
+func (g *gitLabProvider) fetchPullRequests(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]*github.PullRequest, error) {
+	var result []*github.PullRequest
+	err := g.forEachScope(ctx, owner, repo, isOrg, "merge_requests", func(path string) error {
+		pullRequests, err := g.fetchMergeRequests(ctx, path, since, until)
+		result = append(result, pullRequests...)
+		return err
+	})
+	return result, err
+}
+
+// fetchIssues returns the issues of a project, or of all projects of a group or user.
+func (g *gitLabProvider) fetchIssues(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]*github.Issue, error) {
+	var result []*github.Issue
+	err := g.forEachScope(ctx, owner, repo, isOrg, "issues", func(path string) error {
+		issues, err := g.fetchGitLabIssues(ctx, path, since, until)
+		result = append(result, issues...)
+		return err
+	})
+	return result, err
+}
+
+// forEachScope calls fetch with the path of an endpoint of a project, a group or every project of a user.
+// Projects of a user that fail to be fetched are skipped.
+func (g *gitLabProvider) forEachScope(ctx context.Context, owner, repo string, isOrg bool, endpoint string, fetch func(path string) error) error {
+	var paths []string
+	switch {
+	case repo != "":
+		paths = []string{"projects/" + url.PathEscape(owner+"/"+repo) + "/" + endpoint}
+	case isOrg:
+		paths = []string{"groups/" + url.PathEscape(owner) + "/" + endpoint}
+	default:
+		repos, err := g.listRepositories(ctx, owner, false)
+		if err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			paths = append(paths, "projects/"+url.PathEscape(owner+"/"+repo)+"/"+endpoint)
+		}
+	}
+
+	pr := getProgress(ctx)
+	pr.addRepos(len(paths))
+
+	for _, path := range paths {
+		err := fetch(path)
+		pr.repoDone(0, err)
+		if isGitLabNotFound(err) && repo != "" {
+			return fmt.Errorf("project %v/%v not found", owner, repo)
+		}
+		if err != nil {
+			if ctx.Err() != nil || len(paths) == 1 {
+				return err
+			}
+			g.p.API.LogWarn("Failed to fetch "+endpoint, "path", path, "error", err.Error())
+		}
+	}
+	return nil
+}
+
+func (g *gitLabProvider) fetchMergeRequests(ctx context.Context, path string, since, until time.Time) ([]*github.PullRequest, error) {
+	mergeRequests, err := g.listMergeRequests(ctx, path, since)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*github.PullRequest
+	for _, mr := range mergeRequests {
+		pullRequest := toPullRequest(mr)
+		if isPullRequestActive(pullRequest, since, until) {
+			result = append(result, pullRequest)
+		}
+	}
+	return result, nil
+}
+
+// listMergeRequests returns the merge requests of a merge requests endpoint that were updated since the given time.
+func (g *gitLabProvider) listMergeRequests(ctx context.Context, path string, since time.Time) ([]gitLabMergeRequest, error) {
+	query := url.Values{
+		"state":             {"all"},
+		"scope":             {"all"},
+		"updated_after":     {since.Format(time.RFC3339)},
+		"include_subgroups": {"true"},
+		"per_page":          {strconv.Itoa(resultsPerPage)},
+	}
+
+	var result []gitLabMergeRequest
+	for page := 1; page != 0; {
+		query.Set("page", strconv.Itoa(page))
+
+		var mergeRequests []gitLabMergeRequest
+		nextPage, err := g.get(ctx, path, query, &mergeRequests)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, mergeRequests...)
+
+		page = nextPage
+	}
+	return result, nil
+}
+
+// fetchReviews returns the approvals and diff comments on merge requests between since and until.
+// GitLab records approvals as system notes. Notes by the author of a merge request aren't included.
+func (g *gitLabProvider) fetchReviews(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]reviewActivity, error) {
+	var result []reviewActivity
+	err := g.forEachScope(ctx, owner, repo, isOrg, "merge_requests", func(path string) error {
+		mergeRequests, err := g.listMergeRequests(ctx, path, since)
+		if err != nil {
+			return err
+		}
+
+		for _, mr := range mergeRequests {
+			query := url.Values{"per_page": {strconv.Itoa(resultsPerPage)}}
+			for page := 1; page != 0; {
+				query.Set("page", strconv.Itoa(page))
+
+				var notes []gitLabNote
+				nextPage, err := g.get(ctx, fmt.Sprintf("projects/%d/merge_requests/%d/notes", mr.ProjectID, mr.IID), query, &notes)
+				if err != nil {
+					return err
+				}
+				for _, note := range notes {
+					if note.Author.Username == mr.Author.Username || !inRange(note.CreatedAt, since, until) {
+						continue
+					}
+					switch {
+					case note.System && note.Body == "approved this merge request":
+						result = append(result, reviewActivity{note.Author.Username, reviewStateApproved, note.CreatedAt})
+					case !note.System && note.Type == "DiffNote":
+						result = append(result, reviewActivity{note.Author.Username, "", note.CreatedAt})
+					}
+				}
+
+				page = nextPage
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// fetchFirstResponses returns the merge requests and issues opened between since and until, with the time of their first response.
+// Comments and approvals count as a response. Other system notes, and notes by the author or by bots, don't.
+func (g *gitLabProvider) fetchFirstResponses(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]contribution, error) {
+	var result []contribution
+	err := g.forEachScope(ctx, owner, repo, isOrg, "merge_requests", func(path string) error {
+		mergeRequests, err := g.listMergeRequests(ctx, path, since)
+		if err != nil {
+			return err
+		}
+		for _, mr := range mergeRequests {
+			if !inRange(mr.CreatedAt, since, until) {
+				continue
+			}
+			c := contribution{author: mr.Author.Username, title: mr.Title, url: mr.WebURL, isPullRequest: true, createdAt: mr.CreatedAt}
+			if mr.MergedAt != nil {
+				c.closedAt = *mr.MergedAt
+			} else if mr.ClosedAt != nil {
+				c.closedAt = *mr.ClosedAt
+			}
+			c.respondedAt, err = g.firstResponse(ctx, fmt.Sprintf("projects/%d/merge_requests/%d/notes", mr.ProjectID, mr.IID), mr.Author.Username)
+			if err != nil {
+				return err
+			}
+			result = append(result, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.forEachScope(ctx, owner, repo, isOrg, "issues", func(path string) error {
+		issues, err := g.fetchGitLabIssues(ctx, path, since, until)
+		if err != nil {
+			return err
+		}
+		for _, issue := range issues {
+			if !inRange(issue.GetCreatedAt(), since, until) {
+				continue
+			}
+			c := contribution{author: issue.GetUser().GetLogin(), title: issue.GetTitle(), url: issue.GetHTMLURL(), createdAt: issue.GetCreatedAt(), closedAt: issue.GetClosedAt()}
+			c.respondedAt, err = g.firstResponse(ctx, fmt.Sprintf("projects/%d/issues/%d/notes", issue.GetRepository().GetID(), issue.GetNumber()), c.author)
+			if err != nil {
+				return err
+			}
+			result = append(result, c)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// firstResponse returns the time of the first note of a notes endpoint by someone other than author, or zero if there is none.
+func (g *gitLabProvider) firstResponse(ctx context.Context, path, author string) (time.Time, error) {
+	query := url.Values{
+		"sort":     {"asc"},
+		"order_by": {"created_at"},
+		"per_page": {strconv.Itoa(resultsPerPage)},
+	}
+	bots := g.p.getBotFilter()
+	for page := 1; page != 0; {
+		query.Set("page", strconv.Itoa(page))
+
+		var notes []gitLabNote
+		nextPage, err := g.get(ctx, path, query, &notes)
+		if err != nil {
+			return time.Time{}, err
+		}
+		for _, note := range notes {
+			if note.Author.Username == author || bots.isBotLogin(note.Author.Username) {
+				continue
+			}
+			if !note.System || note.Body == "approved this merge request" {
+				return note.CreatedAt, nil
+			}
+		}
+
+		page = nextPage
+	}
+	return time.Time{}, nil
+}
+
+// fetchContributorProfile finds the commits of a user by the email addresses of the contributors of every project,
+// like commit authors are found in every other report. Merge requests are counted with a single request per group.
+func (g *gitLabProvider) fetchContributorProfile(ctx context.Context, owner string, isOrg bool, login string) (*contributorProfile, error) {
+	user, err := g.getUser(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	profile := newContributorProfile(user.Username, user.Name, user.AvatarURL)
+
+	repos, err := g.listRepositories(ctx, owner, isOrg)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	pr := getProgress(ctx)
+	pr.addRepos(len(repos))
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+
+			commits, err := g.fetchCommitsOfUser(ctx, owner, repo, profile.login)
+			pr.repoDone(len(commits), err)
+			if err != nil {
+				g.p.API.LogWarn("Failed to fetch commits", "repo", owner+"/"+repo, "error", err.Error())
+				return
+			}
+			if len(commits) == 0 {
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			profile.repos[repo] = len(commits)
+			profile.commits += len(commits)
+			for _, c := range commits {
+				profile.addCommit(owner, repo, c)
+			}
+		}(repo)
+	}
+	wg.Wait()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	err = g.forEachScope(ctx, owner, "", isOrg, "merge_requests", func(path string) error {
+		query := url.Values{
+			"state":             {"all"},
+			"scope":             {"all"},
+			"author_username":   {profile.login},
+			"include_subgroups": {"true"},
+			"per_page":          {strconv.Itoa(resultsPerPage)},
+		}
+		for page := 1; page != 0; {
+			query.Set("page", strconv.Itoa(page))
+
+			var mergeRequests []gitLabMergeRequest
+			nextPage, err := g.get(ctx, path, query, &mergeRequests)
+			if err != nil {
+				return err
+			}
+			profile.pullRequests += len(mergeRequests)
+			for _, mr := range mergeRequests {
+				profile.addActivity(mr.CreatedAt, mr.WebURL, "Merge request")
+			}
+
+			page = nextPage
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// fetchCommitsOfUser returns the commits to the default branch of a project by every email address of the contributors that belongs to the user.
+func (g *gitLabProvider) fetchCommitsOfUser(ctx context.Context, owner, repo, login string) ([]*github.RepositoryCommit, error) {
+	path := "projects/" + url.PathEscape(owner+"/"+repo) + "/repository/"
+
+	emails, err := g.contributorEmails(ctx, path, []string{login})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*github.RepositoryCommit
+	for email := range emails {
+		commits, err := g.fetchCommitsByEmail(ctx, path, email, url.Values{}, false)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, commits...)
+	}
+	return result, nil
+}
+
+// contributorEmails maps the email addresses of the contributors of a project that belong to one of the users to their login.
+func (g *gitLabProvider) contributorEmails(ctx context.Context, path string, logins []string) (map[string]string, error) {
+	result := map[string]string{}
+	query := url.Values{"per_page": {strconv.Itoa(resultsPerPage)}}
+	for page := 1; page != 0; {
+		query.Set("page", strconv.Itoa(page))
+
+		var contributors []gitLabContributor
+		nextPage, err := g.get(ctx, path+"contributors", query, &contributors)
+		if err != nil {
+			return nil, err
+		}
+		for _, contributor := range contributors {
+			user := g.findUserByEmail(ctx, contributor.Email)
+			if user == nil {
+				continue
+			}
+			for _, login := range logins {
+				if strings.EqualFold(user.GetLogin(), login) {
+					result[contributor.Email] = login
+				}
+			}
+		}
+
+		page = nextPage
+	}
+	return result, nil
+}
+
+// fetchCommitsByEmail returns the commits of a project that were authored with an email address. The query may restrict them further.
+// If first is set, it stops after the first commit found.
+func (g *gitLabProvider) fetchCommitsByEmail(ctx context.Context, path, email string, query url.Values, first bool) ([]*github.RepositoryCommit, error) {
+	query.Set("author", email)
+	query.Set("per_page", strconv.Itoa(resultsPerPage))
+
+	var result []*github.RepositoryCommit
+	for page := 1; page != 0; {
+		query.Set("page", strconv.Itoa(page))
+
+		var commits []gitLabCommit
+		nextPage, err := g.get(ctx, path+"commits", query, &commits)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range commits {
+			// The author filter also matches parts of names and email addresses
+			if strings.EqualFold(c.AuthorEmail, email) {
+				result = append(result, c.toRepositoryCommit())
+				if first {
+					return g.resolveAuthors(ctx, result), nil
+				}
+			}
+		}
+
+		page = nextPage
+	}
+	return g.resolveAuthors(ctx, result), nil
+}
+
+func (g *gitLabProvider) lookupUserByEmail(ctx context.Context, email string) (*github.User, error) {
+	return g.findUserByEmail(ctx, email), nil
+}
+
+func (g *gitLabProvider) fetchMembers(ctx context.Context, group string) ([]string, error) {
+	var result []string
+	query := url.Values{"per_page": {strconv.Itoa(resultsPerPage)}}
+	for page := 1; page != 0; {
+		query.Set("page", strconv.Itoa(page))
+
+		var members []*gitLabUser
+		nextPage, err := g.get(ctx, "groups/"+url.PathEscape(group)+"/members/all", query, &members)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			result = append(result, member.Username)
+		}
+
+		page = nextPage
+	}
+	return result, nil
+}
+
+// toPullRequest converts a merge request. Like on GitHub, merged merge requests count as closed when they were merged.
+func toPullRequest(mr gitLabMergeRequest) *github.PullRequest {
+	createdAt := mr.CreatedAt
+	updatedAt := mr.UpdatedAt
+	closedAt := mr.ClosedAt
+	if mr.MergedAt != nil {
+		closedAt = mr.MergedAt
+	}
+
+	return &github.PullRequest{
+		Number:    github.Int(mr.IID),
+		Title:     github.String(mr.Title),
+		State:     github.String(mr.State),
+		HTMLURL:   github.String(mr.WebURL),
+		User:      &github.User{ID: github.Int64(mr.Author.ID), Login: github.String(mr.Author.Username), AvatarURL: github.String(mr.Author.AvatarURL)},
+		CreatedAt: &createdAt,
+		UpdatedAt: &updatedAt,
+		MergedAt:  mr.MergedAt,
+		ClosedAt:  closedAt,
+		Merged:    github.Bool(mr.MergedAt != nil),
+	}
+}
+
+func (g *gitLabProvider) fetchGitLabIssues(ctx context.Context, path string, since, until time.Time) ([]*github.Issue, error) {
+	query := url.Values{
+		"scope":             {"all"},
+		"updated_after":     {since.Format(time.RFC3339)},
+		"include_subgroups": {"true"},
+		"per_page":          {strconv.Itoa(resultsPerPage)},
+	}
+
+	var result []*github.Issue
+	for page := 1; page != 0; {
+		query.Set("page", strconv.Itoa(page))
+
+		var issues []gitLabIssue
+		nextPage, err := g.get(ctx, path, query, &issues)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range issues {
+			issue := toIssue(i)
+			if isIssueActive(issue, since, until) {
+				result = append(result, issue)
+			}
+		}
+
+		page = nextPage
+	}
+	return result, nil
+}
+
+func toIssue(i gitLabIssue) *github.Issue {
+	createdAt := i.CreatedAt
+	updatedAt := i.UpdatedAt
+
+	var labels []*github.Label
+	for _, label := range i.Labels {
+		labels = append(labels, &github.Label{Name: github.String(label)})
+	}
+
+	return &github.Issue{
+		Number:    github.Int(i.IID),
+		Title:     github.String(i.Title),
+		State:     github.String(i.State),
+		HTMLURL:   github.String(i.WebURL),
+		User:      &github.User{ID: github.Int64(i.Author.ID), Login: github.String(i.Author.Username), AvatarURL: github.String(i.Author.AvatarURL)},
+		Labels:    labels,
+		CreatedAt: &createdAt,
+		UpdatedAt: &updatedAt,
+		ClosedAt:  i.ClosedAt,
+		// The project of an issue is needed to fetch its notes
+		Repository: &github.Repository{ID: github.Int64(i.ProjectID)},
+	}
+}
+
+// findUserByEmail looks up the user with the given email address. nil is returned if none is found.
+// Only public email addresses can be found, unless the token belongs to an administrator.
+func (g *gitLabProvider) findUserByEmail(ctx context.Context, email string) *github.User {
+	email = strings.ToLower(email)
+
+	g.usersLock.Lock()
+	user, ok := g.usersByEmail[email]
+	g.usersLock.Unlock()
+	if ok {
+		return user
+	}
+
+	var users []*gitLabUser
+	if _, err := g.get(ctx, "users", url.Values{"search": {email}}, &users); err != nil {
+		g.p.API.LogDebug("Failed to search GitLab user", "error", err.Error())
+	}
+	if len(users) == 1 {
+		user = &github.User{
+			ID:        github.Int64(users[0].ID),
+			Login:     github.String(users[0].Username),
+			AvatarURL: github.String(users[0].AvatarURL),
+		}
+	}
+
+	g.usersLock.Lock()
+	g.usersByEmail[email] = user
+	g.usersLock.Unlock()
+	return user
+}
+
+// resolveAuthors returns copies of commits that are linked to the users of their author email addresses.
+// Authors are resolved for every report rather than cached with the commits, so that users who add an email address later are found.
+func (g *gitLabProvider) resolveAuthors(ctx context.Context, commits []*github.RepositoryCommit) []*github.RepositoryCommit {
+	result := make([]*github.RepositoryCommit, 0, len(commits))
+	for _, c := range commits {
+		resolved := *c
+		resolved.Author = g.findUserByEmail(ctx, c.GetCommit().GetAuthor().GetEmail())
+		result = append(result, &resolved)
+	}
+	return result
+}
+
+// findFirstContributions finds the contributors of a group whose first commit is after since.
+// A contributor has contributed earlier, if a project has a commit before since by one of their email addresses.
+func (g *gitLabProvider) findFirstContributions(ctx context.Context, org string, repos []string, since time.Time) (map[string]firstContributionInfo, error) {
+	if repos == nil {
+		var err error
+		repos, err = g.listRepositories(ctx, org, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	commits := g.fetchCommitsFromProjects(ctx, org, repos, since, time.Now())
+
+	firstContributions := map[string]firstContributionInfo{}
+	for _, repo := range repos {
+		for _, c := range commits[repo] {
+			author := c.GetAuthor()
+			if author == nil {
+				continue
+			}
+			login := author.GetLogin()
+
+			date := commitDate(c)
+			firstContribution, contains := firstContributions[login]
+			if !contains || firstContribution.date.After(date) {
+				firstContributions[login] = firstContributionInfo{login, date, c.GetHTMLURL(), org, repo}
+			}
+		}
+	}
+	if len(firstContributions) == 0 {
+		return firstContributions, ctx.Err()
+	}
+
+	var logins []string
+	for login := range firstContributions {
+		logins = append(logins, login)
+	}
+	// The until filter includes commits at the given time
+	earlier, err := g.contributedBefore(ctx, org, repos, logins, since.Add(-time.Second))
+	if err != nil {
+		return nil, err
+	}
+	for login := range earlier {
+		delete(firstContributions, login)
+	}
+
+	return firstContributions, nil
+}
+
+// contributedBefore looks up the email addresses of the users among the contributors of every project,
+// and checks whether a commit by one of them is older than before.
+func (g *gitLabProvider) contributedBefore(ctx context.Context, org string, repos, logins []string, before time.Time) (map[string]bool, error) {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	result := map[string]bool{}
+	var firstErr error
+
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+
+			err := g.contributedBeforeToProject(ctx, org, repo, logins, before, &lock, result)
+			if err != nil {
+				lock.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				lock.Unlock()
+			}
+		}(repo)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+func (g *gitLabProvider) contributedBeforeToProject(ctx context.Context, org, repo string, logins []string, before time.Time, lock *sync.Mutex, result map[string]bool) error {
+	path := "projects/" + url.PathEscape(org+"/"+repo) + "/repository/"
+
+	emails, err := g.contributorEmails(ctx, path, logins)
+	if isGitLabNotFound(err) {
+		// Empty projects have no repository
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for email, login := range emails {
+		lock.Lock()
+		found := result[login]
+		lock.Unlock()
+		if found {
+			continue
+		}
+
+		commits, err := g.fetchCommitsByEmail(ctx, path, email, url.Values{"until": {before.Format(time.RFC3339)}}, true)
+		if err != nil {
+			return err
+		}
+		if len(commits) > 0 {
+			lock.Lock()
+			result[login] = true
+			lock.Unlock()
+		}
+	}
+	return nil
+}
+
+func (g *gitLabProvider) resolveAuthor(ctx context.Context, _, _, _, email string) (*github.User, error) {
+	return g.findUserByEmail(ctx, email), nil
+}
+
+func (g *gitLabProvider) fetchTeamMembers(ctx context.Context, org string, teams []string) ([]string, error) {
+	var result []string
+	for _, team := range teams {
+		if team == "" {
+			continue
+		}
+
+		query := url.Values{"per_page": {strconv.Itoa(resultsPerPage)}}
+		for page := 1; page != 0; {
+			query.Set("page", strconv.Itoa(page))
+
+			var members []*gitLabUser
+			nextPage, err := g.get(ctx, "groups/"+url.PathEscape(org+"/"+team)+"/members/all", query, &members)
+			if isGitLabNotFound(err) {
+				g.p.API.LogWarn("GitLab subgroup not found", "group", org+"/"+team)
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, member := range members {
+				result = append(result, member.Username)
+			}
+
+			page = nextPage
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestGitLabProvider returns a provider that sends its requests to handler. Requests are passed without the api/v4 prefix.
+func newTestGitLabProvider(t *testing.T, handler http.HandlerFunc) *gitLabProvider {
+	mux := http.NewServeMux()
+	mux.Handle("/api/v4/", http.StripPrefix("/api/v4", handler))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	api := &plugintest.API{}
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Maybe()
+	api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	p := &Plugin{scheduler: newScheduler(2, func(string, ...interface{}) {})}
+	p.SetAPI(api)
+
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	return &gitLabProvider{
+		p:            p,
+		baseURL:      baseURL,
+		client:       server.Client(),
+		usersByEmail: map[string]*github.User{},
+	}
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	require.NoError(t, json.NewEncoder(w).Encode(v))
+}
+
+// gitLabUsers serves the users search by email address.
+func gitLabUsers(t *testing.T, w http.ResponseWriter, r *http.Request, usersByEmail map[string]string) {
+	var users []gitLabUser
+	if login, ok := usersByEmail[r.URL.Query().Get("search")]; ok {
+		users = append(users, gitLabUser{ID: 1, Username: login})
+	}
+	writeJSON(t, w, users)
+}
+
+func TestGitLabContributedBefore(t *testing.T) {
+	before := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	usersByEmail := map[string]string{
+		"jane@example.com": "jane",
+		"john@example.com": "john",
+		"old@example.com":  "old",
+	}
+
+	g := newTestGitLabProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/users":
+			gitLabUsers(t, w, r, usersByEmail)
+		case "/projects/group%2Fserver/repository/contributors":
+			writeJSON(t, w, []gitLabContributor{
+				{Email: "jane@example.com", Commits: 3},
+				{Email: "john@example.com", Commits: 1},
+				{Email: "old@example.com", Commits: 1},
+			})
+		case "/projects/group%2Fserver/repository/commits":
+			assert.Equal(t, before.Format(time.RFC3339), r.URL.Query().Get("until"))
+			var commits []gitLabCommit
+			switch r.URL.Query().Get("author") {
+			case "jane@example.com":
+				// The author filter matches parts of email addresses, too
+				commits = []gitLabCommit{{ID: "a", AuthorEmail: "mary-jane@example.com"}, {ID: "b", AuthorEmail: "jane@example.com"}}
+			case "john@example.com":
+				commits = []gitLabCommit{{ID: "c", AuthorEmail: "big-john@example.com"}}
+			}
+			writeJSON(t, w, commits)
+		case "/projects/group%2Fempty/repository/contributors":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			t.Errorf("unexpected request %v", r.URL)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	earlier, err := g.contributedBefore(context.Background(), "group", []string{"server", "empty"}, []string{"jane", "john", "alice"}, before)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"jane": true}, earlier)
+}
+
+func TestGitLabFindFirstContributions(t *testing.T) {
+	since := time.Now().AddDate(0, 0, -30).UTC().Truncate(time.Second)
+	usersByEmail := map[string]string{
+		"jane@example.com":   "jane",
+		"newbie@example.com": "newbie",
+	}
+
+	g := newTestGitLabProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/users":
+			gitLabUsers(t, w, r, usersByEmail)
+		case "/projects/group%2Fserver/repository/contributors":
+			// The contributors count every commit, so jane and newbie look alike
+			writeJSON(t, w, []gitLabContributor{
+				{Email: "jane@example.com", Commits: 2},
+				{Email: "newbie@example.com", Commits: 2},
+			})
+		case "/projects/group%2Fserver/repository/commits":
+			query := r.URL.Query()
+			if query.Get("author") == "" {
+				writeJSON(t, w, []gitLabCommit{
+					{ID: "a", AuthorEmail: "jane@example.com", CommittedDate: since.AddDate(0, 0, 5), WebURL: "https://gitlab.example.com/group/server/-/commit/a"},
+					{ID: "b", AuthorEmail: "newbie@example.com", CommittedDate: since.AddDate(0, 0, 6), WebURL: "https://gitlab.example.com/group/server/-/commit/b"},
+					{ID: "c", AuthorEmail: "newbie@example.com", CommittedDate: since.AddDate(0, 0, 7), WebURL: "https://gitlab.example.com/group/server/-/commit/c"},
+				})
+				return
+			}
+
+			// Earlier commits are checked explicitly
+			assert.Equal(t, since.Add(-time.Second).Format(time.RFC3339), query.Get("until"))
+			var commits []gitLabCommit
+			if query.Get("author") == "jane@example.com" {
+				commits = []gitLabCommit{{ID: "old", AuthorEmail: "jane@example.com"}}
+			}
+			writeJSON(t, w, commits)
+		default:
+			t.Errorf("unexpected request %v", r.URL)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	api := g.p.API.(*plugintest.API)
+	allowLogs(api)
+	kv := newTestKVStore(api)
+
+	first, err := g.findFirstContributions(context.Background(), "group", []string{"server"}, since)
+	require.NoError(t, err)
+	require.Len(t, first, 1)
+	assert.Equal(t, "https://gitlab.example.com/group/server/-/commit/b", first["newbie"].commit)
+
+	// Authors are resolved for every report instead of being cached with the commits
+	var shards int
+	for key, value := range kv.data {
+		if strings.HasPrefix(key, commitShardKeyPrefix) {
+			shards++
+			assert.Contains(t, string(value), "newbie@example.com")
+			assert.NotContains(t, string(value), `"login"`)
+		}
+	}
+	assert.NotZero(t, shards)
+}
+
+func TestGitLabListRepositories(t *testing.T) {
+	g := newTestGitLabProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/groups/group/projects":
+			assert.Equal(t, "true", r.URL.Query().Get("include_subgroups"))
+			// GitLab leaves out X-Next-Page on the last page
+			if r.URL.Query().Get("page") == "1" {
+				w.Header().Set("X-Next-Page", "2")
+				writeJSON(t, w, []gitLabProject{{PathWithNamespace: "group/server"}, {PathWithNamespace: "group/sub/webapp"}})
+				return
+			}
+			assert.Equal(t, "2", r.URL.Query().Get("page"))
+			writeJSON(t, w, []gitLabProject{{PathWithNamespace: "group/sub/deep/docs"}})
+		case "/users/jane/projects":
+			assert.Empty(t, r.URL.Query().Get("include_subgroups"))
+			writeJSON(t, w, []gitLabProject{{PathWithNamespace: "jane/dotfiles"}})
+		default:
+			t.Errorf("unexpected request %v", r.URL)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	repos, err := g.listRepositories(context.Background(), "group", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server", "sub/webapp", "sub/deep/docs"}, repos)
+
+	repos, err = g.listRepositories(context.Background(), "jane", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dotfiles"}, repos)
+}
+
+func TestGitLabGetErrors(t *testing.T) {
+	g := newTestGitLabProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/groups/missing":
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(t, w, map[string]string{"message": "404 Group Not Found"})
+		case "/groups/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("<html>Internal Server Error</html>"))
+		case "/users":
+			writeJSON(t, w, []gitLabUser{})
+		default:
+			t.Errorf("unexpected request %v", r.URL)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	_, err := g.getGroup(context.Background(), "missing")
+	require.Error(t, err)
+	assert.True(t, isGitLabNotFound(err))
+	assert.Contains(t, err.Error(), "404 Group Not Found")
+
+	_, err = g.getGroup(context.Background(), "broken")
+	require.Error(t, err)
+	assert.False(t, isGitLabNotFound(err))
+	assert.Equal(t, http.StatusInternalServerError, err.(*gitLabError).Response.StatusCode)
+
+	isOrg, err := g.verifyOwner(context.Background(), "missing")
+	assert.Error(t, err)
+	assert.True(t, isOrg)
+}
+
+func TestGitLabFirstResponse(t *testing.T) {
+	created := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	note := func(author, body string, system bool, minutes int) gitLabNote {
+		return gitLabNote{Author: gitLabUser{Username: author}, Body: body, System: system, CreatedAt: created.Add(time.Duration(minutes) * time.Minute)}
+	}
+
+	notes := map[string][][]gitLabNote{
+		"/projects/1/merge_requests/1/notes": {
+			{
+				note("jane", "Ready for review", false, 1),
+				note("ci", "added 1 commit", true, 2),
+				note("renovate[bot]", "Updated the dependencies", false, 3),
+			},
+			{
+				note("john", "approved this merge request", true, 4),
+				note("john", "Looks good", false, 5),
+			},
+		},
+		"/projects/1/merge_requests/2/notes": {
+			{
+				note("john", "changed the description", true, 1),
+				note("alice", "Why?", false, 2),
+			},
+		},
+		"/projects/1/issues/3/notes": {
+			{note("jane", "Any news?", false, 1)},
+		},
+	}
+
+	g := newTestGitLabProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		pages, ok := notes[r.URL.EscapedPath()]
+		if !ok {
+			t.Errorf("unexpected request %v", r.URL)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		assert.Equal(t, "asc", r.URL.Query().Get("sort"))
+		page := 1
+		if r.URL.Query().Get("page") == "2" {
+			page = 2
+		}
+		if page < len(pages) {
+			w.Header().Set("X-Next-Page", "2")
+		}
+		writeJSON(t, w, pages[page-1])
+	})
+
+	tests := map[string]struct {
+		path     string
+		expected time.Time
+	}{
+		"approvals on later pages count as a response": {"projects/1/merge_requests/1/notes", created.Add(4 * time.Minute)},
+		"other system notes don't count":               {"projects/1/merge_requests/2/notes", created.Add(2 * time.Minute)},
+		"notes by the author don't count":              {"projects/1/issues/3/notes", time.Time{}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			respondedAt, err := g.firstResponse(context.Background(), test.path, "jane")
+			require.NoError(t, err)
+			assert.True(t, test.expected.Equal(respondedAt), "expected %v, got %v", test.expected, respondedAt)
+		})
+	}
+}
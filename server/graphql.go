@@ -0,0 +1,637 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+const (
+	// graphQLEndpoint is relative to the REST API. It resolves to https://api.github.com/graphql for github.com
+	// and to https://[hostname]/api/graphql for GitHub Enterprise Server, whose REST API is served at /api/v3/.
+	graphQLEndpoint = "../graphql"
+
+	// graphQLReposPerQuery is the number of repositories whose history is fetched with a single query.
+	graphQLReposPerQuery = 20
+
+	// graphQLAuthorsPerQuery is the number of earlier contribution checks done with a single query.
+	graphQLAuthorsPerQuery = 50
+
+	// graphQLCommitsPerQuery is the number of commits whose stats are fetched with a single query.
+	graphQLCommitsPerQuery = 100
+
+	// graphQLUndefinedField is the error code of queries with a field the API doesn't know,
+	// e.g. a field that older GitHub Enterprise Server versions don't have yet.
+	graphQLUndefinedField = "undefinedField"
+)
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+type graphQLError struct {
+	Type       string        `json:"type"`
+	Path       []interface{} `json:"path"`
+	Message    string        `json:"message"`
+	Extensions struct {
+		Code string `json:"code"`
+	} `json:"extensions"`
+}
+
+// graphQLQueryError is returned if a query failed as a whole, e.g. because it isn't valid.
+type graphQLQueryError struct {
+	graphQLError
+}
+
+func (e *graphQLQueryError) Error() string {
+	return "graphql query failed: " + e.Message
+}
+
+// isGraphQLUndefinedFieldError returns true if err is returned for a query with a field the API doesn't know.
+func isGraphQLUndefinedFieldError(err error) bool {
+	queryErr, ok := err.(*graphQLQueryError)
+	return ok && queryErr.Extensions.Code == graphQLUndefinedField
+}
+
+type graphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []graphQLError             `json:"errors"`
+}
+
+type graphQLCommit struct {
+	OID           string    `json:"oid"`
+	URL           string    `json:"url"`
+	Message       string    `json:"message"`
+	CommittedDate time.Time `json:"committedDate"`
+	Author        struct {
+		Name  string    `json:"name"`
+		Email string    `json:"email"`
+		Date  time.Time `json:"date"`
+		User  *struct {
+			Typename   string `json:"__typename"`
+			ID         string `json:"id"`
+			DatabaseID int64  `json:"databaseId"`
+			Login      string `json:"login"`
+		} `json:"user"`
+	} `json:"author"`
+	Committer struct {
+		Name  string    `json:"name"`
+		Email string    `json:"email"`
+		Date  time.Time `json:"date"`
+	} `json:"committer"`
+}
+
+type graphQLHistory struct {
+	PageInfo struct {
+		HasNextPage bool   `json:"hasNextPage"`
+		EndCursor   string `json:"endCursor"`
+	} `json:"pageInfo"`
+	Nodes []graphQLCommit `json:"nodes"`
+}
+
+type graphQLRepository struct {
+	DefaultBranchRef *struct {
+		Target struct {
+			History *graphQLHistory `json:"history"`
+		} `json:"target"`
+	} `json:"defaultBranchRef"`
+}
+
+// historyRequest tracks the commits of one repository and time range that are fetched with GraphQL.
+type historyRequest struct {
+	repo    string
+	r       timeRange
+	cursor  string
+	done    bool
+	err     error
+	commits []*github.RepositoryCommit
+}
+
+// useGraphQL returns true if the GitHub client is authenticated, which is required by the GraphQL API.
+func (p *Plugin) useGraphQL() bool {
+	return p.isGitHubPluginRunning() || p.getConfiguration().Token != ""
+}
+
+// queryGraphQL runs a GraphQL query and returns the data of every top level field.
+// Fields that couldn't be resolved are reported in the returned errors.
+func (p *Plugin) queryGraphQL(ctx context.Context, client *github.Client, query string, variables map[string]interface{}) (*graphQLResponse, error) {
+	var result *graphQLResponse
+	err := p.scheduler.do(ctx, func() (*github.Response, error) {
+		req, err := client.NewRequest(http.MethodPost, graphQLEndpoint, graphQLRequest{query, variables})
+		if err != nil {
+			return nil, err
+		}
+
+		result = &graphQLResponse{}
+		resp, err := client.Do(ctx, req, result)
+		if err != nil {
+			return resp, err
+		}
+
+		for _, e := range result.Errors {
+			if e.Type == "RATE_LIMITED" {
+				return resp, &github.RateLimitError{
+					Rate:     resp.Rate,
+					Response: resp.Response,
+					Message:  e.Message,
+				}
+			}
+		}
+		return resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Data == nil && len(result.Errors) > 0 {
+		return nil, &graphQLQueryError{result.Errors[0]}
+	}
+	return result, nil
+}
+
+// fieldErrors maps top level fields to the error that occurred while resolving them.
+func (r *graphQLResponse) fieldErrors() map[string]error {
+	result := map[string]error{}
+	for _, e := range r.Errors {
+		if len(e.Path) == 0 {
+			continue
+		}
+		if field, ok := e.Path[0].(string); ok {
+			result[field] = fmt.Errorf("%v", e.Message)
+		}
+	}
+	return result
+}
+
+// fetchCommitHistoriesGraphQL fetches the commits between since and until of many repositories of owner at once.
+// Only commits that aren't in the commit cache yet are fetched. The commits are returned per repository.
+// Repositories that couldn't be fetched are returned in the error map.
+func (p *Plugin) fetchCommitHistoriesGraphQL(ctx context.Context, client *github.Client, owner string, repos []string, since, until time.Time) (map[string][]*github.RepositoryCommit, map[string]error, error) {
+	pr := getProgress(ctx)
+	pr.addRepos(len(repos))
+
+	caches := map[string]*commitCache{}
+	pendingRequests := map[string]int{}
+	var requests []*historyRequest
+	now := time.Now()
+	for _, repo := range repos {
+		cache := p.getCommitCache(client.BaseURL.Host, owner, repo, since, until)
+		caches[repo] = cache
+
+		for _, r := range cache.missing(since, until, now) {
+			requests = append(requests, &historyRequest{repo: repo, r: r})
+			pendingRequests[repo]++
+		}
+		if pendingRequests[repo] == 0 {
+			pr.repoDone(len(cache.between(since, until)), nil)
+		}
+	}
+
+	for {
+		var pending []*historyRequest
+		for _, req := range requests {
+			if !req.done {
+				pending = append(pending, req)
+			}
+			if len(pending) == graphQLReposPerQuery {
+				break
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		if err := p.fetchHistoryPages(ctx, client, owner, pending); err != nil {
+			return nil, nil, err
+		}
+
+		for _, req := range pending {
+			if !req.done {
+				continue
+			}
+			if pendingRequests[req.repo]--; pendingRequests[req.repo] == 0 {
+				commits, err := 0, error(nil)
+				for _, r := range requests {
+					if r.repo == req.repo {
+						commits += len(r.commits)
+						if r.err != nil {
+							err = r.err
+						}
+					}
+				}
+				pr.repoDone(commits, err)
+			}
+		}
+	}
+
+	failed := map[string]error{}
+	for _, req := range requests {
+		if req.err != nil {
+			failed[req.repo] = req.err
+		}
+	}
+
+	fetched := map[string][]*historyRequest{}
+	for _, req := range requests {
+		if failed[req.repo] == nil {
+			fetched[req.repo] = append(fetched[req.repo], req)
+		}
+	}
+
+	result := map[string][]*github.RepositoryCommit{}
+	for _, repo := range repos {
+		if failed[repo] != nil {
+			continue
+		}
+
+		if reqs := fetched[repo]; len(reqs) > 0 || len(caches[repo].dirty) > 0 {
+			caches[repo] = p.updateCommitCache(ctx, client.BaseURL.Host, owner, repo, since, until, func(cache *commitCache) {
+				for _, req := range reqs {
+					cache.add(req.r, req.commits)
+				}
+			})
+		}
+		result[repo] = caches[repo].between(since, until)
+	}
+
+	return result, failed, nil
+}
+
+// fetchHistoryPages fetches the next page of commits for every request with a single query.
+func (p *Plugin) fetchHistoryPages(ctx context.Context, client *github.Client, owner string, requests []*historyRequest) error {
+	var params []string
+	var fields []string
+	variables := map[string]interface{}{}
+	for i, req := range requests {
+		params = append(params, fmt.Sprintf("$o%[1]d: String!, $n%[1]d: String!, $s%[1]d: GitTimestamp!, $u%[1]d: GitTimestamp!, $c%[1]d: String", i))
+		fields = append(fields, fmt.Sprintf(`r%[1]d: repository(owner: $o%[1]d, name: $n%[1]d) {
+	defaultBranchRef { target { ... on Commit {
+		history(first: %[2]d, since: $s%[1]d, until: $u%[1]d, after: $c%[1]d) { ...history }
+	} } }
+}`, i, resultsPerPage))
+
+		variables[fmt.Sprintf("o%d", i)] = owner
+		variables[fmt.Sprintf("n%d", i)] = req.repo
+		variables[fmt.Sprintf("s%d", i)] = req.r.since
+		variables[fmt.Sprintf("u%d", i)] = req.r.until
+		if req.cursor != "" {
+			variables[fmt.Sprintf("c%d", i)] = req.cursor
+		}
+	}
+
+	query := fmt.Sprintf(`query(%s) {
+%s
+}
+
+fragment history on CommitHistoryConnection {
+	pageInfo { hasNextPage endCursor }
+	nodes {
+		oid url message committedDate
+		author { name email date user { __typename id databaseId login } }
+		committer { name email date }
+	}
+}`, strings.Join(params, ", "), strings.Join(fields, "\n"))
+
+	response, err := p.queryGraphQL(ctx, client, query, variables)
+	if err != nil {
+		return err
+	}
+	fieldErrors := response.fieldErrors()
+
+	for i, req := range requests {
+		field := fmt.Sprintf("r%d", i)
+
+		var repository *graphQLRepository
+		if data, ok := response.Data[field]; ok {
+			if err := json.Unmarshal(data, &repository); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case repository == nil:
+			req.err = fmt.Errorf("repository %v/%v not found", owner, req.repo)
+			if fieldErrors[field] != nil {
+				req.err = fmt.Errorf("failed to fetch repository %v/%v: %v", owner, req.repo, fieldErrors[field])
+			}
+			req.done = true
+		case repository.DefaultBranchRef == nil || repository.DefaultBranchRef.Target.History == nil:
+			// Empty repository
+			req.done = true
+		default:
+			history := repository.DefaultBranchRef.Target.History
+			for _, c := range history.Nodes {
+				req.commits = append(req.commits, c.toRepositoryCommit())
+			}
+			req.cursor = history.PageInfo.EndCursor
+			req.done = !history.PageInfo.HasNextPage
+		}
+	}
+	return nil
+}
+
+func (c graphQLCommit) toRepositoryCommit() *github.RepositoryCommit {
+	commit := &github.RepositoryCommit{
+		SHA:     github.String(c.OID),
+		HTMLURL: github.String(c.URL),
+		Commit: &github.Commit{
+			Message: github.String(c.Message),
+			Author: &github.CommitAuthor{
+				Name:  github.String(c.Author.Name),
+				Email: github.String(c.Author.Email),
+				Date:  &c.Author.Date,
+			},
+			Committer: &github.CommitAuthor{
+				Name:  github.String(c.Committer.Name),
+				Email: github.String(c.Committer.Email),
+				Date:  &c.CommittedDate,
+			},
+		},
+	}
+
+	switch {
+	case c.Author.User != nil:
+		commit.Author = &github.User{
+			ID:     github.Int64(c.Author.User.DatabaseID),
+			NodeID: github.String(c.Author.User.ID),
+			Login:  github.String(c.Author.User.Login),
+			Type:   github.String(c.Author.User.Typename),
+		}
+	case strings.HasSuffix(strings.ToLower(c.Author.Email), gitHubBotEmailSuffix):
+		// GitHub Apps aren't users, so GraphQL doesn't link their commits. The REST API links them to the bot.
+		if match := gitHubNoReplyEmail.FindStringSubmatch(c.Author.Email); match != nil {
+			commit.Author = &github.User{
+				Login: github.String(match[1]),
+				Type:  github.String(gitHubBotType),
+			}
+		}
+	}
+	return commit
+}
+
+// fetchCommitStatsGraphQL fetches the stats of many commits of a repository at once.
+// Commits that can't be found are left out.
+func (p *Plugin) fetchCommitStatsGraphQL(ctx context.Context, client *github.Client, owner, repo string, shas []string) (map[string]commitStats, error) {
+	result := map[string]commitStats{}
+
+	for start := 0; start < len(shas); start += graphQLCommitsPerQuery {
+		end := start + graphQLCommitsPerQuery
+		if end > len(shas) {
+			end = len(shas)
+		}
+		batch := shas[start:end]
+
+		var params []string
+		var fields []string
+		variables := map[string]interface{}{
+			"owner": owner,
+			"name":  repo,
+		}
+		for i, sha := range batch {
+			params = append(params, fmt.Sprintf("$s%d: GitObjectID!", i))
+			fields = append(fields, fmt.Sprintf("\tc%[1]d: object(oid: $s%[1]d) { ... on Commit { additions deletions changedFilesIfAvailable } }", i))
+			variables[fmt.Sprintf("s%d", i)] = sha
+		}
+
+		query := fmt.Sprintf("query($owner: String!, $name: String!, %s) {\nrepository(owner: $owner, name: $name) {\n%s\n}\n}", strings.Join(params, ", "), strings.Join(fields, "\n"))
+		response, err := p.queryGraphQL(ctx, client, query, variables)
+		if err != nil {
+			return nil, err
+		}
+
+		var repository map[string]*struct {
+			Additions               int  `json:"additions"`
+			Deletions               int  `json:"deletions"`
+			ChangedFilesIfAvailable *int `json:"changedFilesIfAvailable"`
+		}
+		if data, ok := response.Data["repository"]; ok {
+			if err := json.Unmarshal(data, &repository); err != nil {
+				return nil, err
+			}
+		}
+		if repository == nil {
+			return nil, fmt.Errorf("repository %v/%v not found", owner, repo)
+		}
+
+		for i, sha := range batch {
+			commit := repository[fmt.Sprintf("c%d", i)]
+			if commit == nil {
+				continue
+			}
+			stats := commitStats{Additions: commit.Additions, Deletions: commit.Deletions}
+			if commit.ChangedFilesIfAvailable != nil {
+				stats.Files = *commit.ChangedFilesIfAvailable
+			}
+			result[sha] = stats
+		}
+	}
+
+	return result, nil
+}
+
+// findFirstContributionsGraphQL finds the contributors of an organization whose first commit is after since.
+// In contrast to findFirstContributions, only contributors with a commit after since are checked,
+// and many of these checks are done with a single query.
+func (p *Plugin) findFirstContributionsGraphQL(ctx context.Context, client *github.Client, contributors map[string][]*github.Contributor, org string, since time.Time) (map[string]firstContributionInfo, error) {
+	var repos []string
+	for repo := range contributors {
+		repos = append(repos, repo)
+	}
+
+	histories, failed, err := p.fetchCommitHistoriesGraphQL(ctx, client, org, repos, since, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for repo, repoErr := range failed {
+		p.API.LogWarn("Failed to fetch commits", "repo", org+"/"+repo, "error", repoErr.Error())
+	}
+
+	firstContributions := map[string]firstContributionInfo{}
+	nodeIDs := map[string]string{}
+	emails := map[string][]string{}
+	knownEmails := map[string]bool{}
+	for repo, commits := range histories {
+		for _, c := range commits {
+			author := c.GetAuthor()
+			if author == nil {
+				continue
+			}
+			login := author.GetLogin()
+			if author.GetNodeID() != "" {
+				nodeIDs[login] = author.GetNodeID()
+			}
+			if email := c.GetCommit().GetAuthor().GetEmail(); email != "" && !knownEmails[login+" "+email] {
+				knownEmails[login+" "+email] = true
+				emails[login] = append(emails[login], email)
+			}
+
+			date := commitDate(c)
+			firstContribution, contains := firstContributions[login]
+			if !contains || firstContribution.date.After(date) {
+				firstContributions[login] = firstContributionInfo{login, date, c.GetHTMLURL(), org, repo}
+			}
+		}
+	}
+
+	var candidates []string
+	for login := range firstContributions {
+		candidates = append(candidates, login)
+	}
+	if err := p.resolveNodeIDs(ctx, client, candidates, nodeIDs); err != nil {
+		return nil, err
+	}
+
+	// Authors without a node ID, e.g. bots and renamed users, are checked by the email addresses of their commits.
+	// Authors that can't be checked at all are left out.
+	for login := range firstContributions {
+		if nodeIDs[login] == "" && len(emails[login]) == 0 {
+			delete(firstContributions, login)
+		}
+	}
+
+	var checks []earlierContributionCheck
+	for repo, repoContributors := range contributors {
+		for _, contributor := range repoContributors {
+			login := contributor.GetLogin()
+			if _, ok := firstContributions[login]; ok {
+				checks = append(checks, earlierContributionCheck{repo, login})
+			}
+		}
+	}
+
+	earlier, err := p.checkEarlierContributionsGraphQL(ctx, client, org, checks, nodeIDs, emails, since)
+	if err != nil {
+		return nil, err
+	}
+	for login := range earlier {
+		delete(firstContributions, login)
+	}
+
+	return firstContributions, nil
+}
+
+type earlierContributionCheck struct {
+	repo   string
+	author string
+}
+
+// checkEarlierContributionsGraphQL returns the authors that committed to a repository before the given time.
+// Many checks are done with a single query. Authors are identified by their GraphQL node ID, or by their email addresses if they have none.
+func (p *Plugin) checkEarlierContributionsGraphQL(ctx context.Context, client *github.Client, org string, checks []earlierContributionCheck, nodeIDs map[string]string, emails map[string][]string, before time.Time) (map[string]bool, error) {
+	earlier := map[string]bool{}
+
+	for start := 0; start < len(checks); {
+		var batch []earlierContributionCheck
+		for ; start < len(checks) && len(batch) < graphQLAuthorsPerQuery; start++ {
+			// Skip authors that are already known to have contributed earlier
+			author := checks[start].author
+			if !earlier[author] && (nodeIDs[author] != "" || len(emails[author]) > 0) {
+				batch = append(batch, checks[start])
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		var params []string
+		var fields []string
+		variables := map[string]interface{}{
+			"owner": org,
+			"until": before.Add(-time.Second),
+		}
+		for i, check := range batch {
+			author := fmt.Sprintf("{id: $a%d}", i)
+			if nodeID := nodeIDs[check.author]; nodeID != "" {
+				params = append(params, fmt.Sprintf("$n%[1]d: String!, $a%[1]d: ID!", i))
+				variables[fmt.Sprintf("a%d", i)] = nodeID
+			} else {
+				author = fmt.Sprintf("{emails: $e%d}", i)
+				params = append(params, fmt.Sprintf("$n%[1]d: String!, $e%[1]d: [String!]", i))
+				variables[fmt.Sprintf("e%d", i)] = emails[check.author]
+			}
+			fields = append(fields, fmt.Sprintf(`c%[1]d: repository(owner: $owner, name: $n%[1]d) {
+	defaultBranchRef { target { ... on Commit {
+		history(first: 1, until: $until, author: %[2]s) { nodes { oid } }
+	} } }
+}`, i, author))
+			variables[fmt.Sprintf("n%d", i)] = check.repo
+		}
+
+		query := fmt.Sprintf("query($owner: String!, $until: GitTimestamp!, %s) {\n%s\n}", strings.Join(params, ", "), strings.Join(fields, "\n"))
+		response, err := p.queryGraphQL(ctx, client, query, variables)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, check := range batch {
+			var repository *graphQLRepository
+			if data, ok := response.Data[fmt.Sprintf("c%d", i)]; ok {
+				if err := json.Unmarshal(data, &repository); err != nil {
+					return nil, err
+				}
+			}
+			if repository == nil || repository.DefaultBranchRef == nil || repository.DefaultBranchRef.Target.History == nil {
+				continue
+			}
+			if len(repository.DefaultBranchRef.Target.History.Nodes) > 0 {
+				earlier[check.author] = true
+			}
+		}
+	}
+
+	return earlier, nil
+}
+
+// resolveNodeIDs looks up the GraphQL node IDs of users that aren't in nodeIDs yet.
+func (p *Plugin) resolveNodeIDs(ctx context.Context, client *github.Client, logins []string, nodeIDs map[string]string) error {
+	var missing []string
+	for _, login := range logins {
+		if nodeIDs[login] == "" {
+			missing = append(missing, login)
+		}
+	}
+
+	for start := 0; start < len(missing); start += graphQLAuthorsPerQuery {
+		end := start + graphQLAuthorsPerQuery
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batch := missing[start:end]
+
+		var params []string
+		var fields []string
+		variables := map[string]interface{}{}
+		for i, login := range batch {
+			params = append(params, fmt.Sprintf("$l%d: String!", i))
+			fields = append(fields, fmt.Sprintf("u%[1]d: user(login: $l%[1]d) { id }", i))
+			variables[fmt.Sprintf("l%d", i)] = login
+		}
+
+		query := fmt.Sprintf("query(%s) {\n%s\n}", strings.Join(params, ", "), strings.Join(fields, "\n"))
+		response, err := p.queryGraphQL(ctx, client, query, variables)
+		if err != nil {
+			return err
+		}
+
+		for i, login := range batch {
+			var user *struct {
+				ID string `json:"id"`
+			}
+			if data, ok := response.Data[fmt.Sprintf("u%d", i)]; ok {
+				if err := json.Unmarshal(data, &user); err != nil {
+					return err
+				}
+			}
+			if user != nil {
+				nodeIDs[login] = user.ID
+			}
+		}
+	}
+	return nil
+}
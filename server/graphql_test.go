@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// graphQLTestPageSize is the number of commits per page of the fake GraphQL API, so that pagination is used with few commits.
+const graphQLTestPageSize = 2
+
+// fakeGraphQL answers the queries of graphql.go from canned repositories and users.
+type fakeGraphQL struct {
+	t *testing.T
+
+	// commits are the commits of the default branch of every repository. Repositories that aren't in commits don't exist.
+	commits map[string][]graphQLCommit
+	// nodeIDs are the node IDs of users that can be looked up.
+	nodeIDs map[string]string
+	// earlier contains "repo/nodeID" or "repo/email" for authors that committed to repo before the checked time.
+	earlier map[string]bool
+
+	lock    sync.Mutex
+	queries []graphQLRequest
+}
+
+func (f *fakeGraphQL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request graphQLRequest
+	require.NoError(f.t, json.NewDecoder(r.Body).Decode(&request))
+
+	f.lock.Lock()
+	f.queries = append(f.queries, request)
+	f.lock.Unlock()
+
+	data := map[string]interface{}{}
+	var errs []graphQLError
+	variable := func(name string, i int) string {
+		value, _ := request.Variables[fmt.Sprintf("%v%d", name, i)].(string)
+		return value
+	}
+	parseTime := func(value string) time.Time {
+		date, err := time.Parse(time.RFC3339, value)
+		require.NoError(f.t, err)
+		return date
+	}
+
+	for i := 0; ; i++ {
+		switch {
+		case variable("s", i) != "":
+			// Commit history of a repository
+			repo := variable("n", i)
+			commits, ok := f.commits[repo]
+			if !ok {
+				data[fmt.Sprintf("r%d", i)] = nil
+				errs = append(errs, graphQLError{
+					Type:    "NOT_FOUND",
+					Path:    []interface{}{fmt.Sprintf("r%d", i)},
+					Message: fmt.Sprintf("Could not resolve to a Repository with the name '%v/%v'.", variable("o", i), repo),
+				})
+				continue
+			}
+			if len(commits) == 0 {
+				data[fmt.Sprintf("r%d", i)] = map[string]interface{}{"defaultBranchRef": nil}
+				continue
+			}
+
+			since, until := parseTime(variable("s", i)), parseTime(variable("u", i))
+			var matching []graphQLCommit
+			for _, c := range commits {
+				if !c.CommittedDate.Before(since) && !c.CommittedDate.After(until) {
+					matching = append(matching, c)
+				}
+			}
+			start, _ := strconv.Atoi(variable("c", i))
+			end := start + graphQLTestPageSize
+			if end > len(matching) {
+				end = len(matching)
+			}
+			history := graphQLHistory{Nodes: matching[start:end]}
+			history.PageInfo.HasNextPage = end < len(matching)
+			history.PageInfo.EndCursor = strconv.Itoa(end)
+
+			data[fmt.Sprintf("r%d", i)] = map[string]interface{}{
+				"defaultBranchRef": map[string]interface{}{"target": map[string]interface{}{"history": history}},
+			}
+		case variable("a", i) != "":
+			// Earlier contribution check
+			nodes := []interface{}{}
+			if f.earlier[variable("n", i)+"/"+variable("a", i)] {
+				nodes = append(nodes, map[string]string{"oid": "earlier"})
+			}
+			data[fmt.Sprintf("c%d", i)] = map[string]interface{}{
+				"defaultBranchRef": map[string]interface{}{"target": map[string]interface{}{"history": map[string]interface{}{"nodes": nodes}}},
+			}
+		case request.Variables[fmt.Sprintf("e%d", i)] != nil:
+			// Earlier contribution check by email addresses
+			nodes := []interface{}{}
+			for _, email := range request.Variables[fmt.Sprintf("e%d", i)].([]interface{}) {
+				if f.earlier[variable("n", i)+"/"+email.(string)] {
+					nodes = append(nodes, map[string]string{"oid": "earlier"})
+				}
+			}
+			data[fmt.Sprintf("c%d", i)] = map[string]interface{}{
+				"defaultBranchRef": map[string]interface{}{"target": map[string]interface{}{"history": map[string]interface{}{"nodes": nodes}}},
+			}
+		case variable("l", i) != "":
+			// User lookup
+			nodeID, ok := f.nodeIDs[variable("l", i)]
+			if !ok {
+				data[fmt.Sprintf("u%d", i)] = nil
+				errs = append(errs, graphQLError{Type: "NOT_FOUND", Path: []interface{}{fmt.Sprintf("u%d", i)}, Message: "Could not resolve to a User"})
+				continue
+			}
+			data[fmt.Sprintf("u%d", i)] = map[string]string{"id": nodeID}
+		default:
+			writeJSON(f.t, w, map[string]interface{}{"data": data, "errors": errs})
+			return
+		}
+	}
+}
+
+func (f *fakeGraphQL) getQueries() []graphQLRequest {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]graphQLRequest(nil), f.queries...)
+}
+
+func newTestGraphQLClient(t *testing.T, f *fakeGraphQL) (*Plugin, *github.Client) {
+	f.t = t
+	mux := http.NewServeMux()
+	mux.Handle("/api/graphql", f)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	api := &plugintest.API{}
+	newTestKVStore(api)
+	allowLogs(api)
+
+	p := &Plugin{scheduler: newScheduler(2, func(string, ...interface{}) {})}
+	p.SetAPI(api)
+
+	client := github.NewClient(server.Client())
+	baseURL, err := url.Parse(server.URL + "/api/v3/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+	return p, client
+}
+
+// newGraphQLTestCommit returns a commit of the user with login and nodeID, or of an unknown user if login is empty.
+func newGraphQLTestCommit(t *testing.T, oid, login, nodeID string, date time.Time) graphQLCommit {
+	c := graphQLCommit{OID: oid, URL: "https://github.com/org/repo/commit/" + oid, CommittedDate: date}
+	c.Author.Email = login + "@example.com"
+	c.Author.Date = date
+	if login != "" {
+		user := fmt.Sprintf(`{"__typename": "User", "id": %q, "databaseId": 1, "login": %q}`, nodeID, login)
+		require.NoError(t, json.Unmarshal([]byte(user), &c.Author.User))
+	}
+	return c
+}
+
+func TestFetchCommitHistoriesGraphQL(t *testing.T) {
+	until := time.Now().Add(-time.Hour).Truncate(time.Second)
+	since := until.AddDate(0, 0, -30)
+	day := func(d int) time.Time {
+		return since.AddDate(0, 0, d)
+	}
+
+	bot := newGraphQLTestCommit(t, "e", "", "", day(5))
+	bot.Author.Email = "49699333+dependabot[bot]@users.noreply.github.com"
+	f := &fakeGraphQL{commits: map[string][]graphQLCommit{
+		"server": {
+			newGraphQLTestCommit(t, "a", "jane", "J", day(1)),
+			newGraphQLTestCommit(t, "b", "john", "H", day(2)),
+			newGraphQLTestCommit(t, "c", "", "", day(3)),
+			newGraphQLTestCommit(t, "old", "jane", "J", day(-1)),
+		},
+		"webapp": {newGraphQLTestCommit(t, "d", "jane", "J", day(4)), bot},
+		"empty":  {},
+	}}
+	p, client := newTestGraphQLClient(t, f)
+
+	histories, failed, err := p.fetchCommitHistoriesGraphQL(context.Background(), client, "org", []string{"server", "webapp", "empty", "missing"}, since, until)
+	require.NoError(t, err)
+
+	require.Len(t, histories["server"], 3)
+	assert.Equal(t, "c", histories["server"][0].GetSHA())
+	assert.Nil(t, histories["server"][0].GetAuthor())
+	assert.Equal(t, "a", histories["server"][2].GetSHA())
+	assert.Equal(t, "jane", histories["server"][2].GetAuthor().GetLogin())
+	assert.Equal(t, "J", histories["server"][2].GetAuthor().GetNodeID())
+	assert.Equal(t, "User", histories["server"][2].GetAuthor().GetType())
+	require.Len(t, histories["webapp"], 2)
+	// Commits of GitHub Apps are linked to the bot like with the REST API
+	assert.Equal(t, "dependabot[bot]", histories["webapp"][0].GetAuthor().GetLogin())
+	assert.Equal(t, gitHubBotType, histories["webapp"][0].GetAuthor().GetType())
+	assert.Contains(t, histories, "empty")
+	assert.Empty(t, histories["empty"])
+	assert.NotContains(t, histories, "missing")
+	require.Contains(t, failed, "missing")
+	assert.Contains(t, failed["missing"].Error(), "Could not resolve to a Repository with the name 'org/missing'.")
+
+	// Every repository is fetched with the first query, the second page of server with another one
+	queries := f.getQueries()
+	require.Len(t, queries, 2)
+	assert.Equal(t, "server", queries[0].Variables["n0"])
+	assert.Equal(t, "missing", queries[0].Variables["n3"])
+	assert.NotContains(t, queries[0].Variables, "c0")
+	assert.Equal(t, map[string]interface{}{
+		"o0": "org",
+		"n0": "server",
+		"s0": since.Format(time.RFC3339),
+		"u0": until.Format(time.RFC3339),
+		"c0": "2",
+	}, queries[1].Variables)
+
+	// Fetched repositories are cached. Failed ones are fetched again.
+	histories, failed, err = p.fetchCommitHistoriesGraphQL(context.Background(), client, "org", []string{"server", "webapp", "missing"}, since, until)
+	require.NoError(t, err)
+	assert.Len(t, histories["server"], 3)
+	assert.Len(t, histories["webapp"], 2)
+	assert.Contains(t, failed, "missing")
+
+	queries = f.getQueries()
+	require.Len(t, queries, 3)
+	assert.Equal(t, "missing", queries[2].Variables["n0"])
+	assert.NotContains(t, queries[2].Variables, "n1")
+}
+
+func TestFindFirstContributionsGraphQL(t *testing.T) {
+	since := time.Now().AddDate(0, 0, -30).Truncate(time.Second)
+	day := func(d int) time.Time {
+		return since.AddDate(0, 0, d)
+	}
+
+	f := &fakeGraphQL{
+		commits: map[string][]graphQLCommit{
+			"server": {
+				newGraphQLTestCommit(t, "a", "jane", "J", day(1)),
+				// GitHub Enterprise Server versions that don't return node IDs of commit authors
+				newGraphQLTestCommit(t, "b", "john", "", day(3)),
+				newGraphQLTestCommit(t, "c", "john", "", day(2)),
+				newGraphQLTestCommit(t, "d", "", "", day(2)),
+				// Users that can't be looked up by their login, e.g. because they were renamed
+				newGraphQLTestCommit(t, "f", "ghost", "", day(5)),
+				newGraphQLTestCommit(t, "g", "newbie", "", day(6)),
+			},
+			"webapp": {newGraphQLTestCommit(t, "e", "bob", "B", day(4))},
+		},
+		nodeIDs: map[string]string{"john": "H"},
+		earlier: map[string]bool{"server/J": true, "server/ghost@example.com": true},
+	}
+	p, client := newTestGraphQLClient(t, f)
+
+	contributors := map[string][]*github.Contributor{
+		"server": {{Login: github.String("jane")}, {Login: github.String("john")}, {Login: github.String("alice")}, {Login: github.String("ghost")}, {Login: github.String("newbie")}},
+		"webapp": {{Login: github.String("bob")}},
+	}
+	first, err := p.findFirstContributionsGraphQL(context.Background(), client, contributors, "org", since)
+	require.NoError(t, err)
+
+	require.Len(t, first, 3)
+	assert.Equal(t, "server", first["john"].repo)
+	assert.True(t, day(2).Equal(first["john"].date))
+	assert.Equal(t, "https://github.com/org/repo/commit/c", first["john"].commit)
+	assert.Equal(t, "webapp", first["bob"].repo)
+	// Users without a node ID are checked by their email address
+	assert.Equal(t, "server", first["newbie"].repo)
+	assert.NotContains(t, first, "ghost")
+
+	// Only the node IDs of users without one are looked up, and earlier contributions are checked up to just before since
+	queries := f.getQueries()
+	var lookups, checks []graphQLRequest
+	for _, q := range queries {
+		if _, ok := q.Variables["l0"]; ok {
+			lookups = append(lookups, q)
+		}
+		if _, ok := q.Variables["until"]; ok {
+			checks = append(checks, q)
+		}
+	}
+	require.Len(t, lookups, 1)
+	var looked []interface{}
+	for _, login := range lookups[0].Variables {
+		looked = append(looked, login)
+	}
+	assert.ElementsMatch(t, []interface{}{"john", "ghost", "newbie"}, looked)
+	require.Len(t, checks, 1)
+	assert.Equal(t, since.Add(-time.Second).Format(time.RFC3339), checks[0].Variables["until"])
+	assert.Len(t, checks[0].Variables, 2+2*5)
+}
+
+func TestResolveNodeIDs(t *testing.T) {
+	f := &fakeGraphQL{nodeIDs: map[string]string{}}
+	var logins []string
+	for i := 0; i < graphQLAuthorsPerQuery+10; i++ {
+		login := fmt.Sprintf("user%d", i)
+		logins = append(logins, login)
+		f.nodeIDs[login] = fmt.Sprintf("U%d", i)
+	}
+	logins = append(logins, "ghost")
+	p, client := newTestGraphQLClient(t, f)
+
+	nodeIDs := map[string]string{"user0": "known"}
+	require.NoError(t, p.resolveNodeIDs(context.Background(), client, logins, nodeIDs))
+
+	assert.Len(t, nodeIDs, graphQLAuthorsPerQuery+10)
+	assert.Equal(t, "known", nodeIDs["user0"])
+	assert.Equal(t, "U59", nodeIDs["user59"])
+	assert.NotContains(t, nodeIDs, "ghost")
+
+	// Users are looked up in batches
+	queries := f.getQueries()
+	require.Len(t, queries, 2)
+	assert.Len(t, queries[0].Variables, graphQLAuthorsPerQuery)
+	assert.Len(t, queries[1].Variables, 10)
+}
+
+func TestFetchCommitStatsFallback(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/graphql", func(w http.ResponseWriter, r *http.Request) {
+		// GitHub Enterprise Server versions without changedFilesIfAvailable
+		writeJSON(t, w, map[string]interface{}{"errors": []map[string]interface{}{{
+			"message":    "Field 'changedFilesIfAvailable' doesn't exist on type 'Commit'",
+			"extensions": map[string]string{"code": graphQLUndefinedField},
+		}}})
+	})
+	mux.HandleFunc("/api/v3/repos/org/repo/commits/abc", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]interface{}{
+			"sha":   "abc",
+			"stats": map[string]int{"additions": 3, "deletions": 1},
+			"files": []map[string]string{{"filename": "a.go"}, {"filename": "b.go"}},
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	api := &plugintest.API{}
+	allowLogs(api)
+	api.On("GetPluginStatus", gitHubPluginID).Return(&model.PluginStatus{State: model.PluginStateNotRunning}, (*model.AppError)(nil))
+	p := &Plugin{scheduler: newScheduler(2, func(string, ...interface{}) {})}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{Token: "token"})
+
+	client := github.NewClient(server.Client())
+	baseURL, err := url.Parse(server.URL + "/api/v3/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	stats, err := (&gitHubProvider{p: p, client: client}).fetchCommitStats(context.Background(), "org", "repo", []string{"abc"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]commitStats{"abc": {Additions: 3, Deletions: 1, Files: 2}}, stats)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"sort"
@@ -115,95 +116,76 @@ func (p *Plugin) listHackfestContributors(args *model.CommandArgs) *model.AppErr
 	}
 	repo := config.HackfestRepo
 
+	forge, org, appErr := p.getProvider(args.UserId, org)
+	if appErr != nil {
+		return appErr
+	}
+
 	topic := org
 	if repo != "" {
 		topic += "/" + repo
 	}
 
-	client, err := p.getGitHubClient(args.UserId)
-	if err != nil {
-		p.API.LogWarn("Failed to create GitHub client", "error", err.Error())
-
-		return &model.AppError{
-			Id:         "Failed to connect to GitHub.",
-			StatusCode: http.StatusBadRequest,
-			Where:      "p.ExecuteCommand",
-		}
-	}
-
 	attachments := []*model.SlackAttachment{{
 		Title:      "Fetching Hackfest contributors",
 		Text:       waitText,
 		AuthorName: topic,
-		AuthorLink: fmt.Sprintf("https://github.com/%v", topic),
+		AuthorLink: forge.webURL(topic),
 	}}
 
-	loadingPost := &model.Post{
-		ChannelId: args.ChannelId,
-		UserId:    p.botUserID,
-	}
-	model.ParseSlackAttachment(loadingPost, attachments)
-
-	loadingPost, appErr := p.API.CreatePost(loadingPost)
-	if appErr != nil {
-		return appErr
-	}
-
-	go p.updateHackfestContributorsPost(client, loadingPost, args.UserId, org, repo, start, end)
-	return nil
+	return p.startJob(args, &job{
+		Type:   jobTypeHackfest,
+		Target: config.HackfestOrg,
+		Repo:   repo,
+		IsOrg:  true,
+		Since:  start,
+		Until:  end,
+	}, attachments)
 }
 
-func (p *Plugin) updateHackfestContributorsPost(client *github.Client, post *model.Post, userID, org, repo string, since, until time.Time) {
+func (p *Plugin) updateHackfestContributorsPost(ctx context.Context, forge provider, post *model.Post, userID, org, repo string, since, until time.Time) error {
 	config := p.getConfiguration()
 
 	// Fetch commits until one day after at midnight
 	fetchUntil := until.AddDate(0, 0, 1).Add(-time.Microsecond)
 
-	var commits []*github.RepositoryCommit
-	var err error
-	if repo != "" {
-		commits, err = p.fetchCommitsFromRepo(client, org, repo, since, fetchUntil)
-	} else {
-		commits, err = p.fetchCommitsFromOrg(client, org, since, fetchUntil)
-	}
+	commits, err := forge.fetchCommits(ctx, org, repo, true, since, fetchUntil)
 
 	excludedUsers := strings.Split(config.HackfestExcludeUsers, ", ")
 
 	excludedTeams := strings.Split(config.HackfestExcludeTeams, ", ")
 	if len(excludedTeams) > 0 {
-		var teams []*github.Team
-		teams, err = p.fetchTeams(client, org)
-		if err != nil {
-			p.API.LogWarn("failed to fetch teams", "error", err.Error())
-			return
-		}
-
-		for _, excludedTeam := range excludedTeams {
-			for _, team := range teams {
-				if team.GetSlug() == excludedTeam {
-					var member []*github.User
-					member, err = p.fetchTeamMemberFromTeam(client, team.GetOrganization().GetID(), team.GetID())
-					if err != nil {
-						p.API.LogWarn("failed to fetch team member", "error", err.Error())
-						return
-					}
-
-					for _, m := range member {
-						excludedUsers = append(excludedUsers, m.GetLogin())
-					}
-				}
+		member, memberErr := forge.fetchTeamMembers(ctx, org, excludedTeams)
+		if memberErr != nil {
+			p.API.LogWarn("failed to fetch team member", "error", memberErr.Error())
+			if err == nil {
+				err = memberErr
 			}
 		}
+		excludedUsers = append(excludedUsers, member...)
 	}
 
+	var staff map[string]bool
+	var coAuthored []*github.RepositoryCommit
+	if err == nil {
+		staff = p.fetchStaffForReport(ctx, forge, org, true)
+		coAuthored, err = p.fetchCoAuthoredCommits(ctx, forge, commits)
+	}
+	err = finishFetching(ctx, err)
+
 	if err != nil {
 		p.API.LogWarn("failed to fetch data", "err", err.Error())
 
 		message := githubErrorHandle(err)
 		post.Props["attachments"].([]*model.SlackAttachment)[0].Text = message
 	} else {
+		bots := p.getBotFilter()
+		commits, filtered := bots.filterCommits(p.resolveIdentities(commits))
+		coAuthored, _ = bots.filterCommits(p.resolveIdentities(coAuthored))
+		credited, separate := p.creditCoAuthors(commits, coAuthored)
+
 		contributors := map[string]int{}
-		for _, c := range commits {
+		for _, c := range credited {
 			author := c.GetAuthor()
 			if author == nil {
 				continue
@@ -237,7 +219,8 @@ func (p *Plugin) updateHackfestContributorsPost(client *github.Client, post *mod
 			return ss[i].Value > ss[j].Value
 		})
 
-		var contributorsText string
+		var contributorsText, staffText, communityText string
+		var contributions, communityContributions int
 		for _, e := range ss {
 			var c string
 			if e.Value > 1 {
@@ -245,7 +228,16 @@ func (p *Plugin) updateHackfestContributorsPost(client *github.Client, post *mod
 			} else {
 				c = "contribution"
 			}
-			contributorsText += fmt.Sprintf("- [%[1]s](https://github.com/%[1]v): %v %v\n", e.Key, e.Value, c)
+			line := fmt.Sprintf("- [%s](%s): %v %v\n", e.Key, forge.webURL(e.Key), e.Value, c)
+			contributorsText += line
+
+			contributions += e.Value
+			if staff[strings.ToLower(e.Key)] {
+				staffText += line
+			} else {
+				communityText += line
+				communityContributions += e.Value
+			}
 		}
 
 		attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
@@ -254,15 +246,41 @@ func (p *Plugin) updateHackfestContributorsPost(client *github.Client, post *mod
 		attachment.Fields = []*model.SlackAttachmentField{{
 			Title: "Number of Contributors",
 			Value: strconv.Itoa(len(contributors)),
-		}, {
-			Title: "Contributors",
-			Value: contributorsText,
 		}}
+
+		if staff == nil {
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: "Contributors",
+				Value: contributorsText,
+			})
+		} else {
+			if staffText == "" {
+				staffText = "None"
+			}
+			if communityText == "" {
+				communityText = "None"
+			}
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: "External contributions",
+				Value: formatRatio(communityContributions, contributions, "contributions"),
+			}, &model.SlackAttachmentField{
+				Title: "Community",
+				Value: communityText,
+			}, &model.SlackAttachmentField{
+				Title: "Staff",
+				Value: staffText,
+			})
+		}
+		attachment.Fields = append(attachment.Fields, coAuthorsField(forge, separate, nil)...)
+		attachment.Fields = append(attachment.Fields, unlinkedField(credited)...)
+		attachment.Fields = append(attachment.Fields, filtered.field("commits")...)
 	}
 
 	if _, appErr := p.API.UpdatePost(post); appErr != nil {
 		p.SendEphemeralPost(post.ChannelId, userID, "Something went bad. Please try again.")
 		p.API.LogWarn("failed to update post", "err", appErr.Error())
-		return
+		return appErr
 	}
+
+	return err
 }
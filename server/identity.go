@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	identityMapKey = "identities"
+
+	// identityMapRetries is the number of times an update of the identity map is retried if it was changed concurrently.
+	identityMapRetries = 5
+
+	// maxListedUnlinkedAuthors is the number of authors listed in the unlinked commits of a report.
+	maxListedUnlinkedAuthors = 20
+)
+
+// identityMap links commit authors to user accounts, like a .mailmap file. Keys are lower case.
+type identityMap struct {
+	// Emails and Names map the email addresses and names of commit authors to logins.
+	Emails map[string]string
+	Names  map[string]string
+	// Aliases map logins to the login of the same person that's shown in reports instead.
+	Aliases map[string]string
+}
+
+func normalizeIdentity(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// login returns the login that's shown in reports for a login, following its alias.
+func (m *identityMap) login(login string) string {
+	if alias, ok := m.Aliases[normalizeIdentity(login)]; ok {
+		return alias
+	}
+	return login
+}
+
+// lookup returns the login of a commit author by their email address, or else their name. It's empty if none is known.
+func (m *identityMap) lookup(name, email string) string {
+	if login, ok := m.Emails[normalizeIdentity(email)]; ok {
+		return m.login(login)
+	}
+	if login, ok := m.Names[normalizeIdentity(name)]; ok {
+		return m.login(login)
+	}
+	return ""
+}
+
+// resolve links the commits without a user account by the email address or name of their author,
+// and replaces aliases by the login they belong to. Commits are copied before they are changed.
+func (m *identityMap) resolve(commits []*github.RepositoryCommit) []*github.RepositoryCommit {
+	result := make([]*github.RepositoryCommit, 0, len(commits))
+	for _, c := range commits {
+		var login string
+		if author := c.GetAuthor(); author != nil {
+			if alias := m.login(author.GetLogin()); alias != author.GetLogin() {
+				login = alias
+			}
+		} else {
+			login = m.lookup(c.GetCommit().GetAuthor().GetName(), c.GetCommit().GetAuthor().GetEmail())
+		}
+
+		if login != "" {
+			resolved := *c
+			resolved.Author = &github.User{Login: github.String(login)}
+			c = &resolved
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// getIdentityMap loads the identity map and the data it was decoded from. The data is nil if none is stored yet.
+func (p *Plugin) getIdentityMap() (*identityMap, []byte, error) {
+	m := &identityMap{}
+
+	data, appErr := p.API.KVGet(identityMapKey)
+	if appErr != nil {
+		return nil, nil, appErr
+	}
+	if data != nil {
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to decode identity map")
+		}
+	}
+
+	if m.Emails == nil {
+		m.Emails = map[string]string{}
+	}
+	if m.Names == nil {
+		m.Names = map[string]string{}
+	}
+	if m.Aliases == nil {
+		m.Aliases = map[string]string{}
+	}
+	return m, data, nil
+}
+
+// updateIdentityMap changes the identity map with update. The update is retried if the map was changed concurrently.
+func (p *Plugin) updateIdentityMap(update func(m *identityMap) error) error {
+	for i := 0; i < identityMapRetries; i++ {
+		m, oldData, err := p.getIdentityMap()
+		if err != nil {
+			return err
+		}
+		if err = update(m); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		saved, appErr := p.API.KVSetWithOptions(identityMapKey, data, model.PluginKVSetOptions{
+			Atomic:   true,
+			OldValue: oldData,
+		})
+		if appErr != nil {
+			return appErr
+		}
+		if saved {
+			return nil
+		}
+	}
+	return errors.New("identity map was changed concurrently")
+}
+
+// resolveIdentities links commits with the identity map. If it can't be loaded, the commits are returned unchanged.
+func (p *Plugin) resolveIdentities(commits []*github.RepositoryCommit) []*github.RepositoryCommit {
+	m, _, err := p.getIdentityMap()
+	if err != nil {
+		p.API.LogWarn("Failed to load identity map", "error", err.Error())
+		return commits
+	}
+	return m.resolve(commits)
+}
+
+type unlinkedAuthor struct {
+	name    string
+	email   string
+	commits int
+}
+
+// countUnlinked returns the number of commits per author of the commits that aren't linked to a user account, most commits first.
+func countUnlinked(commits []*github.RepositoryCommit) []*unlinkedAuthor {
+	authors := map[string]*unlinkedAuthor{}
+	for _, c := range commits {
+		if c.GetAuthor() != nil {
+			continue
+		}
+
+		author := c.GetCommit().GetAuthor()
+		key := normalizeIdentity(author.GetEmail())
+		if key == "" {
+			key = normalizeIdentity(author.GetName())
+		}
+		a, ok := authors[key]
+		if !ok {
+			a = &unlinkedAuthor{name: author.GetName(), email: author.GetEmail()}
+			authors[key] = a
+		}
+		a.commits++
+	}
+
+	var result []*unlinkedAuthor
+	for _, a := range authors {
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].commits != result[j].commits {
+			return result[i].commits > result[j].commits
+		}
+		return result[i].email < result[j].email
+	})
+	return result
+}
+
+// unlinkedField returns the attachment fields that list the authors of commits that aren't linked to a user account.
+// No field is returned if every commit is linked.
+func unlinkedField(commits []*github.RepositoryCommit) []*model.SlackAttachmentField {
+	authors := countUnlinked(commits)
+	if len(authors) == 0 {
+		return nil
+	}
+
+	var count int
+	for _, a := range authors {
+		count += a.commits
+	}
+
+	text := fmt.Sprintf("%v commits by %v authors aren't linked to an account. Link them with `/%v mailmap set`.\n", count, len(authors), trigger)
+	for i, a := range authors {
+		if i == maxListedUnlinkedAuthors {
+			text += fmt.Sprintf("- and %v more\n", len(authors)-i)
+			break
+		}
+		text += fmt.Sprintf("- %s <%s>: %v\n", a.name, a.email, a.commits)
+	}
+
+	return []*model.SlackAttachmentField{{
+		Title: "Unlinked commits",
+		Value: text,
+	}}
+}
+
+// executeMailmapCommand handles /community mailmap list|set|alias|remove.
+func (p *Plugin) executeMailmapCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	usage := &model.AppError{
+		Id:         "Usage: /community mailmap list, /community mailmap set [email or name] [login], /community mailmap alias [login] [login shown instead] or /community mailmap remove [email, name or login]",
+		StatusCode: http.StatusBadRequest,
+		Where:      "p.ExecuteCommand",
+	}
+	if len(commandArgs) == 0 {
+		return usage
+	}
+
+	if commandArgs[0] == "list" {
+		return p.listIdentities(args)
+	}
+
+	if !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return &model.AppError{
+			Id:         "Only system administrators can change the mailmap.",
+			StatusCode: http.StatusForbidden,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	var message string
+	var update func(m *identityMap) error
+	switch commandArgs[0] {
+	case "set":
+		// Names can contain spaces, the login is the last argument
+		if len(commandArgs) < 3 {
+			return usage
+		}
+		identity := strings.Join(commandArgs[1:len(commandArgs)-1], " ")
+		login := commandArgs[len(commandArgs)-1]
+		update = func(m *identityMap) error {
+			if strings.Contains(identity, "@") {
+				m.Emails[normalizeIdentity(identity)] = login
+			} else {
+				m.Names[normalizeIdentity(identity)] = login
+			}
+			return nil
+		}
+		message = fmt.Sprintf("Commits by %v are credited to %v.", identity, login)
+	case "alias":
+		if len(commandArgs) != 3 {
+			return usage
+		}
+		alias, login := commandArgs[1], commandArgs[2]
+		if normalizeIdentity(alias) == normalizeIdentity(login) {
+			return usage
+		}
+		update = func(m *identityMap) error {
+			// Keep aliases pointing to the shown login, so that they never have to be followed more than once
+			login = m.login(login)
+			if normalizeIdentity(login) == normalizeIdentity(alias) {
+				return fmt.Errorf("%v is already shown as %v", alias, commandArgs[2])
+			}
+			for a, l := range m.Aliases {
+				if normalizeIdentity(l) == normalizeIdentity(alias) {
+					m.Aliases[a] = login
+				}
+			}
+			m.Aliases[normalizeIdentity(alias)] = login
+			return nil
+		}
+		message = fmt.Sprintf("Contributions of %v are shown as %v.", alias, login)
+	case "remove":
+		if len(commandArgs) < 2 {
+			return usage
+		}
+		identity := normalizeIdentity(strings.Join(commandArgs[1:], " "))
+		update = func(m *identityMap) error {
+			_, isEmail := m.Emails[identity]
+			_, isName := m.Names[identity]
+			_, isAlias := m.Aliases[identity]
+			if !isEmail && !isName && !isAlias {
+				return fmt.Errorf("%v isn't in the mailmap", identity)
+			}
+			delete(m.Emails, identity)
+			delete(m.Names, identity)
+			delete(m.Aliases, identity)
+			return nil
+		}
+		message = fmt.Sprintf("Removed %v from the mailmap.", identity)
+	default:
+		return usage
+	}
+
+	if err := p.updateIdentityMap(update); err != nil {
+		p.API.LogWarn("Failed to update identity map", "error", err.Error())
+		return &model.AppError{
+			Id:         fmt.Sprintf("Failed to update the mailmap: %v", err.Error()),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, message)
+	return nil
+}
+
+func (p *Plugin) listIdentities(args *model.CommandArgs) *model.AppError {
+	m, _, err := p.getIdentityMap()
+	if err != nil {
+		p.API.LogError("Failed to load identity map", "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to load the mailmap",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	if len(m.Emails)+len(m.Names)+len(m.Aliases) == 0 {
+		p.SendEphemeralPost(args.ChannelId, args.UserId, "The mailmap is empty.")
+		return nil
+	}
+
+	text := "| Email, name or login | Credited to |\n"
+	text += "|:---------------------|:------------|\n"
+	for _, identities := range []map[string]string{m.Emails, m.Names, m.Aliases} {
+		var keys []string
+		for key := range identities {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			text += fmt.Sprintf("| %v | %v |\n", key, identities[key])
+		}
+	}
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, text)
+	return nil
+}
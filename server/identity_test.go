@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityMapResolve(t *testing.T) {
+	m := &identityMap{
+		Emails:  map[string]string{"jane@example.com": "jane"},
+		Names:   map[string]string{"john doe": "john-old"},
+		Aliases: map[string]string{"john-old": "john", "jane-work": "jane"},
+	}
+
+	commit := func(login, name, email string) *github.RepositoryCommit {
+		c := &github.RepositoryCommit{
+			Commit: &github.Commit{Author: &github.CommitAuthor{Name: github.String(name), Email: github.String(email)}},
+		}
+		if login != "" {
+			c.Author = &github.User{Login: github.String(login)}
+		}
+		return c
+	}
+	jane := commit("", "Jane", "Jane@Example.com")
+
+	commits := m.resolve([]*github.RepositoryCommit{
+		jane,
+		commit("", "John Doe", "john@example.com"),
+		commit("Jane-Work", "Jane", "jane@work.example.com"),
+		commit("alice", "Alice", "alice@example.com"),
+		commit("", "Unknown", "unknown@example.com"),
+		commit("", "Unknown", "unknown@example.com"),
+		commit("", "Someone", "someone@example.com"),
+	})
+
+	require.Len(t, commits, 7)
+	assert.Equal(t, "jane", commits[0].GetAuthor().GetLogin())
+	assert.Equal(t, "john", commits[1].GetAuthor().GetLogin())
+	assert.Equal(t, "jane", commits[2].GetAuthor().GetLogin())
+	assert.Equal(t, "alice", commits[3].GetAuthor().GetLogin())
+	assert.Nil(t, commits[4].GetAuthor())
+	// The original commits aren't changed
+	assert.Nil(t, jane.GetAuthor())
+
+	unlinked := countUnlinked(commits)
+	require.Len(t, unlinked, 2)
+	assert.Equal(t, &unlinkedAuthor{name: "Unknown", email: "unknown@example.com", commits: 2}, unlinked[0])
+	assert.Equal(t, &unlinkedAuthor{name: "Someone", email: "someone@example.com", commits: 1}, unlinked[1])
+
+	assert.Len(t, unlinkedField(commits), 1)
+	assert.Empty(t, unlinkedField(commits[:4]))
+}
+
+func TestExecuteMailmapCommand(t *testing.T) {
+	args := &model.CommandArgs{UserId: "user", ChannelId: "channel"}
+
+	t.Run("requires a system administrator", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		api.On("HasPermissionTo", "user", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		appErr := p.executeMailmapCommand([]string{"set", "jane@example.com", "jane"}, args)
+		require.NotNil(t, appErr)
+		assert.Equal(t, 403, appErr.StatusCode)
+	})
+
+	t.Run("retries concurrent updates", func(t *testing.T) {
+		stored, err := json.Marshal(&identityMap{Aliases: map[string]string{"jane-work": "jane"}})
+		require.NoError(t, err)
+
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		api.On("HasPermissionTo", "user", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("KVGet", identityMapKey).Return(nil, (*model.AppError)(nil)).Once()
+		api.On("KVGet", identityMapKey).Return(stored, (*model.AppError)(nil)).Once()
+		api.On("KVSetWithOptions", identityMapKey, mock.Anything, model.PluginKVSetOptions{Atomic: true}).Return(false, (*model.AppError)(nil)).Once()
+		api.On("KVSetWithOptions", identityMapKey, mock.MatchedBy(func(data []byte) bool {
+			var m identityMap
+			return json.Unmarshal(data, &m) == nil && m.Names["john doe"] == "john" && m.Aliases["jane-work"] == "jane"
+		}), model.PluginKVSetOptions{Atomic: true, OldValue: stored}).Return(true, (*model.AppError)(nil)).Once()
+		api.On("SendEphemeralPost", "user", mock.Anything).Return(nil)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		appErr := p.executeMailmapCommand([]string{"set", "John", "Doe", "john"}, args)
+		assert.Nil(t, appErr)
+	})
+}
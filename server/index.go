@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// indexKeyPrefix prefixes the keys of the indexes of stored schedules, digests and subscriptions, e.g. index_schedule_.
+	indexKeyPrefix = "index_"
+
+	indexRetries = 5
+)
+
+// getIndex returns the IDs of the values stored with keyPrefix, so that the schedulers don't have to list every key each minute.
+// An index that doesn't exist yet is built from the stored keys once.
+func (p *Plugin) getIndex(keyPrefix string) ([]string, error) {
+	ids, _, err := p.loadIndex(keyPrefix)
+	return ids, err
+}
+
+// loadIndex returns the index of keyPrefix and its stored value, which is nil if the index was built from the stored keys.
+func (p *Plugin) loadIndex(keyPrefix string) ([]string, []byte, error) {
+	data, appErr := p.API.KVGet(indexKeyPrefix + keyPrefix)
+	if appErr != nil {
+		return nil, nil, errors.Wrap(appErr, "failed to load index")
+	}
+	if data == nil {
+		ids, err := p.listKeys(keyPrefix)
+		return ids, nil, err
+	}
+
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode index")
+	}
+	return ids, data, nil
+}
+
+// addToIndex adds id to the index of keyPrefix. Call it after the value has been stored.
+func (p *Plugin) addToIndex(keyPrefix, id string) error {
+	return p.updateIndex(keyPrefix, func(ids []string) ([]string, bool) {
+		for _, existing := range ids {
+			if existing == id {
+				return ids, false
+			}
+		}
+		return append(ids, id), true
+	})
+}
+
+// removeFromIndex removes id from the index of keyPrefix. Call it after the value has been deleted.
+func (p *Plugin) removeFromIndex(keyPrefix, id string) error {
+	return p.updateIndex(keyPrefix, func(ids []string) ([]string, bool) {
+		for i, existing := range ids {
+			if existing == id {
+				return append(ids[:i:i], ids[i+1:]...), true
+			}
+		}
+		return ids, false
+	})
+}
+
+// updateIndex changes the index of keyPrefix with update, which returns if it changed the IDs.
+// The update is retried if the index was changed concurrently.
+func (p *Plugin) updateIndex(keyPrefix string, update func(ids []string) ([]string, bool)) error {
+	for i := 0; i < indexRetries; i++ {
+		ids, oldData, err := p.loadIndex(keyPrefix)
+		if err != nil {
+			return err
+		}
+		ids, changed := update(ids)
+		if !changed && oldData != nil {
+			return nil
+		}
+		if ids == nil {
+			ids = []string{}
+		}
+
+		data, err := json.Marshal(ids)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode index")
+		}
+		// A nil old value only stores an index that doesn't exist yet
+		saved, appErr := p.API.KVSetWithOptions(indexKeyPrefix+keyPrefix, data, model.PluginKVSetOptions{
+			Atomic:   true,
+			OldValue: oldData,
+		})
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to store index")
+		}
+		if saved {
+			return nil
+		}
+	}
+	return errors.New("index was changed concurrently")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	kv := newTestKVStore(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	// Values stored before the index existed are indexed once
+	kv.set(scheduleKeyPrefix+"a", []byte("{}"))
+	kv.set(scheduleKeyPrefix+"b", []byte("{}"))
+	kv.set(digestKeyPrefix+"channel", []byte("{}"))
+
+	require.NoError(t, p.addToIndex(scheduleKeyPrefix, "c"))
+	ids, err := p.getIndex(scheduleKeyPrefix)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, ids)
+
+	require.NoError(t, p.addToIndex(scheduleKeyPrefix, "c"))
+	require.NoError(t, p.removeFromIndex(scheduleKeyPrefix, "a"))
+	require.NoError(t, p.removeFromIndex(scheduleKeyPrefix, "missing"))
+
+	// The index is read without listing the keys
+	kv.set(scheduleKeyPrefix+"unindexed", []byte("{}"))
+	ids, err = p.getIndex(scheduleKeyPrefix)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, ids)
+
+	// Every prefix has its own index
+	ids, err = p.getIndex(digestKeyPrefix)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"channel"}, ids)
+
+	// An emptied index stays empty
+	require.NoError(t, p.removeFromIndex(digestKeyPrefix, "channel"))
+	ids, err = p.getIndex(digestKeyPrefix)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/mattermost/mattermost-plugin-community/server/util"
+)
+
+const (
+	// maxListedReporters is the number of reporters listed in the issue stats.
+	maxListedReporters = 10
+
+	noLabel = "no label"
+)
+
+type issuesResult struct {
+	issues []*github.Issue
+	err    error
+}
+
+// fetchIssuesFromRepos fetches the issues of multiple repositories of the same owner.
+// Repositories that fail to be fetched are skipped, unless ctx is done.
+func (p *Plugin) fetchIssuesFromRepos(ctx context.Context, client *github.Client, owner string, repos []string, since, until time.Time) ([]*github.Issue, error) {
+	var result []*github.Issue
+
+	pr := getProgress(ctx)
+	pr.addRepos(len(repos))
+
+	var wg sync.WaitGroup
+	var jobResults = make(chan issuesResult, len(repos))
+
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			issues, err := p.fetchIssuesFromRepo(ctx, client, owner, repo, since, until)
+			jobResults <- issuesResult{issues, err}
+		}(repo)
+	}
+	go func() {
+		wg.Wait()
+		close(jobResults)
+	}()
+
+	for jr := range jobResults {
+		pr.repoDone(0, jr.err)
+		if jr.err != nil {
+			p.API.LogWarn("Failed to fetch issues", "error", jr.err.Error())
+		} else {
+			result = append(result, jr.issues...)
+		}
+	}
+
+	return result, ctx.Err()
+}
+
+// fetchIssuesFromRepo returns the issues of a repository that were opened or closed between since and until.
+// The issues API also returns pull requests, which are skipped.
+func (p *Plugin) fetchIssuesFromRepo(ctx context.Context, client *github.Client, owner, repo string, since, until time.Time) ([]*github.Issue, error) {
+	var result []*github.Issue
+	opts := &github.IssueListByRepoOptions{
+		State: "all",
+		Since: since,
+		ListOptions: github.ListOptions{
+			PerPage: resultsPerPage,
+		},
+	}
+
+	for {
+		var issues []*github.Issue
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			issues, resp, err = client.Issues.ListByRepo(ctx, owner, repo, opts)
+			return resp, err
+		})
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("repository %v/%v not found", owner, repo)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, issue := range issues {
+			if !issue.IsPullRequest() && isIssueActive(issue, since, until) {
+				result = append(result, issue)
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return result, nil
+}
+
+// isIssueActive checks if an issue was opened or closed between since and until.
+func isIssueActive(issue *github.Issue, since, until time.Time) bool {
+	return inRange(issue.GetCreatedAt(), since, until) || inRange(issue.GetClosedAt(), since, until)
+}
+
+type userCount struct {
+	login string
+	count int
+}
+
+type labelIssues struct {
+	label  string
+	opened int
+	closed int
+}
+
+type issueStats struct {
+	opened            int
+	closed            int
+	medianTimeToClose time.Duration
+	p90TimeToClose    time.Duration
+	reporters         []userCount
+	labels            []*labelIssues
+}
+
+// computeIssueStats counts the issues opened and closed between since and until, per reporter and per label.
+// The time to close is measured for the issues closed in that time.
+func computeIssueStats(issues []*github.Issue, since, until time.Time) issueStats {
+	var stats issueStats
+	var timesToClose []time.Duration
+	reporters := map[string]int{}
+	labels := map[string]*labelIssues{}
+
+	issueLabels := func(issue *github.Issue) []*labelIssues {
+		names := []string{noLabel}
+		if len(issue.Labels) > 0 {
+			names = nil
+			for _, label := range issue.Labels {
+				names = append(names, label.GetName())
+			}
+		}
+
+		var result []*labelIssues
+		for _, name := range names {
+			l, ok := labels[name]
+			if !ok {
+				l = &labelIssues{label: name}
+				labels[name] = l
+			}
+			result = append(result, l)
+		}
+		return result
+	}
+
+	for _, issue := range issues {
+		if issue.IsPullRequest() {
+			continue
+		}
+
+		if inRange(issue.GetCreatedAt(), since, until) {
+			stats.opened++
+			reporters[issue.GetUser().GetLogin()]++
+			for _, l := range issueLabels(issue) {
+				l.opened++
+			}
+		}
+
+		if inRange(issue.GetClosedAt(), since, until) {
+			stats.closed++
+			timesToClose = append(timesToClose, issue.GetClosedAt().Sub(issue.GetCreatedAt()))
+			for _, l := range issueLabels(issue) {
+				l.closed++
+			}
+		}
+	}
+
+	sort.Slice(timesToClose, func(i, j int) bool {
+		return timesToClose[i] < timesToClose[j]
+	})
+	stats.medianTimeToClose = percentile(timesToClose, 50)
+	stats.p90TimeToClose = percentile(timesToClose, 90)
+
+	for login, count := range reporters {
+		stats.reporters = append(stats.reporters, userCount{login, count})
+	}
+	sort.Slice(stats.reporters, func(i, j int) bool {
+		if stats.reporters[i].count != stats.reporters[j].count {
+			return stats.reporters[i].count > stats.reporters[j].count
+		}
+		return stats.reporters[i].login < stats.reporters[j].login
+	})
+
+	for _, l := range labels {
+		stats.labels = append(stats.labels, l)
+	}
+	sort.Slice(stats.labels, func(i, j int) bool {
+		a, b := stats.labels[i], stats.labels[j]
+		if a.opened+a.closed != b.opened+b.closed {
+			return a.opened+a.closed > b.opened+b.closed
+		}
+		return a.label < b.label
+	})
+
+	return stats
+}
+
+func (p *Plugin) executeIssuesCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	return p.startRangeJob(commandArgs, args, &job{Type: jobTypeIssues}, "Fetching issue stats")
+}
+
+func (p *Plugin) updateIssuesPost(ctx context.Context, forge provider, post *model.Post, userID, owner, repo string, isOrg bool, since, until time.Time) error {
+	// Include issues until one day after at midnight
+	fetchUntil := until.AddDate(0, 0, 1).Add(-time.Microsecond)
+
+	issues, err := forge.fetchIssues(ctx, owner, repo, isOrg, since, fetchUntil)
+	err = finishFetching(ctx, err)
+
+	attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
+	if err != nil {
+		p.API.LogError("failed to fetch data", "err", err.Error())
+		attachment.Text = githubErrorHandle(err)
+	} else {
+		issues, filtered := p.getBotFilter().filterIssues(issues)
+		stats := computeIssueStats(issues, since, fetchUntil)
+
+		reporters := stats.reporters
+		if len(reporters) > maxListedReporters {
+			reporters = reporters[:maxListedReporters]
+		}
+		var reporterText string
+		for _, r := range reporters {
+			reporterText += fmt.Sprintf("- [%s](%s): %v opened\n", r.login, forge.webURL(r.login), r.count)
+		}
+
+		var labelText string
+		for _, l := range stats.labels {
+			labelText += fmt.Sprintf("- %s: %v opened, %v closed\n", l.label, l.opened, l.closed)
+		}
+
+		attachment.Title = "Issue stats between " + since.Format(shortFormWithDay) + " and " + until.Format(shortFormWithDay)
+		attachment.Text = ""
+		attachment.Fields = []*model.SlackAttachmentField{{
+			Title: "Opened",
+			Value: strconv.Itoa(stats.opened),
+			Short: true,
+		}, {
+			Title: "Closed",
+			Value: strconv.Itoa(stats.closed),
+			Short: true,
+		}, {
+			Title: "Median time to close",
+			Value: util.FormatDuration(stats.medianTimeToClose),
+			Short: true,
+		}, {
+			Title: "90th percentile time to close",
+			Value: util.FormatDuration(stats.p90TimeToClose),
+			Short: true,
+		}, {
+			Title: "Top reporters",
+			Value: reporterText,
+		}, {
+			Title: "Labels",
+			Value: labelText,
+		}}
+		attachment.Fields = append(attachment.Fields, filtered.field("issues")...)
+	}
+
+	if _, appErr := p.API.UpdatePost(post); appErr != nil {
+		p.SendEphemeralPost(post.ChannelId, userID, "Something went bad. Please try again.")
+		p.API.LogError("failed to update post", "err", appErr.Error())
+		return appErr
+	}
+
+	return err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeIssueStats(t *testing.T) {
+	since := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2020, 9, 30, 23, 59, 59, 0, time.UTC)
+	day := func(d int) *time.Time {
+		t := time.Date(2020, 9, d, 12, 0, 0, 0, time.UTC)
+		return &t
+	}
+	issue := func(login string, createdAt, closedAt *time.Time, labels ...string) *github.Issue {
+		i := &github.Issue{
+			User:      &github.User{Login: github.String(login)},
+			CreatedAt: createdAt,
+			ClosedAt:  closedAt,
+		}
+		for _, label := range labels {
+			i.Labels = append(i.Labels, &github.Label{Name: github.String(label)})
+		}
+		return i
+	}
+	august := time.Date(2020, 8, 30, 12, 0, 0, 0, time.UTC)
+
+	pullRequest := issue("alice", day(1), nil, "bug")
+	pullRequest.PullRequestLinks = &github.PullRequestLinks{}
+
+	stats := computeIssueStats([]*github.Issue{
+		issue("alice", day(1), day(2), "bug"),
+		issue("alice", day(3), nil, "bug", "help wanted"),
+		issue("bob", day(4), day(8)),
+		// Opened before, closed in the time range
+		issue("carol", &august, day(1), "enhancement"),
+		pullRequest,
+	}, since, until)
+
+	assert.Equal(t, 3, stats.opened)
+	assert.Equal(t, 3, stats.closed)
+	assert.Equal(t, 2*24*time.Hour, stats.medianTimeToClose)
+	assert.Equal(t, 4*24*time.Hour, stats.p90TimeToClose)
+
+	assert.Equal(t, []userCount{{"alice", 2}, {"bob", 1}}, stats.reporters)
+
+	require.Len(t, stats.labels, 4)
+	assert.Equal(t, &labelIssues{label: "bug", opened: 2, closed: 1}, stats.labels[0])
+	assert.Equal(t, &labelIssues{label: noLabel, opened: 1, closed: 1}, stats.labels[1])
+	assert.Equal(t, &labelIssues{label: "enhancement", closed: 1}, stats.labels[2])
+	assert.Equal(t, &labelIssues{label: "help wanted", opened: 1}, stats.labels[3])
+}
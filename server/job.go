@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultJobTimeout = time.Hour
+
+	jobIDLength = 8
+
+	jobKeyPrefix       = "job_"
+	jobCancelKeyPrefix = "jobcancel_"
+
+	// jobCancelPollInterval is the interval in which a running job checks if it was cancelled on another node.
+	jobCancelPollInterval = 10 * time.Second
+
+	keysPerKVListPage = 100
+
+	// jobRetention is the time finished jobs are kept to be listed by /community jobs.
+	jobRetention = 7 * 24 * time.Hour
+
+	// resumeJobsKey is the key of the cluster job that resumes the jobs of nodes that went away.
+	resumeJobsKey = "resume_jobs"
+	// resumeJobsInterval is the interval in which unfinished jobs, that no node runs, are resumed.
+	resumeJobsInterval = 15 * time.Minute
+	// jobResumeLockTimeout is the time a node tries to get the mutex of a job it resumes.
+	// If another node holds it, the job is still running there.
+	jobResumeLockTimeout = time.Second
+)
+
+const (
+	jobStatusQueued    = "queued"
+	jobStatusRunning   = "running"
+	jobStatusFinished  = "finished"
+	jobStatusFailed    = "failed"
+	jobStatusCancelled = "cancelled"
+)
+
+const (
+	jobTypeCommitter    = "committer"
+	jobTypeChangelog    = "changelog"
+	jobTypeNewCommitter = "new-committer"
+	jobTypeHackfest     = "hackfest"
+	jobTypeDigest       = "digest"
+	jobTypePullRequests = "prs"
+	jobTypeIssues       = "issues"
+	jobTypeReviewers    = "reviewers"
+	jobTypeResponseTime = "response"
+	jobTypeContributor  = "contributor"
+	jobTypeRetention    = "retention"
+)
+
+// job is a report that runs in the background. Its results are rendered into the loading post.
+// Jobs are stored in the KV store, so that unfinished jobs can be resumed after a restart.
+// Finished jobs are kept for jobRetention.
+type job struct {
+	ID         string
+	Type       string
+	Status     string
+	UserID     string
+	ChannelID  string
+	PostID     string
+	Command    string
+	CreatedAt  time.Time
+	Deadline   time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Progress   string
+	Error      string
+
+	// Target is the owner of the repositories, including the prefix of its forge.
+	Target string
+	Repo   string
+	IsOrg  bool
+	Since  time.Time
+	Until  time.Time
+
+	// Repos and Sections are only used by digests.
+	Repos    []string
+	Sections []string
+
+	// Sort is the measure the committer report is sorted by. Empty means commits.
+	Sort string
+
+	// Login is the user whose profile is created by contributor jobs.
+	Login string
+}
+
+func (p *Plugin) getJobTimeout() time.Duration {
+	if minutes := p.getConfiguration().JobTimeout; minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	return defaultJobTimeout
+}
+
+// getJob loads a job from the KV store. nil is returned if the job doesn't exist.
+func (p *Plugin) getJob(jobID string) (*job, error) {
+	data, appErr := p.API.KVGet(jobKeyPrefix + jobID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to load job")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	j := &job{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, errors.Wrap(err, "failed to decode job")
+	}
+	return j, nil
+}
+
+func (p *Plugin) saveJob(j *job) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode job")
+	}
+
+	if appErr := p.API.KVSet(jobKeyPrefix+j.ID, data); appErr != nil {
+		return errors.Wrap(appErr, "failed to store job")
+	}
+	return nil
+}
+
+func (p *Plugin) deleteJob(jobID string) {
+	if appErr := p.API.KVDelete(jobKeyPrefix + jobID); appErr != nil {
+		p.API.LogWarn("Failed to delete job", "job", jobID, "error", appErr.Error())
+	}
+}
+
+func (p *Plugin) isJobDone(j *job) bool {
+	return j.Status == jobStatusFinished || j.Status == jobStatusFailed || j.Status == jobStatusCancelled
+}
+
+func (p *Plugin) isJobCancelled(jobID string) bool {
+	data, appErr := p.API.KVGet(jobCancelKeyPrefix + jobID)
+	if appErr != nil {
+		p.API.LogWarn("Failed to check job cancellation", "job", jobID, "error", appErr.Error())
+		return false
+	}
+	return data != nil
+}
+
+// listKeys returns every KV key with the given prefix, without the prefix.
+func (p *Plugin) listKeys(prefix string) ([]string, error) {
+	var result []string
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, keysPerKVListPage)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to list keys")
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				result = append(result, strings.TrimPrefix(key, prefix))
+			}
+		}
+
+		if len(keys) < keysPerKVListPage {
+			break
+		}
+	}
+	return result, nil
+}
+
+// startJob creates the loading post of a report and queues the report as job j.
+// The type and parameters of the report have to be set in j.
+func (p *Plugin) startJob(args *model.CommandArgs, j *job, attachments []*model.SlackAttachment) *model.AppError {
+	j.UserID = args.UserId
+	j.ChannelID = args.ChannelId
+	j.Command = strings.TrimSpace(args.Command)
+
+	return p.queueJob(j, attachments)
+}
+
+// queueJob creates the loading post of a report and queues the report as job j.
+// The type and parameters of the report, as well as the user and channel, have to be set in j.
+func (p *Plugin) queueJob(j *job, attachments []*model.SlackAttachment) *model.AppError {
+	now := time.Now()
+	j.ID = model.NewId()[:jobIDLength]
+	j.Status = jobStatusQueued
+	j.CreatedAt = now
+	j.Deadline = now.Add(p.getJobTimeout())
+
+	attachments[0].Footer = fmt.Sprintf("Job %v", j.ID)
+
+	post := &model.Post{
+		ChannelId: j.ChannelID,
+		UserId:    p.botUserID,
+	}
+	model.ParseSlackAttachment(post, attachments)
+
+	post, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		return appErr
+	}
+	j.PostID = post.Id
+
+	if err := p.saveJob(j); err != nil {
+		p.API.LogError("Failed to queue job", "error", err.Error())
+		if appErr := p.API.DeletePost(post.Id); appErr != nil {
+			p.API.LogWarn("Failed to delete loading post", "error", appErr.Error())
+		}
+
+		return &model.AppError{
+			Id:         "Failed to queue the report. Please try again.",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	go p.runJob(j.ID, false)
+
+	return nil
+}
+
+// listJobs returns every stored job. Jobs that finished more than jobRetention ago are deleted instead.
+func (p *Plugin) listJobs() ([]*job, error) {
+	jobIDs, err := p.listKeys(jobKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*job
+	for _, jobID := range jobIDs {
+		j, err := p.getJob(jobID)
+		if err != nil {
+			p.API.LogWarn("Failed to load job", "job", jobID, "error", err.Error())
+			continue
+		}
+		if j == nil {
+			continue
+		}
+
+		if p.isJobDone(j) && time.Since(j.FinishedAt) > jobRetention {
+			p.deleteJob(jobID)
+			continue
+		}
+		result = append(result, j)
+	}
+	return result, nil
+}
+
+// resumeJobs runs every job that was queued or running when the plugin was stopped, or whose node went away.
+// Jobs that are still running on another node are left alone.
+func (p *Plugin) resumeJobs() {
+	jobs, err := p.listJobs()
+	if err != nil {
+		p.API.LogError("Failed to resume jobs", "error", err.Error())
+		return
+	}
+
+	for _, j := range jobs {
+		if !p.isJobDone(j) {
+			go p.runJob(j.ID, true)
+		}
+	}
+}
+
+// runJob runs a stored job. A cluster mutex makes sure that only one node runs a job at a time.
+// Resumed jobs are only run if the mutex can be locked right away, so that every node can resume jobs,
+// without all but one of them waiting for the mutex until the deadline of a job.
+func (p *Plugin) runJob(jobID string, resumed bool) {
+	j, err := p.getJob(jobID)
+	if err != nil {
+		p.API.LogError("Failed to run job", "job", jobID, "error", err.Error())
+		return
+	}
+	if j == nil || p.isJobDone(j) {
+		return
+	}
+	if !time.Now().Before(j.Deadline) {
+		// The job was resumed after its deadline, e.g. because the plugin was stopped for too long
+		p.expireJob(j)
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), j.Deadline)
+	defer cancel()
+
+	mutex, err := cluster.NewMutex(p.API, jobKeyPrefix+jobID)
+	if err != nil {
+		p.API.LogError("Failed to create job mutex", "job", jobID, "error", err.Error())
+		return
+	}
+	lockCtx := ctx
+	if resumed {
+		var cancelLock context.CancelFunc
+		lockCtx, cancelLock = context.WithTimeout(ctx, jobResumeLockTimeout)
+		defer cancelLock()
+	}
+	if err = mutex.LockWithContext(lockCtx); err != nil {
+		// The job is run by another node
+		return
+	}
+	defer mutex.Unlock()
+
+	// The job might have been finished by another node in the meantime
+	j, err = p.getJob(jobID)
+	if err != nil {
+		p.API.LogError("Failed to run job", "job", jobID, "error", err.Error())
+		return
+	}
+	if j == nil || p.isJobDone(j) {
+		return
+	}
+
+	j.Status = jobStatusRunning
+	j.StartedAt = time.Now()
+	if err = p.saveJob(j); err != nil {
+		p.API.LogWarn("Failed to update job status", "job", jobID, "error", err.Error())
+	}
+
+	p.jobsLock.Lock()
+	if p.jobCancels == nil {
+		p.jobCancels = map[string]context.CancelFunc{}
+	}
+	p.jobCancels[jobID] = cancel
+	p.jobsLock.Unlock()
+
+	go p.watchJobCancellation(ctx, jobID, cancel)
+
+	err = p.executeJob(ctx, j)
+
+	p.jobsLock.Lock()
+	delete(p.jobCancels, jobID)
+	p.jobsLock.Unlock()
+
+	j.FinishedAt = time.Now()
+	switch {
+	case errors.Cause(err) == context.Canceled:
+		j.Status = jobStatusCancelled
+	case err != nil:
+		j.Status = jobStatusFailed
+		j.Error = err.Error()
+	default:
+		j.Status = jobStatusFinished
+	}
+	if err = p.saveJob(j); err != nil {
+		p.API.LogWarn("Failed to update job status", "job", jobID, "error", err.Error())
+	}
+
+	if appErr := p.API.KVDelete(jobCancelKeyPrefix + jobID); appErr != nil {
+		p.API.LogWarn("Failed to delete job cancellation", "job", jobID, "error", appErr.Error())
+	}
+}
+
+// expireJob marks a job as failed, that can't run anymore because its deadline has passed, and tells its loading post.
+func (p *Plugin) expireJob(j *job) {
+	j.Status = jobStatusFailed
+	j.Error = timeoutMessage
+	j.FinishedAt = time.Now()
+	if err := p.saveJob(j); err != nil {
+		p.API.LogWarn("Failed to update job status", "job", j.ID, "error", err.Error())
+	}
+
+	post, appErr := p.API.GetPost(j.PostID)
+	if appErr != nil {
+		p.API.LogWarn("Failed to load post of job", "job", j.ID, "error", appErr.Error())
+		return
+	}
+	model.ParseSlackAttachment(post, post.Attachments())
+	if attachments := post.Attachments(); len(attachments) > 0 {
+		attachments[0].Text = timeoutMessage
+		p.updatePost(post, j.UserID)
+	}
+}
+
+// watchJobCancellation cancels a running job, once it was cancelled on any node.
+func (p *Plugin) watchJobCancellation(ctx context.Context, jobID string, cancel context.CancelFunc) {
+	if p.isJobCancelled(jobID) {
+		cancel()
+		return
+	}
+
+	ticker := time.NewTicker(jobCancelPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if p.isJobCancelled(jobID) {
+				cancel()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// executeJob renders the report of a job into its loading post.
+// The progress of the report is stored in the job while it runs.
+func (p *Plugin) executeJob(ctx context.Context, j *job) error {
+	post, appErr := p.API.GetPost(j.PostID)
+	if appErr != nil {
+		p.API.LogError("Failed to load post of job", "job", j.ID, "error", appErr.Error())
+		return appErr
+	}
+	// Attachments of stored posts are decoded as generic JSON, but reports expect SlackAttachments
+	model.ParseSlackAttachment(post, post.Attachments())
+
+	ctx = p.trackProgress(ctx, post, func(progress string) {
+		j.Progress = progress
+		if err := p.saveJob(j); err != nil {
+			p.API.LogWarn("Failed to store job progress", "job", j.ID, "error", err.Error())
+		}
+	})
+	defer getProgress(ctx).finish()
+
+	forge, owner, appErr := p.getProvider(j.UserID, j.Target)
+	if appErr != nil {
+		post.Props["attachments"].([]*model.SlackAttachment)[0].Text = appErr.Id
+		p.updatePost(post, j.UserID)
+		return appErr
+	}
+
+	switch j.Type {
+	case jobTypeCommitter:
+		return p.updateCommittersPost(ctx, forge, post, j.UserID, owner, j.Repo, j.IsOrg, j.Since, j.Until, j.Sort)
+	case jobTypeChangelog:
+		return p.updateChangelogPost(ctx, forge, post, j.UserID, owner, j.Repo, j.Since)
+	case jobTypeNewCommitter:
+		return p.updateNewCommittersPost(ctx, forge, post, j.UserID, owner, j.Since)
+	case jobTypeHackfest:
+		return p.updateHackfestContributorsPost(ctx, forge, post, j.UserID, owner, j.Repo, j.Since, j.Until)
+	case jobTypePullRequests:
+		return p.updatePullRequestsPost(ctx, forge, post, j.UserID, owner, j.Repo, j.IsOrg, j.Since, j.Until)
+	case jobTypeIssues:
+		return p.updateIssuesPost(ctx, forge, post, j.UserID, owner, j.Repo, j.IsOrg, j.Since, j.Until)
+	case jobTypeReviewers:
+		return p.updateReviewersPost(ctx, forge, post, j.UserID, owner, j.Repo, j.IsOrg, j.Since, j.Until)
+	case jobTypeResponseTime:
+		return p.updateResponseTimePost(ctx, forge, post, j.UserID, owner, j.Repo, j.IsOrg, j.Since, j.Until)
+	case jobTypeContributor:
+		return p.updateContributorPost(ctx, forge, post, j.UserID, owner, j.IsOrg, j.Login)
+	case jobTypeRetention:
+		return p.updateRetentionPost(ctx, forge, post, j.UserID, owner, j.Since)
+	case jobTypeDigest:
+		return p.updateDigestPost(ctx, forge, post, j.UserID, owner, j.Repos, j.Sections, j.Since, j.Until)
+	default:
+		return errors.Errorf("unknown job type %v", j.Type)
+	}
+}
+
+func (p *Plugin) executeCancelCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	if len(commandArgs) != 1 {
+		return &model.AppError{
+			Id:         "Need one argument",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	jobID := strings.ToLower(commandArgs[0])
+
+	j, err := p.getJob(jobID)
+	if err != nil {
+		p.API.LogError("Failed to load job", "job", jobID, "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to load job",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	if j == nil || p.isJobDone(j) {
+		return &model.AppError{
+			Id:         fmt.Sprintf("Job %v not found. It might have finished already.", jobID),
+			StatusCode: http.StatusNotFound,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	if j.UserID != args.UserId && !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return &model.AppError{
+			Id:         "Only the creator of a job or a system administrator can cancel it.",
+			StatusCode: http.StatusForbidden,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	// The node that runs the job picks up the cancellation from the KV store
+	if appErr := p.API.KVSet(jobCancelKeyPrefix+jobID, []byte(args.UserId)); appErr != nil {
+		return appErr
+	}
+
+	p.jobsLock.Lock()
+	if cancel, ok := p.jobCancels[jobID]; ok {
+		cancel()
+	}
+	p.jobsLock.Unlock()
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, fmt.Sprintf("Cancelled job %v.", j.ID))
+
+	return nil
+}
+
+// maxListedJobs is the maximum number of jobs listed by /community jobs.
+const maxListedJobs = 25
+
+func (p *Plugin) executeJobsCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	if len(commandArgs) != 0 {
+		return &model.AppError{
+			Id:         "Need no arguments",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	jobs, err := p.listJobs()
+	if err != nil {
+		p.API.LogError("Failed to list jobs", "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to list jobs",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	isAdmin := p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM)
+
+	var visible []*job
+	for _, j := range jobs {
+		if isAdmin || j.UserID == args.UserId {
+			visible = append(visible, j)
+		}
+	}
+
+	if len(visible) == 0 {
+		p.SendEphemeralPost(args.ChannelId, args.UserId, "There are no jobs.")
+		return nil
+	}
+
+	sort.Slice(visible, func(i, j int) bool {
+		return visible[i].CreatedAt.After(visible[j].CreatedAt)
+	})
+	if len(visible) > maxListedJobs {
+		visible = visible[:maxListedJobs]
+	}
+
+	usernames := map[string]string{}
+	teamNames := map[string]string{}
+
+	text := "| Job | Status | Started by | Target | Started | Progress | Report |\n"
+	text += "|:----|:-------|:-----------|:-------|:--------|:---------|:-------|\n"
+	for _, j := range visible {
+		username, ok := usernames[j.UserID]
+		if !ok {
+			if user, appErr := p.API.GetUser(j.UserID); appErr == nil {
+				username = "@" + user.Username
+			}
+			usernames[j.UserID] = username
+		}
+
+		target := j.Target
+		if j.Login != "" {
+			target = j.Login + " in " + target
+		}
+		if j.Repo != "" {
+			target += "/" + j.Repo
+		} else if len(j.Repos) > 0 {
+			target += "/" + strings.Join(j.Repos, ",")
+		}
+
+		started := j.CreatedAt
+		if !j.StartedAt.IsZero() {
+			started = j.StartedAt
+		}
+
+		status := j.Status
+		if j.Status == jobStatusFailed && j.Error != "" {
+			status += ": " + strings.Replace(j.Error, "|", "\\|", -1)
+		}
+
+		progress := j.Progress
+		if progress == "" {
+			progress = "-"
+		}
+
+		text += fmt.Sprintf("| %v | %v | %v | %v %v | %v | %v | [Open](%v) |\n",
+			j.ID, status, username, j.Type, target, started.UTC().Format("2006-01-02 15:04 MST"), progress, p.getPermalink(j, args.TeamId, teamNames))
+	}
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, text)
+	return nil
+}
+
+// getPermalink returns the permalink of the loading post of a job. teamNames caches team names by channel ID.
+func (p *Plugin) getPermalink(j *job, defaultTeamID string, teamNames map[string]string) string {
+	teamName, ok := teamNames[j.ChannelID]
+	if !ok {
+		teamID := defaultTeamID
+		// Direct and group messages don't belong to a team
+		if channel, appErr := p.API.GetChannel(j.ChannelID); appErr == nil && channel.TeamId != "" {
+			teamID = channel.TeamId
+		}
+		if team, appErr := p.API.GetTeam(teamID); appErr == nil {
+			teamName = team.Name
+		}
+		teamNames[j.ChannelID] = teamName
+	}
+
+	siteURL := ""
+	if config := p.API.GetConfig(); config.ServiceSettings.SiteURL != nil {
+		siteURL = strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/")
+	}
+	if teamName == "" {
+		// The webapp redirects to a team of the user for posts in direct and group messages
+		return fmt.Sprintf("%v/_redirect/pl/%v", siteURL, j.PostID)
+	}
+	return fmt.Sprintf("%v/%v/pl/%v", siteURL, teamName, j.PostID)
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testKVStore serves the KV methods of a plugintest.API from a map.
+type testKVStore struct {
+	lock sync.Mutex
+	data map[string][]byte
+}
+
+func newTestKVStore(api *plugintest.API) *testKVStore {
+	kv := &testKVStore{data: map[string][]byte{}}
+
+	api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+		return kv.get(key)
+	}, func(string) *model.AppError {
+		return nil
+	}).Maybe()
+	api.On("KVSet", mock.Anything, mock.Anything).Return(func(key string, value []byte) *model.AppError {
+		kv.set(key, value)
+		return nil
+	}).Maybe()
+	api.On("KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, value []byte, options model.PluginKVSetOptions) bool {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		if current, exists := kv.data[key]; options.Atomic && (exists != (options.OldValue != nil) || !bytes.Equal(current, options.OldValue)) {
+			return false
+		}
+		if value == nil {
+			delete(kv.data, key)
+		} else {
+			kv.data[key] = value
+		}
+		return true
+	}, func(string, []byte, model.PluginKVSetOptions) *model.AppError {
+		return nil
+	}).Maybe()
+	api.On("KVDelete", mock.Anything).Return(func(key string) *model.AppError {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		delete(kv.data, key)
+		return nil
+	}).Maybe()
+	api.On("KVList", mock.Anything, mock.Anything).Return(func(page, perPage int) []string {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		var keys []string
+		for key := range kv.data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if page*perPage >= len(keys) {
+			return []string{}
+		}
+		keys = keys[page*perPage:]
+		if len(keys) > perPage {
+			keys = keys[:perPage]
+		}
+		return keys
+	}, func(int, int) *model.AppError {
+		return nil
+	}).Maybe()
+
+	return kv
+}
+
+func (kv *testKVStore) get(key string) []byte {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.data[key]
+}
+
+func (kv *testKVStore) set(key string, value []byte) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	kv.data[key] = value
+}
+
+// allowLogs accepts log messages with up to four key value pairs.
+func allowLogs(api *plugintest.API) {
+	for _, method := range []string{"LogDebug", "LogInfo", "LogWarn", "LogError"} {
+		args := []interface{}{mock.Anything}
+		for i := 0; i <= 8; i++ {
+			api.On(method, args...).Maybe()
+			args = append(args, mock.Anything)
+		}
+	}
+}
+
+func storeTestJob(t *testing.T, kv *testKVStore, j *job) {
+	data, err := json.Marshal(j)
+	require.NoError(t, err)
+	kv.set(jobKeyPrefix+j.ID, data)
+}
+
+func loadTestJob(t *testing.T, kv *testKVStore, jobID string) *job {
+	data := kv.get(jobKeyPrefix + jobID)
+	require.NotNil(t, data)
+	j := &job{}
+	require.NoError(t, json.Unmarshal(data, j))
+	return j
+}
+
+// waitForJob waits until the stored job has the given status.
+func waitForJob(t *testing.T, kv *testKVStore, jobID, status string) *job {
+	var j *job
+	require.Eventually(t, func() bool {
+		j = loadTestJob(t, kv, jobID)
+		return j.Status == status
+	}, 5*time.Second, 10*time.Millisecond)
+	return j
+}
+
+func newTestJobPost() *model.Post {
+	post := &model.Post{Id: "post", ChannelId: "channel"}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{Text: waitText}})
+	return post
+}
+
+func TestGithubErrorHandle(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err      error
+		expected string
+	}{
+		"rate limit":       {&github.RateLimitError{Message: "limit"}, rateLimitMessage},
+		"cancelled":        {errors.Wrap(context.Canceled, "failed to fetch"), cancelledMessage},
+		"deadline":         {errors.Wrap(context.DeadlineExceeded, "failed to fetch"), timeoutMessage},
+		"any other errors": {errors.New("not found"), "Failed to fetch data:not found"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, githubErrorHandle(tc.err))
+		})
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	setup := func(t *testing.T) (*Plugin, *plugintest.API, *testKVStore) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		kv := newTestKVStore(api)
+		api.On("GetPost", "post").Return(newTestJobPost(), (*model.AppError)(nil)).Maybe()
+		api.On("UpdatePost", mock.Anything).Return(nil, (*model.AppError)(nil)).Maybe()
+
+		p := &Plugin{scheduler: newScheduler(2, func(string, ...interface{}) {})}
+		p.SetAPI(api)
+		return p, api, kv
+	}
+
+	t.Run("failures are recorded", func(t *testing.T) {
+		p, _, kv := setup(t)
+		p.setConfiguration(&configuration{GitLabURL: "https://gitlab.example.com"})
+		storeTestJob(t, kv, &job{ID: "failing", Type: "unknown", Status: jobStatusQueued, PostID: "post", Target: gitLabPrefix + "group", Deadline: time.Now().Add(time.Hour)})
+
+		p.runJob("failing", false)
+
+		j := loadTestJob(t, kv, "failing")
+		assert.Equal(t, jobStatusFailed, j.Status)
+		assert.Equal(t, "unknown job type unknown", j.Error)
+		assert.False(t, j.StartedAt.IsZero())
+		assert.False(t, j.FinishedAt.IsZero())
+		// The mutex is released
+		assert.Nil(t, kv.get("mutex_"+jobKeyPrefix+"failing"))
+	})
+
+	t.Run("jobs past their deadline time out", func(t *testing.T) {
+		p, api, kv := setup(t)
+		storeTestJob(t, kv, &job{ID: "expired", Type: jobTypeCommitter, Status: jobStatusRunning, PostID: "post", Deadline: time.Now().Add(-time.Minute)})
+
+		p.runJob("expired", false)
+
+		j := loadTestJob(t, kv, "expired")
+		assert.Equal(t, jobStatusFailed, j.Status)
+		assert.Equal(t, timeoutMessage, j.Error)
+		api.AssertCalled(t, "UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Attachments()[0].Text == timeoutMessage
+		}))
+	})
+
+	t.Run("done jobs aren't run again", func(t *testing.T) {
+		p, _, kv := setup(t)
+		storeTestJob(t, kv, &job{ID: "done", Status: jobStatusFinished, Deadline: time.Now().Add(time.Hour)})
+
+		p.runJob("done", false)
+
+		assert.Equal(t, jobStatusFinished, loadTestJob(t, kv, "done").Status)
+		assert.Nil(t, kv.get("mutex_"+jobKeyPrefix+"done"))
+	})
+
+	t.Run("running jobs can be cancelled", func(t *testing.T) {
+		p, api, kv := setup(t)
+		api.On("HasPermissionTo", "other", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("SendEphemeralPost", "owner", mock.Anything).Return(nil)
+
+		// Requests hang until they are cancelled
+		requested := make(chan struct{}, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case requested <- struct{}{}:
+			default:
+			}
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+		p.setConfiguration(&configuration{GitLabURL: server.URL})
+
+		storeTestJob(t, kv, &job{ID: "running", Type: jobTypeCommitter, Status: jobStatusQueued, UserID: "owner", ChannelID: "channel", PostID: "post", Target: gitLabPrefix + "group", IsOrg: true, Deadline: time.Now().Add(time.Hour)})
+
+		done := make(chan struct{})
+		go func() {
+			p.runJob("running", false)
+			close(done)
+		}()
+		<-requested
+		assert.Equal(t, jobStatusRunning, loadTestJob(t, kv, "running").Status)
+
+		// Only the owner and administrators can cancel a job
+		appErr := p.executeCancelCommand([]string{"running"}, &model.CommandArgs{UserId: "other", ChannelId: "channel"})
+		require.NotNil(t, appErr)
+		assert.Equal(t, http.StatusForbidden, appErr.StatusCode)
+
+		appErr = p.executeCancelCommand([]string{"RUNNING"}, &model.CommandArgs{UserId: "owner", ChannelId: "channel"})
+		require.Nil(t, appErr)
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("job wasn't cancelled")
+		}
+		assert.Equal(t, jobStatusCancelled, loadTestJob(t, kv, "running").Status)
+		assert.Nil(t, kv.get(jobCancelKeyPrefix+"running"))
+
+		p.jobsLock.Lock()
+		assert.Empty(t, p.jobCancels)
+		p.jobsLock.Unlock()
+
+		// Finished jobs can't be cancelled anymore
+		appErr = p.executeCancelCommand([]string{"running"}, &model.CommandArgs{UserId: "owner", ChannelId: "channel"})
+		require.NotNil(t, appErr)
+		assert.Equal(t, http.StatusNotFound, appErr.StatusCode)
+	})
+
+	t.Run("jobs cancelled on another node are stopped", func(t *testing.T) {
+		p, _, kv := setup(t)
+		p.setConfiguration(&configuration{GitLabURL: "https://gitlab.example.com"})
+		storeTestJob(t, kv, &job{ID: "remote", Type: jobTypeCommitter, Status: jobStatusQueued, PostID: "post", Target: gitLabPrefix + "group", IsOrg: true, Deadline: time.Now().Add(time.Hour)})
+		kv.set(jobCancelKeyPrefix+"remote", []byte("owner"))
+
+		// Hold the scheduler, so that the job can't fetch anything before it's cancelled
+		p.scheduler.pause(time.Now().Add(time.Hour))
+		p.runJob("remote", false)
+
+		assert.Equal(t, jobStatusCancelled, loadTestJob(t, kv, "remote").Status)
+		assert.Nil(t, kv.get(jobCancelKeyPrefix+"remote"))
+	})
+}
+
+func TestResumeJobs(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	kv := newTestKVStore(api)
+	api.On("GetPost", "post").Return(newTestJobPost(), (*model.AppError)(nil)).Maybe()
+	api.On("UpdatePost", mock.Anything).Return(nil, (*model.AppError)(nil)).Maybe()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{GitLabURL: "https://gitlab.example.com"})
+
+	now := time.Now()
+	storeTestJob(t, kv, &job{ID: "queued", Type: "unknown", Status: jobStatusQueued, PostID: "post", Target: gitLabPrefix + "group", Deadline: now.Add(time.Hour)})
+	storeTestJob(t, kv, &job{ID: "interrupted", Type: "unknown", Status: jobStatusRunning, PostID: "post", Target: gitLabPrefix + "group", Deadline: now.Add(time.Hour)})
+	storeTestJob(t, kv, &job{ID: "recent", Status: jobStatusFinished, FinishedAt: now.Add(-time.Hour)})
+	storeTestJob(t, kv, &job{ID: "old", Status: jobStatusFailed, FinishedAt: now.Add(-jobRetention - time.Hour)})
+	// Another node holds the mutex of a job it runs
+	storeTestJob(t, kv, &job{ID: "elsewhere", Type: "unknown", Status: jobStatusRunning, PostID: "post", Target: gitLabPrefix + "group", Deadline: now.Add(time.Hour)})
+	kv.set("mutex_"+jobKeyPrefix+"elsewhere", []byte{1})
+
+	p.resumeJobs()
+
+	waitForJob(t, kv, "queued", jobStatusFailed)
+	waitForJob(t, kv, "interrupted", jobStatusFailed)
+	assert.Equal(t, jobStatusFinished, loadTestJob(t, kv, "recent").Status)
+	assert.Nil(t, kv.get(jobKeyPrefix+"old"))
+	assert.Never(t, func() bool {
+		return loadTestJob(t, kv, "elsewhere").Status != jobStatusRunning
+	}, jobResumeLockTimeout+200*time.Millisecond, 20*time.Millisecond)
+}
+
+func TestExecuteJobsCommand(t *testing.T) {
+	setup := func(t *testing.T, userID string, isAdmin bool) (*Plugin, *string) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		kv := newTestKVStore(api)
+
+		now := time.Now()
+		storeTestJob(t, kv, &job{ID: "own", Type: jobTypeCommitter, Status: jobStatusRunning, UserID: "alice", ChannelID: "channel", PostID: "post1", Target: "mattermost", CreatedAt: now, Progress: "Scanned 1/2 repositories"})
+		storeTestJob(t, kv, &job{ID: "others", Type: jobTypeContributor, Status: jobStatusFailed, UserID: "bob", ChannelID: "channel", PostID: "post2", Target: "mattermost", Login: "jane", CreatedAt: now.Add(-time.Minute), FinishedAt: now, Error: "a|b"})
+
+		api.On("HasPermissionTo", userID, model.PERMISSION_MANAGE_SYSTEM).Return(isAdmin)
+		api.On("GetUser", "alice").Return(&model.User{Username: "alice"}, (*model.AppError)(nil)).Maybe()
+		api.On("GetUser", "bob").Return(&model.User{Username: "bob"}, (*model.AppError)(nil)).Maybe()
+		api.On("GetChannel", "channel").Return(&model.Channel{TeamId: "team"}, (*model.AppError)(nil)).Maybe()
+		api.On("GetTeam", "team").Return(&model.Team{Name: "core"}, (*model.AppError)(nil)).Maybe()
+		api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewString("https://chat.example.com/")}}).Maybe()
+
+		var message string
+		api.On("SendEphemeralPost", userID, mock.Anything).Run(func(args mock.Arguments) {
+			message = args.Get(1).(*model.Post).Message
+		}).Return(nil)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+		return p, &message
+	}
+
+	t.Run("users see their own jobs", func(t *testing.T) {
+		p, message := setup(t, "alice", false)
+		require.Nil(t, p.executeJobsCommand(nil, &model.CommandArgs{UserId: "alice", ChannelId: "channel"}))
+
+		lines := strings.Split(strings.TrimSpace(*message), "\n")
+		require.Len(t, lines, 3)
+		assert.Contains(t, lines[2], "| own | running | @alice | committer mattermost |")
+		assert.Contains(t, lines[2], "| Scanned 1/2 repositories | [Open](https://chat.example.com/core/pl/post1) |")
+	})
+
+	t.Run("administrators see every job", func(t *testing.T) {
+		p, message := setup(t, "admin", true)
+		require.Nil(t, p.executeJobsCommand(nil, &model.CommandArgs{UserId: "admin", ChannelId: "channel"}))
+
+		lines := strings.Split(strings.TrimSpace(*message), "\n")
+		require.Len(t, lines, 4)
+		assert.Contains(t, lines[2], "| own |")
+		assert.Contains(t, lines[3], "| others | failed: a\\|b | @bob | contributor jane in mattermost |")
+	})
+
+	t.Run("users without jobs are told so", func(t *testing.T) {
+		p, message := setup(t, "carol", false)
+		require.Nil(t, p.executeJobsCommand(nil, &model.CommandArgs{UserId: "carol", ChannelId: "channel"}))
+		assert.Equal(t, "There are no jobs.", *message)
+	})
+}
+
+func TestGetPermalink(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("GetChannel", "channel").Return(&model.Channel{TeamId: "team"}, (*model.AppError)(nil))
+	api.On("GetChannel", "direct").Return(&model.Channel{Type: model.CHANNEL_DIRECT}, (*model.AppError)(nil))
+	api.On("GetTeam", "team").Return(&model.Team{Name: "core"}, (*model.AppError)(nil))
+	api.On("GetTeam", "").Return(nil, model.NewAppError("GetTeam", "app.team.get.missing.app_error", nil, "", http.StatusNotFound))
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewString("https://chat.example.com/")}})
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	teamNames := map[string]string{}
+	assert.Equal(t, "https://chat.example.com/core/pl/post1", p.getPermalink(&job{ChannelID: "channel", PostID: "post1"}, "", teamNames))
+	// Direct messages fall back to the team of the command, or link without a team
+	assert.Equal(t, "https://chat.example.com/core/pl/post2", p.getPermalink(&job{ChannelID: "direct", PostID: "post2"}, "team", map[string]string{}))
+	assert.Equal(t, "https://chat.example.com/_redirect/pl/post3", p.getPermalink(&job{ChannelID: "direct", PostID: "post3"}, "", teamNames))
+}
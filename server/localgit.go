@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/pkg/errors"
+)
+
+const (
+	authorCacheKeyPrefix = "authors_"
+
+	// unresolvedAuthorTTL is the time after which the account of an email address, that couldn't be resolved, is looked up again.
+	unresolvedAuthorTTL = 7 * 24 * time.Hour
+
+	// localGitLogFormat separates the fields of a commit with the unit separator and commits with the record separator.
+	localGitLogFormat = "%H%x1f%aN%x1f%aE%x1f%aI%x1f%cN%x1f%cE%x1f%cI%x1f%B%x1e"
+
+	// localGitStatsPerCall is the number of commits whose stats are read with a single git command.
+	localGitStatsPerCall = 500
+)
+
+// gitConfigEnvVersion is the first git version that reads configuration from GIT_CONFIG_COUNT and friends.
+var gitConfigEnvVersion = []int{2, 31}
+
+// cachedAuthor is the account an email address of a commit author belongs to. Login is empty, if none was found.
+type cachedAuthor struct {
+	ID        int64
+	Login     string
+	CheckedAt time.Time
+}
+
+// localGitProvider reads the commits of the configured repositories from mirror clones on the local disk.
+// Every other repository, and everything besides commits, is fetched from the wrapped provider.
+// The API of the wrapped provider is only used to find the accounts of commit authors by their email address.
+type localGitProvider struct {
+	provider
+	p *Plugin
+
+	dir      string
+	patterns []string
+
+	// headerOnce looks up the credentials for the first git command, header is the authorization header they result in.
+	headerOnce sync.Once
+	header     string
+}
+
+// withLocalClones wraps forge in a localGitProvider, if local clones are configured.
+func (p *Plugin) withLocalClones(forge provider) provider {
+	config := p.getConfiguration()
+	if config.LocalGitDirectory == "" || strings.TrimSpace(config.LocalGitRepositories) == "" {
+		return forge
+	}
+
+	var patterns []string
+	for _, pattern := range strings.Split(config.LocalGitRepositories, ",") {
+		if pattern = strings.ToLower(strings.TrimSpace(pattern)); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return &localGitProvider{
+		provider: forge,
+		p:        p,
+		dir:      config.LocalGitDirectory,
+		patterns: patterns,
+	}
+}
+
+// isLocal checks if the commits of a repository are read from a local clone.
+func (l *localGitProvider) isLocal(owner, repo string) bool {
+	name := strings.ToLower(owner + "/" + repo)
+	for _, pattern := range l.patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *localGitProvider) splitRepos(owner string, repos []string) (local, remote []string) {
+	for _, repo := range repos {
+		if l.isLocal(owner, repo) {
+			local = append(local, repo)
+		} else {
+			remote = append(remote, repo)
+		}
+	}
+	return local, remote
+}
+
+func (l *localGitProvider) mirrorPath(owner, repo string) (string, error) {
+	elements := []string{l.dir, l.id()}
+	for _, element := range strings.Split(owner+"/"+repo, "/") {
+		if element == "" || element == "." || element == ".." || strings.ContainsAny(element, `\:`) {
+			return "", errors.Errorf("invalid repository name %v/%v", owner, repo)
+		}
+		elements = append(elements, element)
+	}
+
+	elements[len(elements)-1] += ".git"
+	return filepath.Join(elements...), nil
+}
+
+// git runs a git command. If mirror isn't empty, the command runs against that repository.
+func (l *localGitProvider) git(ctx context.Context, mirror string, args ...string) ([]byte, error) {
+	if mirror != "" {
+		args = append([]string{"--git-dir", mirror}, args...)
+	}
+
+	name := args[len(args)-1]
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if header := l.authorizationHeader(); header != "" {
+		if l.p.gitReadsConfigFromEnv() {
+			// Pass the credentials through the environment to keep them out of the process list
+			env = append(env, "GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=http.extraHeader", "GIT_CONFIG_VALUE_0="+header)
+		} else {
+			// Only the last argument is ever logged
+			args = append([]string{"-c", "http.extraHeader=" + header}, args...)
+		}
+	}
+
+	// #nosec G204 -- the arguments never contain user input that isn't validated
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = env
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrapf(err, "git %v failed: %v", name, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// gitReadsConfigFromEnv checks once if the installed git reads configuration from the environment, which git 2.31 added.
+func (p *Plugin) gitReadsConfigFromEnv() bool {
+	p.gitVersionOnce.Do(func() {
+		out, err := exec.Command("git", "version").Output()
+		if err != nil {
+			p.API.LogWarn("Failed to check git version", "error", err.Error())
+			return
+		}
+
+		version := parseGitVersion(string(out))
+		p.gitConfigEnv = compareVersions(version, gitConfigEnvVersion) >= 0
+		if !p.gitConfigEnv {
+			p.API.LogWarn("git is older than 2.31. Credentials for local clones are passed as arguments, which other users of the server can see in the process list.", "version", strings.TrimSpace(string(out)))
+		}
+	})
+	return p.gitConfigEnv
+}
+
+// parseGitVersion returns the numbers of the output of git version, e.g. [2 39 2] for "git version 2.39.2 (Apple Git-143)".
+func parseGitVersion(out string) []int {
+	fields := strings.Fields(out)
+	if len(fields) < 3 {
+		return nil
+	}
+
+	var result []int
+	for _, part := range strings.Split(fields[2], ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			break
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+// compareVersions returns -1, 0 or 1 if version a is lower than, equal to or higher than b. Missing numbers count as 0.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// authorizationHeader returns the header that authenticates git with the token of the wrapped provider, or an empty string if it has none.
+// The token is looked up once per provider.
+func (l *localGitProvider) authorizationHeader() string {
+	l.headerOnce.Do(func() {
+		var credentials string
+		switch forge := l.provider.(type) {
+		case *gitHubProvider:
+			token, err := forge.gitToken()
+			if err != nil {
+				l.p.API.LogWarn("Failed to get GitHub token for local clones", "error", err.Error())
+			}
+			if token != "" {
+				credentials = "x-access-token:" + token
+			}
+		case *gitLabProvider:
+			if token := l.p.getConfiguration().GitLabToken; token != "" {
+				credentials = "oauth2:" + token
+			}
+		}
+
+		if credentials != "" {
+			l.header = "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+		}
+	})
+	return l.header
+}
+
+// syncMirror clones a repository, or fetches the latest changes if a clone already exists.
+// If fetching fails, e.g. because the server is offline, the existing clone is used as it is.
+func (l *localGitProvider) syncMirror(ctx context.Context, owner, repo string) (string, error) {
+	mirror, err := l.mirrorPath(owner, repo)
+	if err != nil {
+		return "", err
+	}
+
+	lock, _ := l.p.mirrorLocks.LoadOrStore(mirror, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err = os.Stat(mirror); os.IsNotExist(err) {
+		if err = l.cloneMirror(ctx, owner, repo, mirror); err != nil {
+			return "", err
+		}
+		return mirror, nil
+	}
+
+	if _, err = l.git(ctx, mirror, "remote", "update", "--prune"); err != nil {
+		l.p.API.LogWarn("Failed to update local clone. Using the existing data.", "repo", owner+"/"+repo, "error", err.Error())
+	}
+	return mirror, nil
+}
+
+// cloneMirror clones a repository into a temporary directory next to mirror, and moves it into place once it's complete.
+// That way, a clone that is interrupted, e.g. because the report is cancelled, doesn't leave a broken mirror behind.
+func (l *localGitProvider) cloneMirror(ctx context.Context, owner, repo, mirror string) error {
+	if err := os.MkdirAll(filepath.Dir(mirror), 0700); err != nil {
+		return errors.Wrap(err, "failed to create directory for local clone")
+	}
+
+	tmp, err := ioutil.TempDir(filepath.Dir(mirror), filepath.Base(mirror)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create directory for local clone")
+	}
+	defer os.RemoveAll(tmp)
+
+	if _, err = l.git(ctx, "", "clone", "--mirror", "--quiet", l.webURL(owner+"/"+repo)+".git", tmp); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, mirror); err != nil {
+		return errors.Wrap(err, "failed to move local clone into place")
+	}
+	return nil
+}
+
+// readCommits returns the commits of the default branch of a local clone, newest first.
+func (l *localGitProvider) readCommits(ctx context.Context, owner, repo, mirror string, args ...string) ([]*github.RepositoryCommit, error) {
+	out, err := l.git(ctx, mirror, append([]string{"log", "--format=" + localGitLogFormat}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	repoURL := l.webURL(owner + "/" + repo)
+
+	var result []*github.RepositoryCommit
+	for _, record := range strings.Split(string(out), "\x1e") {
+		fields := strings.Split(strings.TrimLeft(record, "\n"), "\x1f")
+		if len(fields) != 8 {
+			continue
+		}
+
+		authorDate, _ := time.Parse(time.RFC3339, fields[3])
+		commitDate, _ := time.Parse(time.RFC3339, fields[6])
+
+		result = append(result, &github.RepositoryCommit{
+			SHA:     github.String(fields[0]),
+			HTMLURL: github.String(repoURL + "/commit/" + fields[0]),
+			Commit: &github.Commit{
+				Message: github.String(strings.TrimSpace(fields[7])),
+				Author: &github.CommitAuthor{
+					Name:  github.String(fields[1]),
+					Email: github.String(fields[2]),
+					Date:  &authorDate,
+				},
+				Committer: &github.CommitAuthor{
+					Name:  github.String(fields[4]),
+					Email: github.String(fields[5]),
+					Date:  &commitDate,
+				},
+			},
+		})
+	}
+	return result, nil
+}
+
+func (l *localGitProvider) fetchLocalCommits(ctx context.Context, owner, repo string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	mirror, err := l.syncMirror(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	commits, err := l.readCommits(ctx, owner, repo, mirror, "--since="+since.Format(time.RFC3339), "--until="+until.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+
+	l.resolveAuthors(ctx, owner, repo, commits)
+	return commits, nil
+}
+
+func getAuthorCacheKey(source string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(source)))
+	return authorCacheKeyPrefix + hex.EncodeToString(hash[:])[:40]
+}
+
+// resolveAuthors sets the author accounts of commits, which are looked up by the email address of the author.
+// Known email addresses are stored in the KV store, so that they only have to be looked up once.
+func (l *localGitProvider) resolveAuthors(ctx context.Context, owner, repo string, commits []*github.RepositoryCommit) {
+	key := getAuthorCacheKey(l.id())
+
+	authors := map[string]cachedAuthor{}
+	data, appErr := l.p.API.KVGet(key)
+	if appErr != nil {
+		l.p.API.LogWarn("Failed to load author cache", "error", appErr.Error())
+	} else if data != nil {
+		if err := json.Unmarshal(data, &authors); err != nil {
+			l.p.API.LogWarn("Failed to decode author cache", "error", err.Error())
+		}
+	}
+
+	// Look up every unknown email address once, using its latest commit
+	unknown := map[string]string{}
+	for _, c := range commits {
+		email := strings.ToLower(c.GetCommit().GetAuthor().GetEmail())
+		author, ok := authors[email]
+		if ok && (author.Login != "" || time.Since(author.CheckedAt) < unresolvedAuthorTTL) {
+			continue
+		}
+		if _, ok := unknown[email]; !ok {
+			unknown[email] = c.GetSHA()
+		}
+	}
+
+	if len(unknown) > 0 {
+		var lock sync.Mutex
+		var wg sync.WaitGroup
+		for email, sha := range unknown {
+			wg.Add(1)
+			go func(email, sha string) {
+				defer wg.Done()
+
+				user, err := l.provider.resolveAuthor(ctx, owner, repo, sha, email)
+				if err != nil {
+					l.p.API.LogWarn("Failed to resolve commit author", "sha", sha, "error", err.Error())
+					return
+				}
+
+				author := cachedAuthor{CheckedAt: time.Now()}
+				if user != nil {
+					author.ID = user.GetID()
+					author.Login = user.GetLogin()
+				}
+
+				lock.Lock()
+				authors[email] = author
+				lock.Unlock()
+			}(email, sha)
+		}
+		wg.Wait()
+
+		data, err := json.Marshal(authors)
+		if err != nil {
+			l.p.API.LogWarn("Failed to encode author cache", "error", err.Error())
+		} else if appErr := l.p.API.KVSet(key, data); appErr != nil {
+			l.p.API.LogWarn("Failed to store author cache", "error", appErr.Error())
+		}
+	}
+
+	for _, c := range commits {
+		author := authors[strings.ToLower(c.GetCommit().GetAuthor().GetEmail())]
+		if author.Login != "" {
+			c.Author = &github.User{
+				ID:    github.Int64(author.ID),
+				Login: github.String(author.Login),
+			}
+		}
+	}
+}
+
+func (l *localGitProvider) fetchCommits(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	if repo != "" {
+		if l.isLocal(owner, repo) {
+			pr := getProgress(ctx)
+			pr.addRepos(1)
+			commits, err := l.fetchLocalCommits(ctx, owner, repo, since, until)
+			pr.repoDone(len(commits), err)
+			return commits, err
+		}
+		return l.provider.fetchCommits(ctx, owner, repo, isOrg, since, until)
+	}
+
+	repos, err := l.listRepositories(ctx, owner, isOrg)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.fetchCommitsFromRepos(ctx, owner, repos, since, until)
+}
+
+func (l *localGitProvider) fetchCommitsFromRepos(ctx context.Context, owner string, repos []string, since, until time.Time) ([]*github.RepositoryCommit, error) {
+	local, remote := l.splitRepos(owner, repos)
+
+	pr := getProgress(ctx)
+	pr.addRepos(len(local))
+
+	var result []*github.RepositoryCommit
+	for _, repo := range local {
+		commits, err := l.fetchLocalCommits(ctx, owner, repo, since, until)
+		pr.repoDone(len(commits), err)
+		if err != nil {
+			l.p.API.LogWarn("Failed to read commits from local clone", "repo", owner+"/"+repo, "error", err.Error())
+			continue
+		}
+		result = append(result, commits...)
+	}
+
+	if len(remote) > 0 {
+		commits, err := l.provider.fetchCommitsFromRepos(ctx, owner, remote, since, until)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, commits...)
+	}
+
+	return result, ctx.Err()
+}
+
+func (l *localGitProvider) fetchCommitStats(ctx context.Context, owner, repo string, shas []string) (map[string]commitStats, error) {
+	if !l.isLocal(owner, repo) {
+		return l.provider.fetchCommitStats(ctx, owner, repo, shas)
+	}
+
+	// The clone was synced when its commits were read
+	mirror, err := l.mirrorPath(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]commitStats{}
+	for start := 0; start < len(shas); start += localGitStatsPerCall {
+		end := start + localGitStatsPerCall
+		if end > len(shas) {
+			end = len(shas)
+		}
+
+		args := append([]string{"log", "--no-walk=unsorted", "--numstat", "--format=%x1e%H"}, shas[start:end]...)
+		out, err := l.git(ctx, mirror, args...)
+		if err != nil {
+			return nil, err
+		}
+		for sha, stats := range parseNumstat(string(out)) {
+			result[sha] = stats
+		}
+	}
+	return result, nil
+}
+
+// parseNumstat parses the output of git log --numstat --format=%x1e%H. Binary files count as changed files without lines.
+func parseNumstat(out string) map[string]commitStats {
+	result := map[string]commitStats{}
+	for _, record := range strings.Split(out, "\x1e") {
+		lines := strings.Split(strings.TrimSpace(record), "\n")
+		if lines[0] == "" {
+			continue
+		}
+
+		var stats commitStats
+		for _, line := range lines[1:] {
+			fields := strings.SplitN(line, "\t", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			additions, _ := strconv.Atoi(fields[0])
+			deletions, _ := strconv.Atoi(fields[1])
+			stats.Additions += additions
+			stats.Deletions += deletions
+			stats.Files++
+		}
+		result[lines[0]] = stats
+	}
+	return result
+}
+
+// firstLocalContributions returns the first commit of every author to the given local clones.
+func (l *localGitProvider) firstLocalContributions(ctx context.Context, org string, repos []string) map[string]firstContributionInfo {
+	pr := getProgress(ctx)
+	pr.addRepos(len(repos))
+
+	result := map[string]firstContributionInfo{}
+	for _, repo := range repos {
+		mirror, err := l.syncMirror(ctx, org, repo)
+		if err != nil {
+			pr.repoDone(0, err)
+			l.p.API.LogWarn("Failed to sync local clone", "repo", org+"/"+repo, "error", err.Error())
+			continue
+		}
+
+		commits, err := l.readCommits(ctx, org, repo, mirror)
+		pr.repoDone(len(commits), err)
+		if err != nil {
+			l.p.API.LogWarn("Failed to read commits from local clone", "repo", org+"/"+repo, "error", err.Error())
+			continue
+		}
+		l.resolveAuthors(ctx, org, repo, commits)
+
+		for _, c := range commits {
+			author := c.GetAuthor()
+			if author == nil {
+				continue
+			}
+			login := author.GetLogin()
+
+			date := commitDate(c)
+			firstContribution, contains := result[login]
+			if !contains || firstContribution.date.After(date) {
+				result[login] = firstContributionInfo{login, date, c.GetHTMLURL(), org, repo}
+			}
+		}
+	}
+	return result
+}
+
+// findFirstContributions combines the complete history of the local clones with the results of the wrapped provider for every other repository.
+func (l *localGitProvider) findFirstContributions(ctx context.Context, org string, repos []string, since time.Time) (map[string]firstContributionInfo, error) {
+	if repos == nil {
+		var err error
+		repos, err = l.listRepositories(ctx, org, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	local, remote := l.splitRepos(org, repos)
+	if len(local) == 0 {
+		return l.provider.findFirstContributions(ctx, org, remote, since)
+	}
+
+	firstLocal := l.firstLocalContributions(ctx, org, local)
+
+	result := map[string]firstContributionInfo{}
+	for login, info := range firstLocal {
+		if !info.date.Before(since) {
+			result[login] = info
+		}
+	}
+	if len(remote) == 0 {
+		return result, nil
+	}
+
+	firstRemote, err := l.provider.findFirstContributions(ctx, org, remote, since)
+	if err != nil {
+		return nil, err
+	}
+
+	var newToLocal []string
+	for login := range result {
+		if _, ok := firstRemote[login]; !ok {
+			newToLocal = append(newToLocal, login)
+		}
+	}
+
+	for login, info := range firstRemote {
+		if localInfo, ok := firstLocal[login]; ok && localInfo.date.Before(info.date) {
+			if localInfo.date.Before(since) {
+				delete(result, login)
+			}
+			continue
+		}
+		result[login] = info
+	}
+
+	// Contributors that are new to the local clones might have contributed to the other repositories earlier
+	earlier, err := l.provider.contributedBefore(ctx, org, remote, newToLocal, since)
+	if err != nil {
+		return nil, err
+	}
+	for login := range earlier {
+		delete(result, login)
+	}
+
+	return result, nil
+}
+
+func (l *localGitProvider) contributedBefore(ctx context.Context, org string, repos, logins []string, before time.Time) (map[string]bool, error) {
+	local, remote := l.splitRepos(org, repos)
+
+	result := map[string]bool{}
+	if len(remote) > 0 {
+		var err error
+		result, err = l.provider.contributedBefore(ctx, org, remote, logins, before)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	firstLocal := l.firstLocalContributions(ctx, org, local)
+	for _, login := range logins {
+		if info, ok := firstLocal[login]; ok && info.date.Before(before) {
+			result[login] = true
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fixtureProvider is the remote of repositories in a fixture directory. Its results for other repositories come from maps.
+type fixtureProvider struct {
+	testProvider
+	dir string
+
+	remoteFirst   map[string]firstContributionInfo
+	remoteEarlier map[string]bool
+}
+
+func (f *fixtureProvider) webURL(path string) string {
+	return filepath.Join(f.dir, path)
+}
+
+func (f *fixtureProvider) resolveAuthor(_ context.Context, _, _, _, email string) (*github.User, error) {
+	return f.usersByEmail[email], nil
+}
+
+func (f *fixtureProvider) findFirstContributions(_ context.Context, _ string, _ []string, _ time.Time) (map[string]firstContributionInfo, error) {
+	return f.remoteFirst, nil
+}
+
+func (f *fixtureProvider) contributedBefore(_ context.Context, _ string, _, logins []string, _ time.Time) (map[string]bool, error) {
+	result := map[string]bool{}
+	for _, login := range logins {
+		if f.remoteEarlier[login] {
+			result[login] = true
+		}
+	}
+	return result, nil
+}
+
+type fixtureCommit struct {
+	email string
+	date  time.Time
+}
+
+// newFixtureRepo creates a repository in dir/owner/repo.git with a commit per entry.
+func newFixtureRepo(t *testing.T, dir, owner, repo string, commits []fixtureCommit) {
+	path := filepath.Join(dir, owner, repo+".git")
+	require.NoError(t, os.MkdirAll(path, 0700))
+
+	run := func(env []string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = path
+		cmd.Env = append(os.Environ(), env...)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+
+	run(nil, "init", "--quiet")
+	for _, c := range commits {
+		date := c.date.Format(time.RFC3339)
+		run([]string{
+			"GIT_AUTHOR_NAME=" + c.email, "GIT_AUTHOR_EMAIL=" + c.email, "GIT_AUTHOR_DATE=" + date,
+			"GIT_COMMITTER_NAME=" + c.email, "GIT_COMMITTER_EMAIL=" + c.email, "GIT_COMMITTER_DATE=" + date,
+		}, "commit", "--quiet", "--allow-empty", "-m", "Commit by "+c.email)
+	}
+}
+
+func newTestLocalGitProvider(t *testing.T, patterns ...string) (*localGitProvider, *fixtureProvider) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+
+	api := &plugintest.API{}
+	api.On("KVGet", mock.Anything).Return(nil, (*model.AppError)(nil)).Maybe()
+	api.On("KVSet", mock.Anything, mock.Anything).Return((*model.AppError)(nil)).Maybe()
+	api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	forge := &fixtureProvider{
+		dir: t.TempDir(),
+		testProvider: testProvider{usersByEmail: map[string]*github.User{
+			"jane@example.com":  {Login: github.String("jane")},
+			"john@example.com":  {Login: github.String("john")},
+			"alice@example.com": {Login: github.String("alice")},
+		}},
+	}
+	return &localGitProvider{
+		provider: forge,
+		p:        p,
+		dir:      t.TempDir(),
+		patterns: patterns,
+	}, forge
+}
+
+func TestLocalGitFetchCommits(t *testing.T) {
+	l, forge := newTestLocalGitProvider(t, "org/server")
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 12, 0, 0, 0, time.UTC)
+	}
+	newFixtureRepo(t, forge.dir, "org", "server", []fixtureCommit{
+		{"jane@example.com", day(1)},
+		{"john@example.com", day(10)},
+		{"unknown@example.com", day(11)},
+		{"jane@example.com", day(20)},
+	})
+
+	commits, err := l.fetchCommits(context.Background(), "org", "server", true, day(5), day(15))
+	require.NoError(t, err)
+	require.Len(t, commits, 2)
+	assert.Equal(t, "unknown@example.com", commits[0].GetCommit().GetAuthor().GetEmail())
+	assert.Nil(t, commits[0].GetAuthor())
+	assert.Equal(t, "john", commits[1].GetAuthor().GetLogin())
+	assert.True(t, day(10).Equal(commitDate(commits[1])))
+	assert.Equal(t, filepath.Join(forge.dir, "org/server")+"/commit/"+commits[1].GetSHA(), commits[1].GetHTMLURL())
+
+	// The existing clone is used, once the remote is gone
+	require.NoError(t, os.RemoveAll(forge.dir))
+	commits, err = l.fetchCommits(context.Background(), "org", "server", true, day(1), day(31))
+	require.NoError(t, err)
+	assert.Len(t, commits, 4)
+}
+
+func TestLocalGitFailedCloneLeavesNoMirror(t *testing.T) {
+	l, _ := newTestLocalGitProvider(t, "org/*")
+
+	_, err := l.syncMirror(context.Background(), "org", "missing")
+	require.Error(t, err)
+
+	mirror, err := l.mirrorPath("org", "missing")
+	require.NoError(t, err)
+	_, err = os.Stat(mirror)
+	assert.True(t, os.IsNotExist(err))
+
+	entries, err := filepath.Glob(filepath.Join(filepath.Dir(mirror), "*"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestParseGitVersion(t *testing.T) {
+	assert.Equal(t, []int{2, 39, 2}, parseGitVersion("git version 2.39.2 (Apple Git-143)\n"))
+	assert.Equal(t, []int{2, 30, 1}, parseGitVersion("git version 2.30.1.windows.1\n"))
+	assert.Nil(t, parseGitVersion("unknown"))
+
+	assert.Equal(t, -1, compareVersions([]int{2, 30, 1}, gitConfigEnvVersion))
+	assert.Equal(t, 0, compareVersions([]int{2, 31, 0}, gitConfigEnvVersion))
+	assert.Equal(t, 1, compareVersions([]int{3}, gitConfigEnvVersion))
+	assert.Equal(t, -1, compareVersions(nil, gitConfigEnvVersion))
+}
+
+func TestLocalGitAuthorizationHeader(t *testing.T) {
+	header := func(credentials string) string {
+		return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	t.Run("configured token", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetPluginStatus", gitHubPluginID).Return(nil, model.NewAppError("GetPluginStatus", "not found", nil, "", http.StatusNotFound))
+		allowLogs(api)
+		p := &Plugin{}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{Token: "token"})
+
+		l := &localGitProvider{provider: &gitHubProvider{p: p}, p: p}
+		assert.Equal(t, header("x-access-token:token"), l.authorizationHeader())
+	})
+
+	t.Run("token of the user from the GitHub plugin", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetPluginStatus", gitHubPluginID).Return(&model.PluginStatus{State: model.PluginStateRunning}, (*model.AppError)(nil))
+		api.On("PluginHTTP", mock.Anything).Return(func(r *http.Request) *http.Response {
+			assert.Equal(t, "user", r.URL.Query().Get("userID"))
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(`{"access_token": "user token"}`))}
+		}).Once()
+		p := &Plugin{}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{Token: "token"})
+
+		l := &localGitProvider{provider: &gitHubProvider{p: p, userID: "user"}, p: p}
+		assert.Equal(t, header("x-access-token:user token"), l.authorizationHeader())
+		// The token is only looked up once
+		assert.Equal(t, header("x-access-token:user token"), l.authorizationHeader())
+	})
+}
+
+func TestLocalGitFindFirstContributions(t *testing.T) {
+	l, forge := newTestLocalGitProvider(t, "org/server")
+	since := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	newFixtureRepo(t, forge.dir, "org", "server", []fixtureCommit{
+		{"jane@example.com", since.AddDate(0, -1, 0)},
+		{"jane@example.com", since.AddDate(0, 0, 1)},
+		{"john@example.com", since.AddDate(0, 0, 2)},
+		{"alice@example.com", since.AddDate(0, 0, 3)},
+	})
+
+	first, err := l.findFirstContributions(context.Background(), "org", []string{"server"}, since)
+	require.NoError(t, err)
+	assert.Len(t, first, 2)
+	assert.True(t, since.AddDate(0, 0, 2).Equal(first["john"].date))
+	assert.Equal(t, "server", first["john"].repo)
+	assert.Contains(t, first, "alice")
+
+	earlier, err := l.contributedBefore(context.Background(), "org", []string{"server"}, []string{"jane", "john"}, since)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"jane": true}, earlier)
+
+	// Newcomers to the clone, who contributed to other repositories earlier, aren't new
+	forge.remoteFirst = map[string]firstContributionInfo{
+		"bob": {author: "bob", date: since.AddDate(0, 0, 5), org: "org", repo: "webapp"},
+	}
+	forge.remoteEarlier = map[string]bool{"alice": true}
+	first, err = l.findFirstContributions(context.Background(), "org", []string{"server", "webapp"}, since)
+	require.NoError(t, err)
+	assert.Len(t, first, 2)
+	assert.Contains(t, first, "john")
+	assert.Contains(t, first, "bob")
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v31/github"
@@ -20,7 +21,11 @@ type firstContributionInfo struct {
 	repo   string
 }
 
-const rateLimitMessage = "Hit rate limit. Please try again later."
+const (
+	rateLimitMessage = "Hit rate limit. Please try again later."
+	cancelledMessage = "The report was cancelled."
+	timeoutMessage   = "The report took too long and was stopped."
+)
 
 func (p *Plugin) executeNewCommitterCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
 	if len(commandArgs) != 2 {
@@ -42,18 +47,12 @@ func (p *Plugin) executeNewCommitterCommand(commandArgs []string, args *model.Co
 		}
 	}
 
-	client, err := p.getGitHubClient(args.UserId)
-	if err != nil {
-		p.API.LogWarn("Failed to create GitHub client", "error", err.Error())
-
-		return &model.AppError{
-			Id:         "Failed to connect to GitHub.",
-			StatusCode: http.StatusBadRequest,
-			Where:      "p.ExecuteCommand",
-		}
+	forge, organization, appErr := p.getProvider(args.UserId, organization)
+	if appErr != nil {
+		return appErr
 	}
 
-	org, _, err := client.Organizations.Get(context.Background(), organization)
+	avatarLogo, err := forge.avatarURL(context.Background(), organization, true)
 	if err != nil {
 		return &model.AppError{
 			Id:         "Failed to fetch data",
@@ -66,53 +65,72 @@ func (p *Plugin) executeNewCommitterCommand(commandArgs []string, args *model.Co
 		Title:      "Fetching new committers since " + since.Format(shortFormWithDay),
 		Text:       waitText,
 		AuthorName: organization,
-		AuthorIcon: org.GetAvatarURL(),
-		AuthorLink: fmt.Sprintf("https://github.com/%v", organization),
+		AuthorIcon: avatarLogo,
+		AuthorLink: forge.webURL(organization),
 	}}
 
-	loadingPost := &model.Post{
-		ChannelId: args.ChannelId,
-		UserId:    p.botUserID,
-	}
-	model.ParseSlackAttachment(loadingPost, attachments)
-
-	loadingPost, appErr := p.API.CreatePost(loadingPost)
-	if appErr != nil {
-		return appErr
-	}
-
-	go p.updateNewCommittersPost(client, loadingPost, args.UserId, organization, since)
-
-	return nil
+	return p.startJob(args, &job{
+		Type:   jobTypeNewCommitter,
+		Target: commandArgs[0],
+		IsOrg:  true,
+		Since:  since,
+	}, attachments)
 }
 
-func (p *Plugin) updateNewCommittersPost(client *github.Client, post *model.Post, userID, org string, since time.Time) {
-	contributors, err := p.fetchContributors(client, org)
-	if err != nil {
-		p.logAndPropUserAboutError(post, userID, err)
-		return
+func (p *Plugin) updateNewCommittersPost(ctx context.Context, forge provider, post *model.Post, userID, org string, since time.Time) error {
+	firstContributions, err := forge.findFirstContributions(ctx, org, nil, since)
+	var staff map[string]bool
+	var coAuthors []firstContributionInfo
+	if err == nil {
+		staff = p.fetchStaffForReport(ctx, forge, org, true)
 	}
-
-	firstContributions, err := p.findFirstContributions(client, contributors, org, since)
+	if err == nil && p.getCoAuthorsMode() != coAuthorsIgnore {
+		// Co-authors aren't contributors of repositories, so they are found in the commit messages
+		var commits, coAuthored []*github.RepositoryCommit
+		commits, err = forge.fetchCommits(ctx, org, "", true, since, time.Now())
+		if err == nil {
+			coAuthored, err = p.fetchCoAuthoredCommits(ctx, forge, commits)
+		}
+		if err == nil {
+			coAuthors, err = p.findFirstCoAuthorships(ctx, forge, org, since, coAuthored, firstContributions)
+		}
+	}
+	err = finishFetching(ctx, err)
 	if err != nil {
 		p.logAndPropUserAboutError(post, userID, err)
-		return
+		return err
 	}
 
 	var result []firstContributionInfo
 	for _, contribution := range firstContributions {
 		result = append(result, contribution)
 	}
+	credited := p.getCoAuthorsMode() == coAuthorsCredit
+	if credited {
+		result = append(result, coAuthors...)
+	}
+	bots := p.getBotFilter()
+	result, filtered := bots.filterFirstContributions(result)
+	coAuthors, _ = bots.filterFirstContributions(coAuthors)
 	sort.Slice(result, func(i, j int) bool {
 		return result[i].date.Before(result[j].date)
 	})
 
-	p.updatePostContent(post, result, since)
+	p.updatePostContent(post, result, since, staff, filtered)
+	if !credited && len(coAuthors) > 0 {
+		attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: "New co-authors",
+			Value: formatFirstContributions(forge, coAuthors),
+		})
+	}
 	p.updatePost(post, userID)
-	p.createContributorsPost(post.ChannelId, userID, result)
+	p.createContributorsPost(forge, post.ChannelId, userID, result, staff)
+
+	return nil
 }
 
-func (p *Plugin) findFirstContributions(client *github.Client, contributors map[string][]*github.Contributor, org string, since time.Time) (map[string]firstContributionInfo, error) {
+func (p *Plugin) findFirstContributions(ctx context.Context, client *github.Client, contributors map[string][]*github.Contributor, org string, since time.Time) (map[string]firstContributionInfo, error) {
 	firstContributions := map[string]firstContributionInfo{}
 	earlierContributors := map[string]bool{}
 
@@ -125,7 +143,7 @@ func (p *Plugin) findFirstContributions(client *github.Client, contributors map[
 				continue
 			}
 
-			commits, err := p.fetchCommitsFromRepoByAuthor(client, org, repo, author)
+			commits, err := p.fetchCommitsFromRepoByAuthor(ctx, client, org, repo, author)
 			if err != nil {
 				return nil, err
 			}
@@ -157,18 +175,95 @@ func (p *Plugin) findFirstContributions(client *github.Client, contributors map[
 	return firstContributions, nil
 }
 
+// findFirstCoAuthorships finds the co-authors of commits since the given time, who haven't committed to the organization before.
+// coAuthored are the co-authored commits of the organization since then, as returned by fetchCoAuthoredCommits.
+// Contributors that are already known aren't checked again. Earlier co-authorships aren't considered.
+func (p *Plugin) findFirstCoAuthorships(ctx context.Context, forge provider, org string, since time.Time, coAuthored []*github.RepositoryCommit, known map[string]firstContributionInfo) ([]firstContributionInfo, error) {
+	first := map[string]firstContributionInfo{}
+	for _, c := range p.resolveIdentities(coAuthored) {
+		login := c.GetAuthor().GetLogin()
+		if _, ok := known[login]; ok || login == "" {
+			continue
+		}
+		if e, ok := first[login]; ok && !commitDate(c).Before(e.date) {
+			continue
+		}
+		first[login] = firstContributionInfo{
+			author: login,
+			date:   commitDate(c),
+			commit: c.GetHTMLURL(),
+			org:    org,
+			repo:   repoFromCommitURL(forge, org, c.GetHTMLURL()),
+		}
+	}
+	if len(first) == 0 {
+		return nil, nil
+	}
+
+	repos, err := forge.listRepositories(ctx, org, true)
+	if err != nil {
+		return nil, err
+	}
+	var logins []string
+	for login := range first {
+		logins = append(logins, login)
+	}
+	earlier, err := forge.contributedBefore(ctx, org, repos, logins, since)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []firstContributionInfo
+	for login, e := range first {
+		if !earlier[login] {
+			result = append(result, e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].date.Before(result[j].date)
+	})
+	return result, nil
+}
+
+// repoFromCommitURL returns the name of the repository of a commit of an organization from the link to the commit.
+func repoFromCommitURL(forge forgeInfo, org, commitURL string) string {
+	prefix := forge.webURL(org + "/")
+	if len(commitURL) <= len(prefix) || !strings.EqualFold(commitURL[:len(prefix)], prefix) {
+		return ""
+	}
+	repo := commitURL[len(prefix):]
+	// GitLab links commits of projects in subgroups as [group]/[subgroup]/[project]/-/commit/[sha]
+	if i := strings.Index(repo, "/-/"); i >= 0 {
+		return repo[:i]
+	}
+	if i := strings.Index(repo, "/"); i >= 0 {
+		repo = repo[:i]
+	}
+	return repo
+}
+
 func (p *Plugin) logAndPropUserAboutError(post *model.Post, userID string, err error) {
 	p.API.LogError("failed to fetch data", "err", err.Error())
 
-	var message = "Failed to fetch data:" + err.Error()
-	if _, ok := err.(*github.RateLimitError); ok {
-		message = rateLimitMessage
-	}
+	message := githubErrorHandle(err)
 	post.Props["attachments"].([]*model.SlackAttachment)[0].Text = message
 	p.updatePost(post, userID)
 }
 
-func (p *Plugin) updatePostContent(post *model.Post, result []firstContributionInfo, since time.Time) {
+// splitFirstContributions splits first contributions into the ones by staff and by the community.
+func splitFirstContributions(result []firstContributionInfo, staff map[string]bool) (staffResult, communityResult []firstContributionInfo) {
+	for _, e := range result {
+		if staff[strings.ToLower(e.author)] {
+			staffResult = append(staffResult, e)
+		} else {
+			communityResult = append(communityResult, e)
+		}
+	}
+	return staffResult, communityResult
+}
+
+// updatePostContent sets the number of new committers. If staff is known, the share of the community is added.
+func (p *Plugin) updatePostContent(post *model.Post, result []firstContributionInfo, since time.Time, staff map[string]bool, filtered filteredBots) {
 	attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
 	attachment.Title = "New Committers since " + since.Format(shortFormWithDay)
 	attachment.Text = ""
@@ -176,6 +271,15 @@ func (p *Plugin) updatePostContent(post *model.Post, result []firstContributionI
 		Title: "Number of new committers:",
 		Value: strconv.Itoa(len(result)),
 	}}
+
+	if staff != nil {
+		_, communityResult := splitFirstContributions(result, staff)
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: "External contributors",
+			Value: formatRatio(len(communityResult), len(result), "new committers"),
+		})
+	}
+	attachment.Fields = append(attachment.Fields, filtered.field("")...)
 }
 
 func (p *Plugin) updatePost(post *model.Post, userID string) {
@@ -185,16 +289,17 @@ func (p *Plugin) updatePost(post *model.Post, userID string) {
 	}
 }
 
-func (p *Plugin) createContributorsPost(channelID, userID string, result []firstContributionInfo) {
-	var resultText string
-	for _, e := range result {
-		resultText += fmt.Sprintf("- [%[1]s](https://github.com/%[1]v): [first commit](%s) at %s on [%[4]s](https://github.com/%s/%[4]v)\n", e.author, e.commit, e.date.Format(shortFormWithDay), e.repo, e.org)
+func (p *Plugin) createContributorsPost(forge forgeInfo, channelID, userID string, result []firstContributionInfo, staff map[string]bool) {
+	message := formatFirstContributions(forge, result)
+	if staff != nil {
+		staffResult, communityResult := splitFirstContributions(result, staff)
+		message = "#### Community\n" + formatFirstContributions(forge, communityResult) + "\n#### Staff\n" + formatFirstContributions(forge, staffResult)
 	}
 
 	committersPost := &model.Post{
 		ChannelId: channelID,
 		UserId:    p.botUserID,
-		Message:   resultText,
+		Message:   message,
 	}
 
 	if _, appErr := p.API.CreatePost(committersPost); appErr != nil {
@@ -202,3 +307,11 @@ func (p *Plugin) createContributorsPost(channelID, userID string, result []first
 		p.API.LogError("failed to create post", "err", appErr.Error())
 	}
 }
+
+func formatFirstContributions(forge forgeInfo, result []firstContributionInfo) string {
+	var resultText string
+	for _, e := range result {
+		resultText += fmt.Sprintf("- [%s](%s): [first commit](%s) at %s on [%s](%s)\n", e.author, forge.webURL(e.author), e.commit, e.date.Format(shortFormWithDay), e.repo, forge.webURL(e.org+"/"+e.repo))
+	}
+	return resultText
+}
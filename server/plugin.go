@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"sync"
+	"time"
 
+	"github.com/mattermost/mattermost-plugin-api/cluster"
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
 	"github.com/pkg/errors"
@@ -27,12 +30,35 @@ type Plugin struct {
 
 	// botUserID is the ID of the community bot user
 	botUserID string
+
+	// scheduler limits and paces the requests of all GitHub fetchers.
+	scheduler *scheduler
+
+	// mirrorLocks holds a *sync.Mutex per local clone, so that a clone is only synced by one report at a time.
+	mirrorLocks sync.Map
+
+	// gitVersionOnce checks the installed git version once, gitConfigEnv is set if it reads configuration from the environment.
+	gitVersionOnce sync.Once
+	gitConfigEnv   bool
+
+	// jobsLock synchronizes access to jobCancels.
+	jobsLock sync.Mutex
+
+	// jobCancels holds the functions to cancel the jobs running on this node by their ID.
+	jobCancels map[string]context.CancelFunc
+
+	// schedulerJob runs due schedules on one node of the cluster.
+	schedulerJob *cluster.Job
+
+	// resumeJob resumes the jobs of nodes that went away on one node of the cluster.
+	resumeJob *cluster.Job
 }
 
 var _ = manifest // Fix unused linter error
 
 // OnActivate creates a github client with the access token from the configurations,
-// creates a bot account, if it doesn't exist and registers the /community slash command
+// creates a bot account, if it doesn't exist, registers the /community slash command, resumes unfinished jobs
+// and starts running schedules, digests and subscriptions
 func (p *Plugin) OnActivate() error {
 	bot := &model.Bot{
 		Username:    botUsername,
@@ -50,6 +76,39 @@ func (p *Plugin) OnActivate() error {
 		return errors.Wrap(err, "failed to register new command")
 	}
 
+	go p.resumeJobs()
+
+	job, err := cluster.Schedule(p.API, schedulerJobKey, cluster.MakeWaitForInterval(time.Minute), func() {
+		p.runSchedules()
+		p.runDigests()
+		p.pollSubscriptions()
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule reports")
+	}
+	p.schedulerJob = job
+
+	resumeJob, err := cluster.Schedule(p.API, resumeJobsKey, cluster.MakeWaitForInterval(resumeJobsInterval), p.resumeJobs)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule resuming jobs")
+	}
+	p.resumeJob = resumeJob
+
+	return nil
+}
+
+// OnDeactivate stops running schedules, digests and subscriptions, and resuming jobs
+func (p *Plugin) OnDeactivate() error {
+	if p.schedulerJob != nil {
+		if err := p.schedulerJob.Close(); err != nil {
+			return errors.Wrap(err, "failed to stop running schedules")
+		}
+	}
+	if p.resumeJob != nil {
+		if err := p.resumeJob.Close(); err != nil {
+			return errors.Wrap(err, "failed to stop resuming jobs")
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/mattermost/mattermost-plugin-community/server/util"
+)
+
+// maxListedProfileRepos is the number of repositories listed on a contributor profile.
+const maxListedProfileRepos = 20
+
+// contributorProfile sums up what a user contributed to the repositories of an owner.
+type contributorProfile struct {
+	login     string
+	name      string
+	avatarURL string
+
+	// repos maps the repositories the user committed to, to their number of commits.
+	repos        map[string]int
+	commits      int
+	pullRequests int
+
+	firstContribution *firstContributionInfo
+
+	lastActivity     time.Time
+	lastActivityURL  string
+	lastActivityKind string
+}
+
+func newContributorProfile(login, name, avatarURL string) *contributorProfile {
+	return &contributorProfile{
+		login:     login,
+		name:      name,
+		avatarURL: avatarURL,
+		repos:     map[string]int{},
+	}
+}
+
+// addCommit records a commit of the user to a repository of org, if it's their first or latest contribution known so far.
+func (c *contributorProfile) addCommit(org, repo string, commit *github.RepositoryCommit) {
+	date := commitDate(commit)
+	if c.firstContribution == nil || date.Before(c.firstContribution.date) {
+		c.firstContribution = &firstContributionInfo{c.login, date, commit.GetHTMLURL(), org, repo}
+	}
+	c.addActivity(date, commit.GetHTMLURL(), "Commit")
+}
+
+// addActivity records an activity of the user, if it's their latest one known so far.
+func (c *contributorProfile) addActivity(date time.Time, url, kind string) {
+	if date.After(c.lastActivity) {
+		c.lastActivity = date
+		c.lastActivityURL = url
+		c.lastActivityKind = kind
+	}
+}
+
+// executeContributorCommand handles /community contributor [login] [organization or user].
+// If the organization is omitted, the default organization from the settings is used.
+func (p *Plugin) executeContributorCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	if (len(commandArgs) != 1 && len(commandArgs) != 2) || strings.TrimPrefix(commandArgs[0], "@") == "" {
+		return &model.AppError{
+			Id:         "Usage: /community contributor [login] [organization]",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	login := strings.TrimPrefix(commandArgs[0], "@")
+	target := p.getConfiguration().DefaultOrganization
+	if len(commandArgs) == 2 {
+		target = commandArgs[1]
+	}
+	if target == "" {
+		return &model.AppError{
+			Id:         "Need an organization, as no default organization is configured",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	forge, owner, appErr := p.getProvider(args.UserId, target)
+	if appErr != nil {
+		return appErr
+	}
+
+	isOrg, err := forge.verifyOwner(context.Background(), owner)
+	if err != nil {
+		return &model.AppError{
+			Id:         "Failed to fetch data",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	avatarLogo, err := forge.avatarURL(context.Background(), owner, isOrg)
+	if err != nil {
+		avatarLogo = ""
+		p.API.LogError(err.Error())
+	}
+
+	attachments := []*model.SlackAttachment{{
+		Title:      fmt.Sprintf("Fetching the contributions of %v", login),
+		Text:       waitText,
+		AuthorName: owner,
+		AuthorIcon: avatarLogo,
+		AuthorLink: forge.webURL(owner),
+	}}
+
+	return p.startJob(args, &job{
+		Type:   jobTypeContributor,
+		Target: target,
+		IsOrg:  isOrg,
+		Login:  login,
+	}, attachments)
+}
+
+func (p *Plugin) updateContributorPost(ctx context.Context, forge provider, post *model.Post, userID, owner string, isOrg bool, login string) error {
+	profile, err := forge.fetchContributorProfile(ctx, owner, isOrg, login)
+	var staff map[string]bool
+	if err == nil {
+		staff = p.fetchStaffForReport(ctx, forge, owner, isOrg)
+	}
+	err = finishFetching(ctx, err)
+	if err != nil {
+		p.logAndPropUserAboutError(post, userID, err)
+		return err
+	}
+
+	attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
+	attachment.AuthorName = profile.login
+	attachment.AuthorIcon = profile.avatarURL
+	attachment.AuthorLink = forge.webURL(profile.login)
+	attachment.ThumbURL = profile.avatarURL
+	attachment.Title = "Contributions to " + owner
+	attachment.TitleLink = forge.webURL(owner)
+	attachment.Fields = formatContributorProfile(forge, owner, profile, staff)
+	attachment.Text = ""
+	if profile.commits == 0 && profile.pullRequests == 0 {
+		attachment.Text = fmt.Sprintf("%v hasn't contributed to %v yet.", profile.login, owner)
+	}
+
+	p.updatePost(post, userID)
+	return nil
+}
+
+// formatContributorProfile renders a profile as attachment fields. Staff is nil, if it couldn't be fetched.
+func formatContributorProfile(forge forgeInfo, owner string, profile *contributorProfile, staff map[string]bool) []*model.SlackAttachmentField {
+	var fields []*model.SlackAttachmentField
+	if profile.name != "" {
+		fields = append(fields, &model.SlackAttachmentField{
+			Title: "Name",
+			Value: profile.name,
+			Short: true,
+		})
+	}
+	if staff != nil {
+		affiliation := "Community"
+		if staff[strings.ToLower(profile.login)] {
+			affiliation = "Staff"
+		}
+		fields = append(fields, &model.SlackAttachmentField{
+			Title: "Affiliation",
+			Value: affiliation,
+			Short: true,
+		})
+	}
+
+	fields = append(fields, &model.SlackAttachmentField{
+		Title: "Commits",
+		Value: util.FormatCount(profile.commits),
+		Short: true,
+	}, &model.SlackAttachmentField{
+		Title: "Pull requests",
+		Value: util.FormatCount(profile.pullRequests),
+		Short: true,
+	})
+
+	if first := profile.firstContribution; first != nil {
+		fields = append(fields, &model.SlackAttachmentField{
+			Title: "First contribution",
+			Value: fmt.Sprintf("[First commit](%s) at %s on [%s](%s)", first.commit, first.date.Format(shortFormWithDay), first.repo, forge.webURL(first.org+"/"+first.repo)),
+		})
+	}
+	if !profile.lastActivity.IsZero() {
+		fields = append(fields, &model.SlackAttachmentField{
+			Title: "Last activity",
+			Value: fmt.Sprintf("[%s](%s) at %s", profile.lastActivityKind, profile.lastActivityURL, profile.lastActivity.Format(shortFormWithDay)),
+		})
+	}
+
+	if len(profile.repos) > 0 {
+		var repos []string
+		for repo := range profile.repos {
+			repos = append(repos, repo)
+		}
+		sort.Slice(repos, func(i, j int) bool {
+			if profile.repos[repos[i]] != profile.repos[repos[j]] {
+				return profile.repos[repos[i]] > profile.repos[repos[j]]
+			}
+			return repos[i] < repos[j]
+		})
+
+		var text string
+		for i, repo := range repos {
+			if i == maxListedProfileRepos {
+				text += fmt.Sprintf("- and %v more\n", len(repos)-i)
+				break
+			}
+			c := "commits"
+			if profile.repos[repo] == 1 {
+				c = "commit"
+			}
+			text += fmt.Sprintf("- [%s](%s): %v %v\n", repo, forge.webURL(owner+"/"+repo), util.FormatCount(profile.repos[repo]), c)
+		}
+		fields = append(fields, &model.SlackAttachmentField{
+			Title: fmt.Sprintf("Repositories (%v)", len(repos)),
+			Value: text,
+		})
+	}
+	return fields
+}
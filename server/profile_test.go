@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContributorProfile(t *testing.T) {
+	commit := func(sha string, date time.Time) *github.RepositoryCommit {
+		return &github.RepositoryCommit{
+			SHA:     github.String(sha),
+			HTMLURL: github.String("https://forge.example.com/org/server/commit/" + sha),
+			Commit:  &github.Commit{Committer: &github.CommitAuthor{Date: &date}},
+		}
+	}
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	profile := newContributorProfile("jane", "Jane Doe", "https://forge.example.com/avatar.png")
+	profile.addCommit("org", "server", commit("b", day(10)))
+	profile.addCommit("org", "webapp", commit("a", day(2)))
+	profile.addCommit("org", "server", commit("c", day(20)))
+	profile.addActivity(day(15), "https://forge.example.com/org/server/pull/1", "Pull request")
+	profile.repos = map[string]int{"server": 2, "webapp": 1}
+	profile.commits = 3
+	profile.pullRequests = 1
+
+	require.NotNil(t, profile.firstContribution)
+	assert.Equal(t, firstContributionInfo{"jane", day(2), "https://forge.example.com/org/server/commit/a", "org", "webapp"}, *profile.firstContribution)
+	assert.Equal(t, day(20), profile.lastActivity)
+	assert.Equal(t, "Commit", profile.lastActivityKind)
+
+	fields := formatContributorProfile(&testProvider{}, "org", profile, map[string]bool{"jane": true})
+	var titles []string
+	for _, field := range fields {
+		titles = append(titles, field.Title)
+	}
+	assert.Equal(t, []string{"Name", "Affiliation", "Commits", "Pull requests", "First contribution", "Last activity", "Repositories (2)"}, titles)
+	assert.Equal(t, "Staff", fields[1].Value)
+	assert.Equal(t, "- [server](https://forge.example.com/org/server): 2 commits\n- [webapp](https://forge.example.com/org/webapp): 1 commit\n", fields[6].Value)
+
+	// Without staff, the affiliation is left out
+	fields = formatContributorProfile(&testProvider{}, "org", newContributorProfile("john", "", ""), nil)
+	require.Len(t, fields, 2)
+	assert.Equal(t, "Commits", fields[0].Title)
+}
+
+func TestExecuteContributorCommandNeedsOrganization(t *testing.T) {
+	p := &Plugin{}
+	p.SetAPI(&plugintest.API{})
+
+	appErr := p.executeContributorCommand([]string{"jane"}, &model.CommandArgs{UserId: "user", ChannelId: "channel"})
+	require.NotNil(t, appErr)
+	assert.Equal(t, 400, appErr.StatusCode)
+
+	appErr = p.executeContributorCommand([]string{""}, &model.CommandArgs{UserId: "user", ChannelId: "channel"})
+	require.NotNil(t, appErr)
+	assert.Equal(t, 400, appErr.StatusCode)
+}
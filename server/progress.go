@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/mattermost/mattermost-plugin-community/server/util"
+)
+
+// progressUpdateInterval is the minimum time between two updates of a loading post. Tests shorten it.
+var progressUpdateInterval = 5 * time.Second
+
+type progressKey struct{}
+
+// progress counts the repositories and commits a report fetched so far.
+// Fetchers find it in their context. All methods are no-ops on a nil progress, so fetchers don't have to check for one.
+type progress struct {
+	lock         sync.Mutex
+	totalRepos   int
+	scannedRepos int
+	failedRepos  int
+	commits      int
+	changed      bool
+
+	// postLock makes sure that the loading post isn't updated anymore, once finish returns.
+	postLock sync.Mutex
+	finished bool
+	done     chan struct{}
+}
+
+func getProgress(ctx context.Context) *progress {
+	pr, _ := ctx.Value(progressKey{}).(*progress)
+	return pr
+}
+
+// addRepos adds repositories that are going to be scanned.
+func (pr *progress) addRepos(n int) {
+	if pr == nil {
+		return
+	}
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	pr.totalRepos += n
+	pr.changed = true
+}
+
+// repoDone records a scanned repository. Repositories that failed to be scanned are counted separately.
+func (pr *progress) repoDone(commits int, err error) {
+	if pr == nil {
+		return
+	}
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	pr.scannedRepos++
+	pr.commits += commits
+	if err != nil {
+		pr.failedRepos++
+	}
+	pr.changed = true
+}
+
+// String renders the progress, e.g. "Scanned 34/120 repositories, 5,210 commits, 2 repositories failed".
+func (pr *progress) String() string {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	text := fmt.Sprintf("Scanned %v/%v repositories", util.FormatCount(pr.scannedRepos), util.FormatCount(pr.totalRepos))
+	if pr.commits > 0 {
+		text += fmt.Sprintf(", %v commits", util.FormatCount(pr.commits))
+	}
+	if pr.failedRepos == 1 {
+		text += ", 1 repository failed"
+	} else if pr.failedRepos > 1 {
+		text += fmt.Sprintf(", %v repositories failed", util.FormatCount(pr.failedRepos))
+	}
+	return text
+}
+
+// finish stops the updates of the loading post. Reports call it before they render their results.
+func (pr *progress) finish() {
+	if pr == nil {
+		return
+	}
+	pr.postLock.Lock()
+	defer pr.postLock.Unlock()
+
+	if !pr.finished {
+		pr.finished = true
+		close(pr.done)
+	}
+}
+
+// finishFetching finishes the progress of a report before it renders its results. If fetching didn't fail, but ctx is done,
+// e.g. because a step that skips what fails was interrupted, the error of ctx is returned instead,
+// so that cancelled and timed out reports aren't rendered from partial results.
+func finishFetching(ctx context.Context, err error) error {
+	getProgress(ctx).finish()
+	if err == nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// trackProgress returns a context that carries a new progress. Until it's finished,
+// the progress is periodically rendered into the loading post of the report and passed to onUpdate.
+func (p *Plugin) trackProgress(ctx context.Context, post *model.Post, onUpdate func(progress string)) context.Context {
+	pr := &progress{
+		done: make(chan struct{}),
+	}
+	// Work on a copy, as the report renders its results into post concurrently
+	progressPost := &model.Post{
+		Id:        post.Id,
+		ChannelId: post.ChannelId,
+		UserId:    post.UserId,
+		Message:   post.Message,
+	}
+	var attachments []*model.SlackAttachment
+	for _, attachment := range post.Attachments() {
+		attachmentCopy := *attachment
+		attachments = append(attachments, &attachmentCopy)
+	}
+	if len(attachments) > 0 {
+		model.ParseSlackAttachment(progressPost, attachments)
+		go p.updateProgressPost(pr, progressPost, progressUpdateInterval, onUpdate)
+	}
+
+	return context.WithValue(ctx, progressKey{}, pr)
+}
+
+func (p *Plugin) updateProgressPost(pr *progress, progressPost *model.Post, interval time.Duration, onUpdate func(progress string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-pr.done:
+			return
+		}
+
+		pr.lock.Lock()
+		changed := pr.changed
+		pr.changed = false
+		pr.lock.Unlock()
+		if !changed {
+			continue
+		}
+
+		pr.postLock.Lock()
+		if pr.finished {
+			pr.postLock.Unlock()
+			return
+		}
+		text := pr.String()
+		onUpdate(text)
+
+		progressPost.Attachments()[0].Text = waitText + "\n" + text
+		if _, appErr := p.API.UpdatePost(progressPost); appErr != nil {
+			p.API.LogWarn("Failed to update progress", "post", progressPost.Id, "error", appErr.Error())
+		}
+		pr.postLock.Unlock()
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestProgressString(t *testing.T) {
+	pr := &progress{}
+	pr.addRepos(120)
+	assert.Equal(t, "Scanned 0/120 repositories", pr.String())
+
+	pr.repoDone(5000, nil)
+	pr.repoDone(210, errors.New("not found"))
+	assert.Equal(t, "Scanned 2/120 repositories, 5,210 commits, 1 repository failed", pr.String())
+
+	pr.repoDone(0, errors.New("not found"))
+	assert.Equal(t, "Scanned 3/120 repositories, 5,210 commits, 2 repositories failed", pr.String())
+
+	// Fetchers don't have to check for a progress
+	var none *progress
+	none.addRepos(1)
+	none.repoDone(1, nil)
+	none.finish()
+	assert.Nil(t, getProgress(context.Background()))
+}
+
+func TestTrackProgress(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	post := &model.Post{Id: "post", ChannelId: "channel"}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{Text: waitText}})
+
+	var updates []string
+	ctx := p.trackProgress(context.Background(), post, func(progress string) {
+		updates = append(updates, progress)
+	})
+	pr := getProgress(ctx)
+
+	// Changes are collected, instead of updating the post for every one of them
+	pr.addRepos(10)
+	for i := 0; i < 10; i++ {
+		pr.repoDone(1, nil)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Once finished, the post isn't updated anymore, so that the results of the report aren't overwritten
+	pr.finish()
+	pr.finish()
+	time.Sleep(100 * time.Millisecond)
+
+	api.AssertNotCalled(t, "UpdatePost", post)
+	assert.Empty(t, updates)
+	assert.Equal(t, waitText, post.Attachments()[0].Text)
+}
+
+func TestTrackProgressUpdatesPost(t *testing.T) {
+	interval := progressUpdateInterval
+	progressUpdateInterval = 20 * time.Millisecond
+	defer func() {
+		progressUpdateInterval = interval
+	}()
+
+	api := &plugintest.API{}
+	updated := make(chan string, 10)
+	api.On("UpdatePost", mock.Anything).Run(func(args mock.Arguments) {
+		updated <- args.Get(0).(*model.Post).Attachments()[0].Text
+	}).Return(nil, (*model.AppError)(nil))
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	post := &model.Post{Id: "post", ChannelId: "channel"}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{Text: waitText}})
+
+	var lock sync.Mutex
+	var updates []string
+	ctx := p.trackProgress(context.Background(), post, func(progress string) {
+		lock.Lock()
+		updates = append(updates, progress)
+		lock.Unlock()
+	})
+	pr := getProgress(ctx)
+
+	pr.addRepos(2)
+	pr.repoDone(3, nil)
+	pr.repoDone(4, nil)
+	for text := ""; text != waitText+"\nScanned 2/2 repositories, 7 commits"; {
+		select {
+		case text = <-updated:
+		case <-time.After(5 * time.Second):
+			t.Fatal("post wasn't updated")
+		}
+	}
+
+	// Without changes, the post isn't updated again
+	time.Sleep(5 * progressUpdateInterval)
+	pr.finish()
+	assert.Empty(t, updated)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, "Scanned 2/2 repositories, 7 commits", updates[len(updates)-1])
+	// The report renders into its own post, which the updates don't touch
+	assert.Equal(t, waitText, post.Attachments()[0].Text)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// provider is a code forge that hosts the repositories reports are created for.
+// Providers return commits as go-github types, which are the common data model of all reports.
+// Code that only needs some capabilities of a forge takes the smaller interface of them.
+type provider interface {
+	forgeInfo
+	ownerFetcher
+	commitFetcher
+	activityFetcher
+	contributorFetcher
+	memberFetcher
+}
+
+// forgeInfo identifies a forge and links to it.
+type forgeInfo interface {
+	// id identifies the forge, e.g. to keep the cached commits of different forges apart.
+	id() string
+	// webURL returns the link to an owner, a repository or a user on the forge.
+	webURL(path string) string
+}
+
+// ownerFetcher fetches the organizations and users that own repositories.
+type ownerFetcher interface {
+	// verifyOwner checks if owner exists and if it's an organization or a user.
+	verifyOwner(ctx context.Context, owner string) (bool, error)
+	avatarURL(ctx context.Context, owner string, isOrg bool) (string, error)
+
+	// listRepositories returns the names of all repositories of an owner.
+	listRepositories(ctx context.Context, owner string, isOrg bool) ([]string, error)
+}
+
+// commitFetcher fetches the commits of repositories.
+type commitFetcher interface {
+	// fetchCommits returns the commits of repo between since and until.
+	// If repo is empty, the commits of all repositories of the owner are returned.
+	fetchCommits(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]*github.RepositoryCommit, error)
+	// fetchCommitsFromRepos returns the commits of the given repositories between since and until.
+	// Repositories that fail to be fetched are skipped.
+	fetchCommitsFromRepos(ctx context.Context, owner string, repos []string, since, until time.Time) ([]*github.RepositoryCommit, error)
+	// fetchCommitStats returns the lines added and deleted, and the number of files changed, by the given commits of repo.
+	// Commits whose stats fail to be fetched are left out.
+	fetchCommitStats(ctx context.Context, owner, repo string, shas []string) (map[string]commitStats, error)
+}
+
+// activityFetcher fetches the pull requests and issues of repositories, and what happened on them.
+type activityFetcher interface {
+	// fetchPullRequests returns the pull requests of repo that were opened, merged or closed between since and until.
+	// If repo is empty, the pull requests of all repositories of the owner are returned.
+	fetchPullRequests(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]*github.PullRequest, error)
+
+	// fetchIssues returns the issues of repo that were opened or closed between since and until. Pull requests aren't included.
+	// If repo is empty, the issues of all repositories of the owner are returned.
+	fetchIssues(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]*github.Issue, error)
+
+	// fetchReviews returns the reviews and review comments on pull requests of repo between since and until,
+	// except the ones by the authors of the pull requests. If repo is empty, all repositories of the owner are included.
+	fetchReviews(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]reviewActivity, error)
+
+	// fetchFirstResponses returns the pull requests and issues of repo that were opened between since and until,
+	// with the time of their first response by someone other than the author. If repo is empty, all repositories of the owner are included.
+	fetchFirstResponses(ctx context.Context, owner, repo string, isOrg bool, since, until time.Time) ([]contribution, error)
+}
+
+// contributorFetcher finds contributors and what they contributed.
+type contributorFetcher interface {
+	// findFirstContributions finds the contributors to the given repositories of an organization whose first commit is after since.
+	// If repos is nil, every repository of the organization is checked.
+	findFirstContributions(ctx context.Context, org string, repos []string, since time.Time) (map[string]firstContributionInfo, error)
+	// contributedBefore returns the users that committed to one of the given repositories before the given time.
+	contributedBefore(ctx context.Context, org string, repos, logins []string, before time.Time) (map[string]bool, error)
+	// resolveAuthor returns the user account of the author of a commit, or nil if it isn't linked to one.
+	resolveAuthor(ctx context.Context, owner, repo, sha, email string) (*github.User, error)
+
+	// fetchContributorProfile returns what a user contributed to the repositories of an owner.
+	fetchContributorProfile(ctx context.Context, owner string, isOrg bool, login string) (*contributorProfile, error)
+
+	// lookupUserByEmail returns the user with the given email address, or nil if none is found.
+	lookupUserByEmail(ctx context.Context, email string) (*github.User, error)
+}
+
+// memberFetcher fetches who belongs to an organization.
+type memberFetcher interface {
+	// fetchMembers returns the logins of the members of an organization.
+	fetchMembers(ctx context.Context, org string) ([]string, error)
+	// fetchTeamMembers returns the logins of the members of the given teams of an organization.
+	fetchTeamMembers(ctx context.Context, org string, teams []string) ([]string, error)
+}
+
+// getProvider returns the provider for a command target. Targets prefixed with gitlab: are hosted on GitLab,
+// every other target on GitHub. The target is returned without the prefix.
+func (p *Plugin) getProvider(userID, target string) (provider, string, *model.AppError) {
+	if strings.HasPrefix(target, gitLabPrefix) {
+		gitLab, err := p.getGitLabProvider()
+		if err != nil {
+			p.API.LogWarn("Failed to create GitLab client", "error", err.Error())
+
+			return nil, "", &model.AppError{
+				Id:         "Failed to connect to GitLab.",
+				StatusCode: http.StatusBadRequest,
+				Where:      "p.ExecuteCommand",
+			}
+		}
+		return p.withLocalClones(gitLab), strings.TrimPrefix(target, gitLabPrefix), nil
+	}
+
+	client, err := p.getGitHubClient(userID)
+	if err != nil {
+		p.API.LogWarn("Failed to create GitHub client", "error", err.Error())
+
+		return nil, "", &model.AppError{
+			Id:         "Failed to connect to GitHub.",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	return p.withLocalClones(&gitHubProvider{p: p, client: client, userID: userID}), target, nil
+}
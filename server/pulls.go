@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/mattermost/mattermost-plugin-community/server/util"
+)
+
+type pullRequestsResult struct {
+	pullRequests []*github.PullRequest
+	err          error
+}
+
+// fetchPullRequestsFromRepos fetches the pull requests of multiple repositories of the same owner.
+// Repositories that fail to be fetched are skipped, unless ctx is done.
+func (p *Plugin) fetchPullRequestsFromRepos(ctx context.Context, client *github.Client, owner string, repos []string, since, until time.Time) ([]*github.PullRequest, error) {
+	var result []*github.PullRequest
+
+	pr := getProgress(ctx)
+	pr.addRepos(len(repos))
+
+	var wg sync.WaitGroup
+	var jobResults = make(chan pullRequestsResult, len(repos))
+
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			pullRequests, err := p.fetchPullRequestsFromRepo(ctx, client, owner, repo, since, until)
+			jobResults <- pullRequestsResult{pullRequests, err}
+		}(repo)
+	}
+	go func() {
+		wg.Wait()
+		close(jobResults)
+	}()
+
+	for jr := range jobResults {
+		pr.repoDone(0, jr.err)
+		if jr.err != nil {
+			p.API.LogWarn("Failed to fetch pull requests", "error", jr.err.Error())
+		} else {
+			result = append(result, jr.pullRequests...)
+		}
+	}
+
+	return result, ctx.Err()
+}
+
+// fetchPullRequestsFromRepo returns the pull requests of a repository that were opened, merged or closed between since and until.
+func (p *Plugin) fetchPullRequestsFromRepo(ctx context.Context, client *github.Client, owner, repo string, since, until time.Time) ([]*github.PullRequest, error) {
+	pullRequests, err := p.fetchUpdatedPullRequests(ctx, client, owner, repo, since)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*github.PullRequest
+	for _, pullRequest := range pullRequests {
+		if isPullRequestActive(pullRequest, since, until) {
+			result = append(result, pullRequest)
+		}
+	}
+	return result, nil
+}
+
+// fetchUpdatedPullRequests returns the pull requests of a repository that were updated since the given time.
+// Pull requests are listed by their last update, so listing stops at the first one that wasn't updated since then.
+func (p *Plugin) fetchUpdatedPullRequests(ctx context.Context, client *github.Client, owner, repo string, since time.Time) ([]*github.PullRequest, error) {
+	var result []*github.PullRequest
+	opts := &github.PullRequestListOptions{
+		State:     "all",
+		Sort:      "updated",
+		Direction: "desc",
+		ListOptions: github.ListOptions{
+			PerPage: resultsPerPage,
+		},
+	}
+
+	for {
+		var pullRequests []*github.PullRequest
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			pullRequests, resp, err = client.PullRequests.List(ctx, owner, repo, opts)
+			return resp, err
+		})
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("repository %v/%v not found", owner, repo)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pullRequest := range pullRequests {
+			if pullRequest.GetUpdatedAt().Before(since) {
+				return result, nil
+			}
+			result = append(result, pullRequest)
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return result, nil
+}
+
+// isPullRequestActive checks if a pull request was opened, merged or closed between since and until.
+func isPullRequestActive(pullRequest *github.PullRequest, since, until time.Time) bool {
+	return inRange(pullRequest.GetCreatedAt(), since, until) ||
+		inRange(pullRequest.GetMergedAt(), since, until) ||
+		inRange(pullRequest.GetClosedAt(), since, until)
+}
+
+func inRange(t, since, until time.Time) bool {
+	return !t.IsZero() && !t.Before(since) && !t.After(until)
+}
+
+type authorPullRequests struct {
+	login          string
+	opened         int
+	merged         int
+	closedUnmerged int
+}
+
+type pullRequestStats struct {
+	opened            int
+	merged            int
+	closedUnmerged    int
+	medianTimeToMerge time.Duration
+	p90TimeToMerge    time.Duration
+	authors           []*authorPullRequests
+}
+
+// computePullRequestStats counts the pull requests opened, merged and closed without merging between since and until.
+// The time to merge is measured from opening to merging for the pull requests merged in that time.
+func computePullRequestStats(pullRequests []*github.PullRequest, since, until time.Time) pullRequestStats {
+	var stats pullRequestStats
+	var timesToMerge []time.Duration
+	authors := map[string]*authorPullRequests{}
+
+	author := func(pullRequest *github.PullRequest) *authorPullRequests {
+		login := pullRequest.GetUser().GetLogin()
+		a, ok := authors[login]
+		if !ok {
+			a = &authorPullRequests{login: login}
+			authors[login] = a
+		}
+		return a
+	}
+
+	for _, pullRequest := range pullRequests {
+		if inRange(pullRequest.GetCreatedAt(), since, until) {
+			stats.opened++
+			author(pullRequest).opened++
+		}
+
+		if inRange(pullRequest.GetMergedAt(), since, until) {
+			stats.merged++
+			author(pullRequest).merged++
+			timesToMerge = append(timesToMerge, pullRequest.GetMergedAt().Sub(pullRequest.GetCreatedAt()))
+		} else if pullRequest.GetMergedAt().IsZero() && inRange(pullRequest.GetClosedAt(), since, until) {
+			stats.closedUnmerged++
+			author(pullRequest).closedUnmerged++
+		}
+	}
+
+	sort.Slice(timesToMerge, func(i, j int) bool {
+		return timesToMerge[i] < timesToMerge[j]
+	})
+	stats.medianTimeToMerge = percentile(timesToMerge, 50)
+	stats.p90TimeToMerge = percentile(timesToMerge, 90)
+
+	for _, a := range authors {
+		stats.authors = append(stats.authors, a)
+	}
+	sort.Slice(stats.authors, func(i, j int) bool {
+		a, b := stats.authors[i], stats.authors[j]
+		if a.opened != b.opened {
+			return a.opened > b.opened
+		}
+		if a.merged != b.merged {
+			return a.merged > b.merged
+		}
+		return a.login < b.login
+	})
+
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted durations, or 0 if there are none.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (p *Plugin) executePullRequestsCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	return p.startRangeJob(commandArgs, args, &job{Type: jobTypePullRequests}, "Fetching pull request stats")
+}
+
+func (p *Plugin) updatePullRequestsPost(ctx context.Context, forge provider, post *model.Post, userID, owner, repo string, isOrg bool, since, until time.Time) error {
+	// Include pull requests until one day after at midnight
+	fetchUntil := until.AddDate(0, 0, 1).Add(-time.Microsecond)
+
+	pullRequests, err := forge.fetchPullRequests(ctx, owner, repo, isOrg, since, fetchUntil)
+	err = finishFetching(ctx, err)
+
+	attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
+	if err != nil {
+		p.API.LogError("failed to fetch data", "err", err.Error())
+		attachment.Text = githubErrorHandle(err)
+	} else {
+		pullRequests, filtered := p.getBotFilter().filterPullRequests(pullRequests)
+		stats := computePullRequestStats(pullRequests, since, fetchUntil)
+
+		var authorText string
+		for _, a := range stats.authors {
+			authorText += fmt.Sprintf("- [%s](%s): %v opened, %v merged, %v closed\n", a.login, forge.webURL(a.login), a.opened, a.merged, a.closedUnmerged)
+		}
+
+		attachment.Title = "Pull request stats between " + since.Format(shortFormWithDay) + " and " + until.Format(shortFormWithDay)
+		attachment.Text = ""
+		attachment.Fields = []*model.SlackAttachmentField{{
+			Title: "Opened",
+			Value: strconv.Itoa(stats.opened),
+			Short: true,
+		}, {
+			Title: "Merged",
+			Value: strconv.Itoa(stats.merged),
+			Short: true,
+		}, {
+			Title: "Closed without merging",
+			Value: strconv.Itoa(stats.closedUnmerged),
+			Short: true,
+		}, {
+			Title: "Median time to merge",
+			Value: util.FormatDuration(stats.medianTimeToMerge),
+			Short: true,
+		}, {
+			Title: "90th percentile time to merge",
+			Value: util.FormatDuration(stats.p90TimeToMerge),
+			Short: true,
+		}, {
+			Title: "Authors",
+			Value: authorText,
+		}}
+		attachment.Fields = append(attachment.Fields, filtered.field("pull requests")...)
+	}
+
+	if _, appErr := p.API.UpdatePost(post); appErr != nil {
+		p.SendEphemeralPost(post.ChannelId, userID, "Something went bad. Please try again.")
+		p.API.LogError("failed to update post", "err", appErr.Error())
+		return appErr
+	}
+
+	return err
+}
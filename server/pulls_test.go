@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputePullRequestStats(t *testing.T) {
+	since := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2020, 9, 30, 23, 59, 59, 0, time.UTC)
+	day := func(d int) *time.Time {
+		t := time.Date(2020, 9, d, 12, 0, 0, 0, time.UTC)
+		return &t
+	}
+	pullRequest := func(login string, createdAt, mergedAt, closedAt *time.Time) *github.PullRequest {
+		return &github.PullRequest{
+			User:      &github.User{Login: github.String(login)},
+			CreatedAt: createdAt,
+			MergedAt:  mergedAt,
+			ClosedAt:  closedAt,
+		}
+	}
+	august := time.Date(2020, 8, 30, 12, 0, 0, 0, time.UTC)
+
+	stats := computePullRequestStats([]*github.PullRequest{
+		pullRequest("alice", day(1), day(2), day(2)),
+		pullRequest("alice", day(3), day(13), day(13)),
+		pullRequest("alice", day(4), nil, nil),
+		pullRequest("bob", day(5), nil, day(6)),
+		// Opened before, merged in the time range
+		pullRequest("carol", &august, day(1), day(1)),
+	}, since, until)
+
+	assert.Equal(t, 4, stats.opened)
+	assert.Equal(t, 3, stats.merged)
+	assert.Equal(t, 1, stats.closedUnmerged)
+	assert.Equal(t, 2*24*time.Hour, stats.medianTimeToMerge)
+	assert.Equal(t, 10*24*time.Hour, stats.p90TimeToMerge)
+
+	require.Len(t, stats.authors, 3)
+	assert.Equal(t, &authorPullRequests{login: "alice", opened: 3, merged: 2}, stats.authors[0])
+	assert.Equal(t, &authorPullRequests{login: "bob", opened: 1, closedUnmerged: 1}, stats.authors[1])
+	assert.Equal(t, &authorPullRequests{login: "carol", merged: 1}, stats.authors[2])
+}
+
+func TestPercentile(t *testing.T) {
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, time.Duration(5), percentile(sorted, 50))
+	assert.Equal(t, time.Duration(9), percentile(sorted, 90))
+	assert.Equal(t, time.Duration(1), percentile(sorted[:1], 90))
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/mattermost/mattermost-plugin-community/server/util"
+)
+
+const (
+	// responseSLA is the business time in which pull requests and issues by external authors should get a response.
+	responseSLA = 2 * 24 * time.Hour
+
+	// maxListedWaiting is the number of unanswered pull requests and issues listed in the response time report.
+	maxListedWaiting = 10
+)
+
+// contribution is a pull request or an issue, with the time of its first response by someone other than the author.
+type contribution struct {
+	author        string
+	title         string
+	url           string
+	isPullRequest bool
+	createdAt     time.Time
+	// respondedAt is zero if nobody but the author has responded yet.
+	respondedAt time.Time
+	// closedAt is zero if the pull request or issue is still open. Merged pull requests are closed, too.
+	closedAt time.Time
+}
+
+type contributionsResult struct {
+	contributions []contribution
+	err           error
+}
+
+// fetchFirstResponsesFromRepos fetches the first responses to pull requests and issues of multiple repositories of the same owner.
+// Repositories that fail to be fetched are skipped, unless ctx is done.
+func (p *Plugin) fetchFirstResponsesFromRepos(ctx context.Context, client *github.Client, owner string, repos []string, since, until time.Time) ([]contribution, error) {
+	var result []contribution
+
+	pr := getProgress(ctx)
+	pr.addRepos(len(repos))
+
+	var wg sync.WaitGroup
+	var jobResults = make(chan contributionsResult, len(repos))
+
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			contributions, err := p.fetchFirstResponsesFromRepo(ctx, client, owner, repo, since, until)
+			jobResults <- contributionsResult{contributions, err}
+		}(repo)
+	}
+	go func() {
+		wg.Wait()
+		close(jobResults)
+	}()
+
+	for jr := range jobResults {
+		pr.repoDone(0, jr.err)
+		if jr.err != nil {
+			p.API.LogWarn("Failed to fetch responses", "error", jr.err.Error())
+		} else {
+			result = append(result, jr.contributions...)
+		}
+	}
+
+	return result, ctx.Err()
+}
+
+// fetchFirstResponsesFromRepo returns the pull requests and issues of a repository that were opened between since and until.
+// Comments and reviews by the author or by bots don't count as a response.
+func (p *Plugin) fetchFirstResponsesFromRepo(ctx context.Context, client *github.Client, owner, repo string, since, until time.Time) ([]contribution, error) {
+	var issues []*github.Issue
+	opts := &github.IssueListByRepoOptions{
+		State: "all",
+		Since: since,
+		ListOptions: github.ListOptions{
+			PerPage: resultsPerPage,
+		},
+	}
+	for {
+		var page []*github.Issue
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			page, resp, err = client.Issues.ListByRepo(ctx, owner, repo, opts)
+			return resp, err
+		})
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("repository %v/%v not found", owner, repo)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, issue := range page {
+			if inRange(issue.GetCreatedAt(), since, until) {
+				issues = append(issues, issue)
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	bots := p.getBotFilter()
+	var result []contribution
+	for _, issue := range issues {
+		c := contribution{
+			author:        issue.GetUser().GetLogin(),
+			title:         issue.GetTitle(),
+			url:           issue.GetHTMLURL(),
+			isPullRequest: issue.IsPullRequest(),
+			createdAt:     issue.GetCreatedAt(),
+			closedAt:      issue.GetClosedAt(),
+		}
+		respond := func(user *github.User, t time.Time) {
+			if user.GetLogin() == c.author || bots.isBot(user) || t.IsZero() {
+				return
+			}
+			if c.respondedAt.IsZero() || t.Before(c.respondedAt) {
+				c.respondedAt = t
+			}
+		}
+
+		if issue.GetComments() > 0 {
+			comments, err := p.fetchIssueComments(ctx, client, owner, repo, issue.GetNumber())
+			if err != nil {
+				return nil, err
+			}
+			for _, comment := range comments {
+				respond(comment.GetUser(), comment.GetCreatedAt())
+			}
+		}
+
+		// Review comments always belong to a review
+		if c.isPullRequest {
+			reviews, err := p.fetchPullRequestReviews(ctx, client, owner, repo, issue.GetNumber())
+			if err != nil {
+				return nil, err
+			}
+			for _, review := range reviews {
+				respond(review.GetUser(), review.GetSubmittedAt())
+			}
+		}
+
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+func (p *Plugin) fetchIssueComments(ctx context.Context, client *github.Client, owner, repo string, number int) ([]*github.IssueComment, error) {
+	var result []*github.IssueComment
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{
+			PerPage: resultsPerPage,
+		},
+	}
+	for {
+		var comments []*github.IssueComment
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			comments, resp, err = client.Issues.ListComments(ctx, owner, repo, number, opts)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, comments...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return result, nil
+}
+
+// businessDuration returns the time between start and end that falls on a weekday.
+// Days are split at midnight in the location of start.
+func businessDuration(start, end time.Time) time.Duration {
+	var result time.Duration
+	for t := start; t.Before(end); {
+		next := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		if next.After(end) {
+			next = end
+		}
+		if t.Weekday() != time.Saturday && t.Weekday() != time.Sunday {
+			result += next.Sub(t)
+		}
+		t = next
+	}
+	return result
+}
+
+type responseStats struct {
+	opened    int
+	responded int
+	// closedWithoutResponse counts the contributions that were closed or merged before anybody but the author responded.
+	// They don't wait for a response anymore, and don't count for the SLA.
+	closedWithoutResponse int
+	// withinSLA and pastSLA count the contributions that got a response within the SLA or didn't.
+	// Contributions that are still waiting count as past the SLA once it's exceeded.
+	withinSLA            int
+	pastSLA              int
+	medianTimeToResponse time.Duration
+	p90TimeToResponse    time.Duration
+	waiting              []contribution
+}
+
+// computeResponseStats measures the business time to the first response of contributions.
+// Contributions that are still waiting are returned longest waiting first.
+func computeResponseStats(contributions []contribution, now time.Time) responseStats {
+	var stats responseStats
+	var timesToResponse []time.Duration
+
+	for _, c := range contributions {
+		stats.opened++
+
+		if c.respondedAt.IsZero() && !c.closedAt.IsZero() {
+			stats.closedWithoutResponse++
+			continue
+		}
+		if c.respondedAt.IsZero() {
+			stats.waiting = append(stats.waiting, c)
+			if businessDuration(c.createdAt, now) > responseSLA {
+				stats.pastSLA++
+			}
+			continue
+		}
+
+		stats.responded++
+		d := businessDuration(c.createdAt, c.respondedAt)
+		timesToResponse = append(timesToResponse, d)
+		if d > responseSLA {
+			stats.pastSLA++
+		} else {
+			stats.withinSLA++
+		}
+	}
+
+	sort.Slice(timesToResponse, func(i, j int) bool {
+		return timesToResponse[i] < timesToResponse[j]
+	})
+	stats.medianTimeToResponse = percentile(timesToResponse, 50)
+	stats.p90TimeToResponse = percentile(timesToResponse, 90)
+
+	sort.Slice(stats.waiting, func(i, j int) bool {
+		return stats.waiting[i].createdAt.Before(stats.waiting[j].createdAt)
+	})
+
+	return stats
+}
+
+func formatResponseStats(stats responseStats) string {
+	if stats.opened == 0 {
+		return "None"
+	}
+
+	compliance := "n/a"
+	if stats.withinSLA+stats.pastSLA > 0 {
+		compliance = fmt.Sprintf("%v%%", stats.withinSLA*100/(stats.withinSLA+stats.pastSLA))
+	}
+
+	return fmt.Sprintf("- Opened: %v\n- Responded: %v\n- Closed without a response: %v\n- Within SLA: %v, past SLA: %v (%v met)\n- Median time to first response: %v\n- 90th percentile time to first response: %v\n- Still waiting: %v\n",
+		stats.opened, stats.responded, stats.closedWithoutResponse, stats.withinSLA, stats.pastSLA, compliance,
+		util.FormatDuration(stats.medianTimeToResponse), util.FormatDuration(stats.p90TimeToResponse), len(stats.waiting))
+}
+
+func (p *Plugin) executeResponseTimeCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	return p.startRangeJob(commandArgs, args, &job{Type: jobTypeResponseTime}, "Fetching response times")
+}
+
+func (p *Plugin) updateResponseTimePost(ctx context.Context, forge provider, post *model.Post, userID, owner, repo string, isOrg bool, since, until time.Time) error {
+	// Include contributions until one day after at midnight
+	fetchUntil := until.AddDate(0, 0, 1).Add(-time.Microsecond)
+
+	contributions, err := forge.fetchFirstResponses(ctx, owner, repo, isOrg, since, fetchUntil)
+
+	var internal map[string]bool
+	if err == nil {
+		internal, err = p.fetchStaff(ctx, forge, owner, isOrg)
+	}
+	err = finishFetching(ctx, err)
+
+	attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
+	if err != nil {
+		p.API.LogError("failed to fetch data", "err", err.Error())
+		attachment.Text = githubErrorHandle(err)
+	} else {
+		contributions, filtered := p.getBotFilter().filterContributions(contributions)
+
+		var internalContributions, externalContributions []contribution
+		for _, c := range contributions {
+			if internal[strings.ToLower(c.author)] {
+				internalContributions = append(internalContributions, c)
+			} else {
+				externalContributions = append(externalContributions, c)
+			}
+		}
+
+		now := time.Now()
+		externalStats := computeResponseStats(externalContributions, now)
+		internalStats := computeResponseStats(internalContributions, now)
+
+		waiting := externalStats.waiting
+		if len(waiting) > maxListedWaiting {
+			waiting = waiting[:maxListedWaiting]
+		}
+		waitingText := "None"
+		if len(waiting) > 0 {
+			waitingText = ""
+		}
+		for _, c := range waiting {
+			kind := "Issue"
+			if c.isPullRequest {
+				kind = "PR"
+			}
+			waitingText += fmt.Sprintf("- %s [%s](%s) by [%s](%s): waiting for %v\n",
+				kind, c.title, c.url, c.author, forge.webURL(c.author), util.FormatDuration(businessDuration(c.createdAt, now)))
+		}
+
+		attachment.Title = "Time to first response between " + since.Format(shortFormWithDay) + " and " + until.Format(shortFormWithDay)
+		attachment.Text = fmt.Sprintf("Business time to the first response by someone other than the author. The SLA is %v business days.", int(responseSLA.Hours()/24))
+		attachment.Fields = []*model.SlackAttachmentField{{
+			Title: "External authors",
+			Value: formatResponseStats(externalStats),
+			Short: true,
+		}, {
+			Title: "Organization members",
+			Value: formatResponseStats(internalStats),
+			Short: true,
+		}, {
+			Title: "Longest waiting external contributions",
+			Value: waitingText,
+		}}
+		attachment.Fields = append(attachment.Fields, filtered.field("pull requests and issues")...)
+	}
+
+	if _, appErr := p.API.UpdatePost(post); appErr != nil {
+		p.SendEphemeralPost(post.ChannelId, userID, "Something went bad. Please try again.")
+		p.API.LogError("failed to update post", "err", appErr.Error())
+		return appErr
+	}
+
+	return err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBusinessDuration(t *testing.T) {
+	// 2020-09-04 is a Friday
+	friday := time.Date(2020, 9, 4, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Duration(0), businessDuration(friday, friday))
+	assert.Equal(t, time.Duration(0), businessDuration(friday, friday.Add(-time.Hour)))
+	assert.Equal(t, 6*time.Hour, businessDuration(friday, friday.Add(6*time.Hour)))
+	// The weekend isn't counted
+	assert.Equal(t, 12*time.Hour, businessDuration(friday, friday.AddDate(0, 0, 2)))
+	assert.Equal(t, 24*time.Hour, businessDuration(friday, friday.AddDate(0, 0, 3)))
+	assert.Equal(t, time.Duration(0), businessDuration(friday.AddDate(0, 0, 1), friday.AddDate(0, 0, 2)))
+	assert.Equal(t, 5*24*time.Hour, businessDuration(friday, friday.AddDate(0, 0, 7)))
+}
+
+func TestComputeResponseStats(t *testing.T) {
+	// 2020-09-07 is a Monday
+	day := func(d, hour int) time.Time {
+		return time.Date(2020, 9, d, hour, 0, 0, 0, time.UTC)
+	}
+	now := day(18, 12)
+
+	stats := computeResponseStats([]contribution{
+		{author: "alice", createdAt: day(7, 12), respondedAt: day(7, 14)},
+		{author: "alice", createdAt: day(8, 12), respondedAt: day(9, 12)},
+		// Over a weekend, but within two business days
+		{author: "bob", createdAt: day(11, 12), respondedAt: day(15, 10)},
+		// Three business days
+		{author: "bob", createdAt: day(14, 12), respondedAt: day(17, 12)},
+		// Waiting past the SLA
+		{author: "carol", title: "oldest", createdAt: day(10, 12)},
+		// Waiting within the SLA
+		{author: "carol", title: "newest", createdAt: day(17, 12)},
+		// Closed or merged without a comment don't wait anymore
+		{author: "dave", title: "merged", createdAt: day(7, 12), closedAt: day(8, 12)},
+		// Responses after closing still count
+		{author: "dave", title: "closed", createdAt: day(7, 12), respondedAt: day(8, 12), closedAt: day(7, 13)},
+	}, now)
+
+	assert.Equal(t, 8, stats.opened)
+	assert.Equal(t, 5, stats.responded)
+	assert.Equal(t, 1, stats.closedWithoutResponse)
+	assert.Equal(t, 4, stats.withinSLA)
+	assert.Equal(t, 2, stats.pastSLA)
+	assert.Equal(t, 24*time.Hour, stats.medianTimeToResponse)
+	assert.Equal(t, 3*24*time.Hour, stats.p90TimeToResponse)
+
+	require.Len(t, stats.waiting, 2)
+	assert.Equal(t, "oldest", stats.waiting[0].title)
+	assert.Equal(t, "newest", stats.waiting[1].title)
+}
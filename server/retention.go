@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// retentionOffsets are the months after their first contribution, for which the share of a cohort that contributed again is reported.
+var retentionOffsets = []int{1, 3, 6, 12}
+
+// cohort are the contributors whose first contribution was in the same month.
+type cohort struct {
+	month        time.Time
+	contributors int
+	// retained counts the contributors that committed again per month offset. Offsets are missing, if that month isn't over yet.
+	retained map[int]int
+}
+
+// monthIndex numbers months consecutively, so that offsets between months can be computed.
+func monthIndex(t time.Time) int {
+	return t.Year()*12 + int(t.Month()) - 1
+}
+
+func (p *Plugin) executeRetentionCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	if len(commandArgs) != 2 {
+		return &model.AppError{
+			Id:         "Usage: /community retention [organization] [year-month of the first cohort]",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	since, err := time.Parse(shortForm, commandArgs[1])
+	if err != nil {
+		return &model.AppError{
+			Id:         "Failed to parse month",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	if !since.Before(truncateToMonth(time.Now())) {
+		return &model.AppError{
+			Id:         "The first cohort has to be before the current month",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	forge, organization, appErr := p.getProvider(args.UserId, commandArgs[0])
+	if appErr != nil {
+		return appErr
+	}
+
+	avatarLogo, err := forge.avatarURL(context.Background(), organization, true)
+	if err != nil {
+		p.API.LogWarn("Failed to fetch organization", "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to fetch data",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	attachments := []*model.SlackAttachment{{
+		Title:      "Fetching contributor retention since " + since.Format(shortForm),
+		Text:       waitText,
+		AuthorName: organization,
+		AuthorIcon: avatarLogo,
+		AuthorLink: forge.webURL(organization),
+	}}
+
+	return p.startJob(args, &job{
+		Type:   jobTypeRetention,
+		Target: commandArgs[0],
+		IsOrg:  true,
+		Since:  since,
+	}, attachments)
+}
+
+func truncateToMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (p *Plugin) updateRetentionPost(ctx context.Context, forge provider, post *model.Post, userID, org string, since time.Time) error {
+	now := time.Now()
+
+	firstContributions, err := forge.findFirstContributions(ctx, org, nil, since)
+	var coAuthors []firstContributionInfo
+	var commits, coAuthored []*github.RepositoryCommit
+	var staff map[string]bool
+	if err == nil {
+		commits, err = p.fetchRetentionCommits(ctx, forge, org, since, now)
+	}
+	if err == nil {
+		staff = p.fetchStaffForReport(ctx, forge, org, true)
+		coAuthored, err = p.fetchCoAuthoredCommits(ctx, forge, commits)
+	}
+	if err == nil {
+		coAuthors, err = p.findFirstCoAuthorships(ctx, forge, org, since, coAuthored, firstContributions)
+	}
+	err = finishFetching(ctx, err)
+	if err != nil {
+		p.logAndPropUserAboutError(post, userID, err)
+		return err
+	}
+
+	var first []firstContributionInfo
+	for _, contribution := range firstContributions {
+		first = append(first, contribution)
+	}
+	if p.getCoAuthorsMode() == coAuthorsCredit {
+		first = append(first, coAuthors...)
+	}
+
+	bots := p.getBotFilter()
+	first, filtered := bots.filterFirstContributions(first)
+	commits, _ = bots.filterCommits(p.resolveIdentities(commits))
+	coAuthored, _ = bots.filterCommits(p.resolveIdentities(coAuthored))
+	credited, _ := p.creditCoAuthors(commits, coAuthored)
+
+	if m, _, err := p.getIdentityMap(); err == nil {
+		for i := range first {
+			first[i].author = m.login(first[i].author)
+		}
+	} else {
+		p.API.LogWarn("Failed to load identity map", "error", err.Error())
+	}
+
+	if staff != nil {
+		_, first = splitFirstContributions(first, staff)
+	}
+
+	cohorts := computeCohorts(first, credited, since, now)
+
+	attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
+	attachment.Title = "Contributor retention since " + since.Format(shortForm)
+	attachment.Text = formatCohorts(cohorts)
+	explanation := "Contributors are grouped by the month of their first commit. Every column shows the share of a cohort that committed again in the month that many months later. Months that aren't over yet are shown as -."
+	if staff != nil {
+		explanation += " Staff isn't included."
+	} else {
+		explanation += " Staff couldn't be fetched, so it's counted as community."
+	}
+	attachment.Fields = []*model.SlackAttachmentField{{
+		Title: "How to read this",
+		Value: explanation,
+	}}
+	attachment.Fields = append(attachment.Fields, filtered.field("")...)
+	p.updatePost(post, userID)
+
+	p.createRetentionCSVPost(post, userID, org, since, cohorts)
+	return nil
+}
+
+// retentionRanges returns the time ranges whose commits the cohorts since the month of since need, with consecutive months merged.
+// These are the months the offsets of a cohort point to, as long as they are over before now, and, if coAuthors is set,
+// the months of the cohorts themselves, whose co-authored commits can be first contributions.
+func retentionRanges(since, now time.Time, coAuthors bool) []timeRange {
+	start := monthIndex(since.UTC())
+	current := monthIndex(now.UTC())
+
+	needed := map[int]bool{}
+	for month := start; month < current; month++ {
+		if coAuthors {
+			needed[month] = true
+		}
+		for _, offset := range retentionOffsets {
+			if month+offset < current {
+				needed[month+offset] = true
+			}
+		}
+	}
+
+	toTime := func(month int) time.Time {
+		return time.Date(month/12, time.Month(month%12+1), 1, 0, 0, 0, 0, time.UTC)
+	}
+	var result []timeRange
+	for month := start; month < current; month++ {
+		if !needed[month] {
+			continue
+		}
+		if n := len(result); n > 0 && result[n-1].until.Equal(toTime(month)) {
+			result[n-1].until = toTime(month + 1)
+			continue
+		}
+		result = append(result, timeRange{toTime(month), toTime(month + 1)})
+	}
+	return result
+}
+
+// fetchRetentionCommits fetches the commits of org in the months returned by retentionRanges.
+// The current month isn't fetched, because no offset points to a month that isn't over.
+func (p *Plugin) fetchRetentionCommits(ctx context.Context, forge provider, org string, since, now time.Time) ([]*github.RepositoryCommit, error) {
+	var result []*github.RepositoryCommit
+	for _, r := range retentionRanges(since, now, p.getCoAuthorsMode() == coAuthorsCredit) {
+		commits, err := forge.fetchCommits(ctx, org, "", true, r.since, r.until.Add(-time.Nanosecond))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, commits...)
+	}
+	return result, nil
+}
+
+// computeCohorts groups the contributors by the month of their first contribution, from the month of since until the month before now.
+// A contributor is retained after n months, if they committed in the nth month after their first contribution.
+func computeCohorts(first []firstContributionInfo, commits []*github.RepositoryCommit, since, now time.Time) []*cohort {
+	activeMonths := map[string]map[int]bool{}
+	for _, c := range commits {
+		login := strings.ToLower(c.GetAuthor().GetLogin())
+		if login == "" {
+			continue
+		}
+		if activeMonths[login] == nil {
+			activeMonths[login] = map[int]bool{}
+		}
+		activeMonths[login][monthIndex(commitDate(c).UTC())] = true
+	}
+
+	firstMonths := map[string]int{}
+	for _, e := range first {
+		login := strings.ToLower(e.author)
+		month := monthIndex(e.date.UTC())
+		if earlier, ok := firstMonths[login]; !ok || month < earlier {
+			firstMonths[login] = month
+		}
+	}
+
+	start := monthIndex(since.UTC())
+	current := monthIndex(now.UTC())
+
+	var result []*cohort
+	byMonth := map[int]*cohort{}
+	for month := start; month < current; month++ {
+		c := &cohort{
+			month:    time.Date(month/12, time.Month(month%12+1), 1, 0, 0, 0, 0, time.UTC),
+			retained: map[int]int{},
+		}
+		for _, offset := range retentionOffsets {
+			if month+offset < current {
+				c.retained[offset] = 0
+			}
+		}
+		result = append(result, c)
+		byMonth[month] = c
+	}
+
+	for login, month := range firstMonths {
+		c, ok := byMonth[month]
+		if !ok {
+			continue
+		}
+		c.contributors++
+		for offset := range c.retained {
+			if activeMonths[login][month+offset] {
+				c.retained[offset]++
+			}
+		}
+	}
+	return result
+}
+
+func formatRetention(retained, contributors int) string {
+	if contributors == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%v%% (%v)", retained*100/contributors, retained)
+}
+
+// formatCohorts renders cohorts as table, followed by the retention of all cohorts together.
+func formatCohorts(cohorts []*cohort) string {
+	text := "| Cohort | Contributors |"
+	separator := "|:-------|-------------:|"
+	for _, offset := range retentionOffsets {
+		unit := "months"
+		if offset == 1 {
+			unit = "month"
+		}
+		text += fmt.Sprintf(" %v %v |", offset, unit)
+		separator += "---------:|"
+	}
+	text += "\n" + separator + "\n"
+
+	var contributors int
+	retained := map[int]int{}
+	known := map[int]int{}
+	for _, c := range cohorts {
+		contributors += c.contributors
+		text += fmt.Sprintf("| %v | %v |", c.month.Format(shortForm), c.contributors)
+		for _, offset := range retentionOffsets {
+			count, ok := c.retained[offset]
+			if !ok {
+				text += " - |"
+				continue
+			}
+			retained[offset] += count
+			known[offset] += c.contributors
+			text += fmt.Sprintf(" %v |", formatRetention(count, c.contributors))
+		}
+		text += "\n"
+	}
+
+	text += fmt.Sprintf("| **All** | %v |", contributors)
+	for _, offset := range retentionOffsets {
+		text += fmt.Sprintf(" %v |", formatRetention(retained[offset], known[offset]))
+	}
+	return text + "\n"
+}
+
+// cohortsCSV exports cohorts with the number and share of retained contributors per month offset.
+// Both are empty, if that month isn't over yet.
+func cohortsCSV(cohorts []*cohort) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	header := []string{"cohort", "contributors"}
+	for _, offset := range retentionOffsets {
+		header = append(header, fmt.Sprintf("retained_after_%d_months", offset), fmt.Sprintf("retention_after_%d_months", offset))
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	for _, c := range cohorts {
+		record := []string{c.month.Format(shortForm), strconv.Itoa(c.contributors)}
+		for _, offset := range retentionOffsets {
+			count, ok := c.retained[offset]
+			switch {
+			case !ok:
+				record = append(record, "", "")
+			case c.contributors == 0:
+				record = append(record, "0", "")
+			default:
+				record = append(record, strconv.Itoa(count), strconv.FormatFloat(float64(count)/float64(c.contributors), 'f', 4, 64))
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// createRetentionCSVPost replies to the report with the cohorts as CSV file.
+func (p *Plugin) createRetentionCSVPost(post *model.Post, userID, org string, since time.Time, cohorts []*cohort) {
+	data, err := cohortsCSV(cohorts)
+	if err != nil {
+		p.API.LogError("failed to encode cohorts", "err", err.Error())
+		return
+	}
+
+	fileName := fmt.Sprintf("retention-%v-%v.csv", strings.Replace(org, "/", "-", -1), since.Format(shortForm))
+	fileInfo, appErr := p.API.UploadFile(data, post.ChannelId, fileName)
+	if appErr != nil {
+		p.SendEphemeralPost(post.ChannelId, userID, "Failed to upload the CSV export. "+appErr.Where+" "+appErr.Id)
+		p.API.LogError("failed to upload file", "err", appErr.Error())
+		return
+	}
+
+	csvPost := &model.Post{
+		ChannelId: post.ChannelId,
+		UserId:    p.botUserID,
+		RootId:    post.Id,
+		Message:   "Contributor retention as CSV",
+		FileIds:   []string{fileInfo.Id},
+	}
+	if _, appErr := p.API.CreatePost(csvPost); appErr != nil {
+		p.SendEphemeralPost(post.ChannelId, userID, "Something went bad. Please try again. "+appErr.Where+" "+appErr.Id)
+		p.API.LogError("failed to create post", "err", appErr.Error())
+	}
+}
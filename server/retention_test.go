@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeCohorts(t *testing.T) {
+	month := func(year int, m time.Month, day int) time.Time {
+		return time.Date(year, m, day, 12, 0, 0, 0, time.UTC)
+	}
+	commit := func(login string, date time.Time) *github.RepositoryCommit {
+		return &github.RepositoryCommit{
+			Author: &github.User{Login: github.String(login)},
+			Commit: &github.Commit{Committer: &github.CommitAuthor{Date: &date}},
+		}
+	}
+
+	first := []firstContributionInfo{
+		{author: "Alice", date: month(2020, time.January, 5)},
+		{author: "bob", date: month(2020, time.January, 20)},
+		{author: "carol", date: month(2020, time.March, 1)},
+		// Contributions before the first cohort are ignored
+		{author: "dave", date: month(2019, time.December, 1)},
+	}
+	commits := []*github.RepositoryCommit{
+		commit("alice", month(2020, time.January, 5)),
+		commit("alice", month(2020, time.February, 10)),
+		commit("alice", month(2020, time.April, 1)),
+		commit("bob", month(2020, time.February, 28)),
+		commit("carol", month(2020, time.June, 30)),
+		commit("dave", month(2020, time.February, 1)),
+	}
+
+	cohorts := computeCohorts(first, commits, month(2020, time.January, 1), month(2020, time.August, 15))
+	require.Len(t, cohorts, 7)
+
+	assert.Equal(t, time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), cohorts[0].month)
+	assert.Equal(t, 2, cohorts[0].contributors)
+	// 12 months after January 2020 aren't over yet
+	assert.Equal(t, map[int]int{1: 2, 3: 1, 6: 0}, cohorts[0].retained)
+
+	assert.Equal(t, 0, cohorts[1].contributors)
+	assert.Equal(t, 1, cohorts[2].contributors)
+	assert.Equal(t, map[int]int{1: 0, 3: 1}, cohorts[2].retained)
+	assert.Empty(t, cohorts[6].retained)
+}
+
+func TestRetentionRanges(t *testing.T) {
+	month := func(year int, m time.Month) time.Time {
+		return time.Date(year, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+	now := time.Date(2020, time.August, 15, 12, 0, 0, 0, time.UTC)
+
+	t.Run("offsets", func(t *testing.T) {
+		// The first cohort and the current month aren't needed
+		assert.Equal(t, []timeRange{{month(2020, time.February), month(2020, time.August)}}, retentionRanges(month(2020, time.January), now, false))
+		assert.Empty(t, retentionRanges(month(2020, time.July), now, false))
+	})
+
+	t.Run("offsets of a single cohort", func(t *testing.T) {
+		now := time.Date(2021, time.January, 15, 0, 0, 0, 0, time.UTC)
+		// Cohorts from November 2020 need December 2020 only
+		assert.Equal(t, []timeRange{{month(2020, time.December), month(2021, time.January)}}, retentionRanges(month(2020, time.November), now, false))
+	})
+
+	t.Run("co-authors", func(t *testing.T) {
+		assert.Equal(t, []timeRange{{month(2020, time.January), month(2020, time.August)}}, retentionRanges(month(2020, time.January), now, true))
+	})
+}
+
+func TestFormatCohorts(t *testing.T) {
+	cohorts := []*cohort{
+		{month: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), contributors: 4, retained: map[int]int{1: 2, 3: 1}},
+		{month: time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC), contributors: 0, retained: map[int]int{1: 0}},
+		{month: time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC), contributors: 1, retained: map[int]int{}},
+	}
+
+	assert.Equal(t, "| Cohort | Contributors | 1 month | 3 months | 6 months | 12 months |\n"+
+		"|:-------|-------------:|---------:|---------:|---------:|---------:|\n"+
+		"| 2020-01 | 4 | 50% (2) | 25% (1) | - | - |\n"+
+		"| 2020-02 | 0 | n/a | - | - | - |\n"+
+		"| 2020-03 | 1 | - | - | - | - |\n"+
+		"| **All** | 5 | 50% (2) | 25% (1) | n/a | n/a |\n", formatCohorts(cohorts))
+
+	data, err := cohortsCSV(cohorts)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "cohort,contributors,retained_after_1_months,retention_after_1_months,retained_after_3_months,retention_after_3_months,retained_after_6_months,retention_after_6_months,retained_after_12_months,retention_after_12_months", lines[0])
+	assert.Equal(t, "2020-01,4,2,0.5000,1,0.2500,,,,", lines[1])
+	assert.Equal(t, "2020-02,0,0,,,,,,,", lines[2])
+	assert.Equal(t, "2020-03,1,,,,,,,,", lines[3])
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	reviewStateApproved         = "APPROVED"
+	reviewStateChangesRequested = "CHANGES_REQUESTED"
+	reviewStateCommented        = "COMMENTED"
+)
+
+// reviewActivity is a review or a review comment on a pull request.
+type reviewActivity struct {
+	login string
+	// state is the state of a review, e.g. APPROVED, or empty for a review comment.
+	state string
+	date  time.Time
+}
+
+type reviewsResult struct {
+	reviews []reviewActivity
+	err     error
+}
+
+// fetchReviewsFromRepos fetches the reviews of multiple repositories of the same owner.
+// Repositories that fail to be fetched are skipped, unless ctx is done.
+func (p *Plugin) fetchReviewsFromRepos(ctx context.Context, client *github.Client, owner string, repos []string, since, until time.Time) ([]reviewActivity, error) {
+	var result []reviewActivity
+
+	pr := getProgress(ctx)
+	pr.addRepos(len(repos))
+
+	var wg sync.WaitGroup
+	var jobResults = make(chan reviewsResult, len(repos))
+
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			reviews, err := p.fetchReviewsFromRepo(ctx, client, owner, repo, since, until)
+			jobResults <- reviewsResult{reviews, err}
+		}(repo)
+	}
+	go func() {
+		wg.Wait()
+		close(jobResults)
+	}()
+
+	for jr := range jobResults {
+		pr.repoDone(0, jr.err)
+		if jr.err != nil {
+			p.API.LogWarn("Failed to fetch reviews", "error", jr.err.Error())
+		} else {
+			result = append(result, jr.reviews...)
+		}
+	}
+
+	return result, ctx.Err()
+}
+
+// fetchReviewsFromRepo returns the reviews and review comments of a repository between since and until.
+// Reviews and comments by the author of a pull request, e.g. replies to review comments, aren't included.
+func (p *Plugin) fetchReviewsFromRepo(ctx context.Context, client *github.Client, owner, repo string, since, until time.Time) ([]reviewActivity, error) {
+	// Reviewing a pull request updates it
+	pullRequests, err := p.fetchUpdatedPullRequests(ctx, client, owner, repo, since)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []reviewActivity
+	authors := map[string]string{}
+	for _, pullRequest := range pullRequests {
+		author := pullRequest.GetUser().GetLogin()
+		authors[pullRequest.GetURL()] = author
+
+		reviews, err := p.fetchPullRequestReviews(ctx, client, owner, repo, pullRequest.GetNumber())
+		if err != nil {
+			return nil, err
+		}
+		for _, review := range reviews {
+			login := review.GetUser().GetLogin()
+			switch review.GetState() {
+			case reviewStateApproved, reviewStateChangesRequested, reviewStateCommented:
+			default:
+				continue
+			}
+			if login == author || !inRange(review.GetSubmittedAt(), since, until) {
+				continue
+			}
+			result = append(result, reviewActivity{login, review.GetState(), review.GetSubmittedAt()})
+		}
+	}
+
+	opts := &github.PullRequestListCommentsOptions{
+		Since: since,
+		ListOptions: github.ListOptions{
+			PerPage: resultsPerPage,
+		},
+	}
+	for {
+		var comments []*github.PullRequestComment
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			comments, resp, err = client.PullRequests.ListComments(ctx, owner, repo, 0, opts)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, comment := range comments {
+			login := comment.GetUser().GetLogin()
+			if login == authors[comment.GetPullRequestURL()] || !inRange(comment.GetCreatedAt(), since, until) {
+				continue
+			}
+			result = append(result, reviewActivity{login, "", comment.GetCreatedAt()})
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return result, nil
+}
+
+func (p *Plugin) fetchPullRequestReviews(ctx context.Context, client *github.Client, owner, repo string, number int) ([]*github.PullRequestReview, error) {
+	var result []*github.PullRequestReview
+	opts := &github.ListOptions{
+		PerPage: resultsPerPage,
+	}
+	for {
+		var reviews []*github.PullRequestReview
+		var resp *github.Response
+		err := p.scheduler.do(ctx, func() (*github.Response, error) {
+			var err error
+			reviews, resp, err = client.PullRequests.ListReviews(ctx, owner, repo, number, opts)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, reviews...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return result, nil
+}
+
+type reviewerCount struct {
+	login            string
+	approved         int
+	changesRequested int
+	commented        int
+	comments         int
+}
+
+func (r *reviewerCount) reviews() int {
+	return r.approved + r.changesRequested + r.commented
+}
+
+// countReviewers returns the reviews and review comments per reviewer, most active reviewers first.
+func countReviewers(activities []reviewActivity) []*reviewerCount {
+	reviewers := map[string]*reviewerCount{}
+	for _, activity := range activities {
+		r, ok := reviewers[activity.login]
+		if !ok {
+			r = &reviewerCount{login: activity.login}
+			reviewers[activity.login] = r
+		}
+
+		switch activity.state {
+		case reviewStateApproved:
+			r.approved++
+		case reviewStateChangesRequested:
+			r.changesRequested++
+		case reviewStateCommented:
+			r.commented++
+		default:
+			r.comments++
+		}
+	}
+
+	var result []*reviewerCount
+	for _, r := range reviewers {
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].reviews() != result[j].reviews() {
+			return result[i].reviews() > result[j].reviews()
+		}
+		if result[i].comments != result[j].comments {
+			return result[i].comments > result[j].comments
+		}
+		return result[i].login < result[j].login
+	})
+	return result
+}
+
+func formatReviewers(forge forgeInfo, reviewers []*reviewerCount) string {
+	if len(reviewers) == 0 {
+		return "None"
+	}
+
+	var text string
+	for _, r := range reviewers {
+		text += fmt.Sprintf("- [%s](%s): %v reviews (%v approved, %v changes requested, %v commented), %v review comments\n",
+			r.login, forge.webURL(r.login), r.reviews(), r.approved, r.changesRequested, r.commented, r.comments)
+	}
+	return text
+}
+
+func (p *Plugin) executeReviewersCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	return p.startRangeJob(commandArgs, args, &job{Type: jobTypeReviewers}, "Fetching reviewer stats")
+}
+
+func (p *Plugin) updateReviewersPost(ctx context.Context, forge provider, post *model.Post, userID, owner, repo string, isOrg bool, since, until time.Time) error {
+	// Include reviews until one day after at midnight
+	fetchUntil := until.AddDate(0, 0, 1).Add(-time.Microsecond)
+
+	activities, err := forge.fetchReviews(ctx, owner, repo, isOrg, since, fetchUntil)
+
+	// Staff, i.e. members of the organization or the user who owns the repositories, are internal reviewers.
+	// If the staff can't be fetched, the reviewers aren't split.
+	var internal map[string]bool
+	if err == nil {
+		internal = p.fetchStaffForReport(ctx, forge, owner, isOrg)
+	}
+	err = finishFetching(ctx, err)
+
+	attachment := post.Props["attachments"].([]*model.SlackAttachment)[0]
+	if err != nil {
+		p.API.LogError("failed to fetch data", "err", err.Error())
+		attachment.Text = githubErrorHandle(err)
+	} else {
+		activities, filtered := p.getBotFilter().filterReviews(activities)
+
+		reviewers := countReviewers(activities)
+		var internalReviewers, externalReviewers []*reviewerCount
+		reviews, comments := 0, 0
+		for _, r := range reviewers {
+			reviews += r.reviews()
+			comments += r.comments
+			if internal[strings.ToLower(r.login)] {
+				internalReviewers = append(internalReviewers, r)
+			} else {
+				externalReviewers = append(externalReviewers, r)
+			}
+		}
+
+		attachment.Title = "Reviewer stats between " + since.Format(shortFormWithDay) + " and " + until.Format(shortFormWithDay)
+		attachment.Text = ""
+		attachment.Fields = []*model.SlackAttachmentField{{
+			Title: "Number of reviews",
+			Value: strconv.Itoa(reviews),
+			Short: true,
+		}, {
+			Title: "Number of review comments",
+			Value: strconv.Itoa(comments),
+			Short: true,
+		}}
+		if internal == nil {
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: "Reviewers",
+				Value: formatReviewers(forge, reviewers),
+			})
+		} else {
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: "Internal reviewers",
+				Value: formatReviewers(forge, internalReviewers),
+			}, &model.SlackAttachmentField{
+				Title: "External reviewers",
+				Value: formatReviewers(forge, externalReviewers),
+			})
+		}
+		attachment.Fields = append(attachment.Fields, filtered.field("reviews and comments")...)
+	}
+
+	if _, appErr := p.API.UpdatePost(post); appErr != nil {
+		p.SendEphemeralPost(post.ChannelId, userID, "Something went bad. Please try again.")
+		p.API.LogError("failed to update post", "err", appErr.Error())
+		return appErr
+	}
+
+	return err
+}
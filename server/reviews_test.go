@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// reviewsTestProvider serves the reviews and the members of an organization for a reviewer report.
+type reviewsTestProvider struct {
+	testProvider
+	activities []reviewActivity
+	members    []string
+	membersErr error
+}
+
+func (r *reviewsTestProvider) fetchReviews(_ context.Context, _, _ string, _ bool, _, _ time.Time) ([]reviewActivity, error) {
+	return r.activities, nil
+}
+
+func (r *reviewsTestProvider) fetchMembers(_ context.Context, _ string) ([]string, error) {
+	return r.members, r.membersErr
+}
+
+func TestCountReviewers(t *testing.T) {
+	now := time.Now()
+	reviewers := countReviewers([]reviewActivity{
+		{"alice", reviewStateApproved, now},
+		{"alice", reviewStateChangesRequested, now},
+		{"alice", "", now},
+		{"bob", reviewStateCommented, now},
+		{"bob", "", now},
+		{"bob", "", now},
+		{"carol", reviewStateApproved, now},
+		{"dave", "", now},
+	})
+
+	assert.Equal(t, []*reviewerCount{
+		{login: "alice", approved: 1, changesRequested: 1, comments: 1},
+		{login: "bob", commented: 1, comments: 2},
+		{login: "carol", approved: 1},
+		{login: "dave", comments: 1},
+	}, reviewers)
+}
+
+func TestUpdateReviewersPost(t *testing.T) {
+	since := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC)
+	activities := []reviewActivity{
+		{"Alice", reviewStateApproved, since},
+		{"alice", "", since},
+		{"bob", reviewStateCommented, since},
+		{"carol", reviewStateChangesRequested, since},
+	}
+
+	update := func(t *testing.T, forge *reviewsTestProvider) map[string]string {
+		api := &plugintest.API{}
+		allowLogs(api)
+		newTestKVStore(api)
+		var updated *model.Post
+		api.On("UpdatePost", mock.Anything).Run(func(args mock.Arguments) {
+			updated = args.Get(0).(*model.Post)
+		}).Return(nil, (*model.AppError)(nil))
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		require.NoError(t, p.updateReviewersPost(context.Background(), forge, newTestJobPost(), "user", "org", "", true, since, until))
+		require.NotNil(t, updated)
+
+		fields := map[string]string{}
+		for _, field := range updated.Attachments()[0].Fields {
+			fields[field.Title] = field.Value.(string)
+		}
+		return fields
+	}
+
+	t.Run("members of the organization are internal reviewers", func(t *testing.T) {
+		fields := update(t, &reviewsTestProvider{activities: activities, members: []string{"alice", "Carol"}})
+
+		assert.Equal(t, "3", fields["Number of reviews"])
+		assert.Equal(t, "1", fields["Number of review comments"])
+		assert.NotContains(t, fields, "Reviewers")
+		assert.Contains(t, fields["Internal reviewers"], "[Alice]")
+		assert.Contains(t, fields["Internal reviewers"], "[alice]")
+		assert.Contains(t, fields["Internal reviewers"], "[carol]")
+		assert.NotContains(t, fields["Internal reviewers"], "[bob]")
+		assert.Contains(t, fields["External reviewers"], "[bob]")
+		assert.NotContains(t, fields["External reviewers"], "alice")
+	})
+
+	t.Run("reviewers aren't split if the members can't be fetched", func(t *testing.T) {
+		fields := update(t, &reviewsTestProvider{activities: activities, membersErr: errors.New("forbidden")})
+
+		assert.Equal(t, "3", fields["Number of reviews"])
+		assert.NotContains(t, fields, "Internal reviewers")
+		assert.NotContains(t, fields, "External reviewers")
+		assert.Contains(t, fields["Reviewers"], "[bob]")
+		assert.Contains(t, fields["Reviewers"], "[carol]")
+	})
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-community/server/util"
+)
+
+const (
+	scheduleKeyPrefix = "schedule_"
+
+	// schedulerJobKey is the key of the cluster job that starts due schedules on one node of a cluster.
+	schedulerJobKey = "schedules"
+
+	scheduleIDLength = 8
+)
+
+// schedulableReports are the reports that can be scheduled. Their time range is derived from the time of the run.
+var schedulableReports = []string{jobTypeCommitter, jobTypeChangelog, jobTypeNewCommitter, jobTypePullRequests, jobTypeIssues, jobTypeReviewers, jobTypeResponseTime}
+
+// schedule is a report that is posted to a channel periodically.
+type schedule struct {
+	ID        string
+	UserID    string
+	ChannelID string
+	Cadence   string
+	Report    string
+	Target    string
+	Repo      string
+	Paused    bool
+	CreatedAt time.Time
+	LastRunAt time.Time
+	NextRunAt time.Time
+}
+
+func (s *schedule) topic() string {
+	if s.Repo == "" {
+		return s.Target
+	}
+	return s.Target + "/" + s.Repo
+}
+
+func (p *Plugin) getSchedule(scheduleID string) (*schedule, error) {
+	data, appErr := p.API.KVGet(scheduleKeyPrefix + scheduleID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to load schedule")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	s := &schedule{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrap(err, "failed to decode schedule")
+	}
+	return s, nil
+}
+
+func (p *Plugin) saveSchedule(s *schedule) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode schedule")
+	}
+
+	if appErr := p.API.KVSet(scheduleKeyPrefix+s.ID, data); appErr != nil {
+		return errors.Wrap(appErr, "failed to store schedule")
+	}
+	return p.addToIndex(scheduleKeyPrefix, s.ID)
+}
+
+func (p *Plugin) listSchedules() ([]*schedule, error) {
+	scheduleIDs, err := p.getIndex(scheduleKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*schedule
+	for _, scheduleID := range scheduleIDs {
+		s, err := p.getSchedule(scheduleID)
+		if err != nil {
+			p.API.LogWarn("Failed to load schedule", "schedule", scheduleID, "error", err.Error())
+			continue
+		}
+		if s != nil {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// runSchedules starts the reports of all schedules that are due. It's called every minute on one node of a cluster.
+// If runs were missed while the plugin wasn't running, the first of them is made up once, for the days it was due to cover,
+// and the schedule continues with its next run after now.
+func (p *Plugin) runSchedules() {
+	schedules, err := p.listSchedules()
+	if err != nil {
+		p.API.LogError("Failed to list schedules", "error", err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, s := range schedules {
+		if s.Paused || s.NextRunAt.After(now) {
+			continue
+		}
+
+		c, err := parseCron(s.Cadence)
+		if err != nil {
+			p.API.LogError("Invalid schedule cadence", "schedule", s.ID, "error", err.Error())
+			continue
+		}
+
+		runAt := s.NextRunAt
+		s.LastRunAt = runAt
+		s.NextRunAt = c.next(now)
+		if err = p.saveSchedule(s); err != nil {
+			p.API.LogError("Failed to update schedule", "schedule", s.ID, "error", err.Error())
+			continue
+		}
+
+		if err = p.runSchedule(s, c, runAt); err != nil {
+			p.API.LogError("Failed to run schedule", "schedule", s.ID, "error", err.Error())
+		}
+	}
+}
+
+// runSchedule queues the report of a schedule. The report covers the days since the previous run.
+func (p *Plugin) runSchedule(s *schedule, c *cronSchedule, runAt time.Time) error {
+	since := truncateToDay(c.prev(runAt))
+	until := truncateToDay(runAt.AddDate(0, 0, -1))
+	if until.Before(since) {
+		until = since
+	}
+
+	forge, owner, appErr := p.getProvider(s.UserID, s.Target)
+	if appErr != nil {
+		return appErr
+	}
+
+	// Changelogs and new committers are always reported for organizations
+	isOrg := true
+	if s.Report != jobTypeChangelog && s.Report != jobTypeNewCommitter {
+		var err error
+		if isOrg, err = forge.verifyOwner(context.Background(), owner); err != nil {
+			return err
+		}
+	}
+
+	avatarLogo, err := forge.avatarURL(context.Background(), owner, isOrg)
+	if err != nil {
+		p.API.LogWarn("Failed to fetch avatar", "error", err.Error())
+		avatarLogo = ""
+	}
+
+	j := &job{
+		Type:      s.Report,
+		UserID:    s.UserID,
+		ChannelID: s.ChannelID,
+		Command:   fmt.Sprintf("/%v schedule %v", trigger, s.ID),
+		Target:    s.Target,
+		Repo:      s.Repo,
+		IsOrg:     isOrg,
+		Since:     since,
+		Until:     until,
+	}
+	if s.Report == jobTypeChangelog {
+		j.Since = time.Date(until.Year(), until.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	topic := owner
+	if s.Repo != "" {
+		topic += "/" + s.Repo
+	}
+
+	attachments := []*model.SlackAttachment{{
+		Title:      fmt.Sprintf("Fetching scheduled %v report", s.Report),
+		Text:       waitText,
+		AuthorName: topic,
+		AuthorIcon: avatarLogo,
+		AuthorLink: forge.webURL(topic),
+	}}
+
+	if appErr := p.queueJob(j, attachments); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func truncateToDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (p *Plugin) executeScheduleCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	if len(commandArgs) == 0 {
+		return &model.AppError{
+			Id:         "Need a subcommand: add, list, pause, resume or delete",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	switch commandArgs[0] {
+	case "add":
+		return p.addSchedule(commandArgs[1:], args)
+	case "list":
+		return p.listChannelSchedules(args)
+	case "pause", "resume", "delete":
+		return p.changeSchedule(commandArgs[0], commandArgs[1:], args)
+	default:
+		return &model.AppError{
+			Id:         fmt.Sprintf("Unknown command %v", commandArgs[0]),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+}
+
+// addSchedule handles /community schedule add [cadence] [report] [target].
+// The cadence is daily, weekly, monthly or a cron expression with five fields.
+func (p *Plugin) addSchedule(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	usage := &model.AppError{
+		Id:         "Usage: /community schedule add [daily|weekly|monthly|cron expression] [" + strings.Join(schedulableReports, "|") + "] [organization]/[repo]",
+		StatusCode: http.StatusBadRequest,
+		Where:      "p.ExecuteCommand",
+	}
+
+	if len(commandArgs) == 0 {
+		return usage
+	}
+
+	cadence := commandArgs[0]
+	rest := commandArgs[1:]
+	if _, ok := cadences[strings.ToLower(cadence)]; !ok {
+		if len(commandArgs) < 5 {
+			return usage
+		}
+		cadence = strings.Join(commandArgs[:5], " ")
+		rest = commandArgs[5:]
+	}
+	if len(rest) != 2 {
+		return usage
+	}
+
+	c, err := parseCron(cadence)
+	if err != nil {
+		return &model.AppError{
+			Id:         fmt.Sprintf("Invalid cadence: %v", err.Error()),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	report := rest[0]
+	if !util.Contains(schedulableReports, report) {
+		return usage
+	}
+
+	target, repo, err := util.ParseOwnerAndRepository(rest[1])
+	if err != nil {
+		return &model.AppError{
+			Id:         err.Error(),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	if report == jobTypeNewCommitter && repo != "" {
+		return &model.AppError{
+			Id:         "New committers can only be reported for organizations",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	forge, owner, appErr := p.getProvider(args.UserId, target)
+	if appErr != nil {
+		return appErr
+	}
+	if _, err = forge.verifyOwner(context.Background(), owner); err != nil {
+		return &model.AppError{
+			Id:         err.Error(),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	now := time.Now()
+	s := &schedule{
+		ID:        model.NewId()[:scheduleIDLength],
+		UserID:    args.UserId,
+		ChannelID: args.ChannelId,
+		Cadence:   cadence,
+		Report:    report,
+		Target:    target,
+		Repo:      repo,
+		CreatedAt: now,
+		NextRunAt: c.next(now),
+	}
+	if err = p.saveSchedule(s); err != nil {
+		p.API.LogError("Failed to add schedule", "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to add schedule",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, fmt.Sprintf("Added schedule %v. The %v report for %v is posted to this channel %v, next at %v.",
+		s.ID, s.Report, s.topic(), s.Cadence, s.NextRunAt.Format("2006-01-02 15:04 MST")))
+	return nil
+}
+
+func (p *Plugin) listChannelSchedules(args *model.CommandArgs) *model.AppError {
+	schedules, err := p.listSchedules()
+	if err != nil {
+		p.API.LogError("Failed to list schedules", "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to list schedules",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	var channelSchedules []*schedule
+	for _, s := range schedules {
+		if s.ChannelID == args.ChannelId {
+			channelSchedules = append(channelSchedules, s)
+		}
+	}
+
+	if len(channelSchedules) == 0 {
+		p.SendEphemeralPost(args.ChannelId, args.UserId, "There are no schedules in this channel.")
+		return nil
+	}
+
+	sort.Slice(channelSchedules, func(i, j int) bool {
+		return channelSchedules[i].CreatedAt.Before(channelSchedules[j].CreatedAt)
+	})
+
+	text := "| Schedule | Cadence | Report | Next run | Status | Created by |\n"
+	text += "|:---------|:--------|:-------|:---------|:-------|:-----------|\n"
+	for _, s := range channelSchedules {
+		status := "active"
+		nextRun := s.NextRunAt.Format("2006-01-02 15:04 MST")
+		if s.Paused {
+			status = "paused"
+			nextRun = "-"
+		}
+
+		username := ""
+		if user, appErr := p.API.GetUser(s.UserID); appErr == nil {
+			username = "@" + user.Username
+		}
+
+		text += fmt.Sprintf("| %v | `%v` | %v %v | %v | %v | %v |\n", s.ID, s.Cadence, s.Report, s.topic(), nextRun, status, username)
+	}
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, text)
+	return nil
+}
+
+// changeSchedule pauses, resumes or deletes a schedule. Only its creator and system administrators can change it.
+func (p *Plugin) changeSchedule(action string, commandArgs []string, args *model.CommandArgs) *model.AppError {
+	if len(commandArgs) != 1 {
+		return &model.AppError{
+			Id:         "Need one argument",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	scheduleID := strings.ToLower(commandArgs[0])
+
+	s, err := p.getSchedule(scheduleID)
+	if err != nil {
+		p.API.LogError("Failed to load schedule", "schedule", scheduleID, "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to load schedule",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	if s == nil {
+		return &model.AppError{
+			Id:         fmt.Sprintf("Schedule %v not found", scheduleID),
+			StatusCode: http.StatusNotFound,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	if s.UserID != args.UserId && !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return &model.AppError{
+			Id:         "Only the creator of a schedule or a system administrator can change it.",
+			StatusCode: http.StatusForbidden,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	var message string
+	switch action {
+	case "delete":
+		if appErr := p.API.KVDelete(scheduleKeyPrefix + s.ID); appErr != nil {
+			return appErr
+		}
+		if err := p.removeFromIndex(scheduleKeyPrefix, s.ID); err != nil {
+			p.API.LogWarn("Failed to remove schedule from index", "schedule", s.ID, "error", err.Error())
+		}
+		p.SendEphemeralPost(args.ChannelId, args.UserId, fmt.Sprintf("Deleted schedule %v.", s.ID))
+		return nil
+	case "pause":
+		s.Paused = true
+		message = fmt.Sprintf("Paused schedule %v.", s.ID)
+	case "resume":
+		c, err := parseCron(s.Cadence)
+		if err != nil {
+			return &model.AppError{
+				Id:         fmt.Sprintf("Invalid cadence: %v", err.Error()),
+				StatusCode: http.StatusBadRequest,
+				Where:      "p.ExecuteCommand",
+			}
+		}
+		s.Paused = false
+		s.NextRunAt = c.next(time.Now())
+		message = fmt.Sprintf("Resumed schedule %v. The next report is posted at %v.", s.ID, s.NextRunAt.Format("2006-01-02 15:04 MST"))
+	}
+
+	if err = p.saveSchedule(s); err != nil {
+		p.API.LogError("Failed to update schedule", "schedule", s.ID, "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to update schedule",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, message)
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+const (
+	defaultMaxConcurrentRequests = 10
+
+	// defaultRetryAfter is the time to wait after hitting a secondary rate limit, if GitHub doesn't specify one.
+	defaultRetryAfter = time.Minute
+
+	// maxRateLimitPauses is the number of times a single request waits for a rate limit before it fails.
+	maxRateLimitPauses = 5
+)
+
+// scheduler bounds the number of concurrent API requests of all fetchers.
+// When a rate limit is hit, every request is paused until the limit is reset, instead of failing the whole report.
+type scheduler struct {
+	lock        sync.Mutex
+	workers     chan struct{}
+	pausedUntil time.Time
+
+	logInfo func(msg string, keyValuePairs ...interface{})
+}
+
+func newScheduler(workers int, logInfo func(msg string, keyValuePairs ...interface{})) *scheduler {
+	s := &scheduler{
+		logInfo: logInfo,
+	}
+	s.setWorkers(workers)
+	return s
+}
+
+// setWorkers changes the size of the worker pool. Requests that are already running aren't affected.
+func (s *scheduler) setWorkers(workers int) {
+	if workers <= 0 {
+		workers = defaultMaxConcurrentRequests
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.workers == nil || cap(s.workers) != workers {
+		s.workers = make(chan struct{}, workers)
+	}
+}
+
+// do runs request as soon as a worker is free and no rate limit is active.
+// request is retried if it fails because of a rate limit. Waiting stops when ctx is done.
+func (s *scheduler) do(ctx context.Context, request func() (*github.Response, error)) error {
+	for pauses := 0; ; pauses++ {
+		if err := s.waitForPause(ctx); err != nil {
+			return err
+		}
+
+		s.lock.Lock()
+		workers := s.workers
+		s.lock.Unlock()
+
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		resp, err := request()
+		<-workers
+
+		if resp != nil && resp.Rate.Limit > 0 && resp.Rate.Remaining == 0 {
+			s.pause(resp.Rate.Reset.Time)
+		}
+
+		retryAt, limited := rateLimitedUntil(err)
+		if !limited {
+			return err
+		}
+		if pauses >= maxRateLimitPauses {
+			return err
+		}
+		s.pause(retryAt)
+	}
+}
+
+func (s *scheduler) waitForPause(ctx context.Context) error {
+	for {
+		s.lock.Lock()
+		wait := time.Until(s.pausedUntil)
+		s.lock.Unlock()
+
+		if wait <= 0 {
+			return ctx.Err()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *scheduler) pause(until time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if until.After(s.pausedUntil) {
+		s.pausedUntil = until
+		s.logInfo("Hit rate limit. Pausing requests.", "until", until.String())
+	}
+}
+
+// rateLimitedUntil checks if err was caused by a primary or secondary rate limit and returns the time to retry the request.
+func rateLimitedUntil(err error) (time.Time, bool) {
+	switch e := err.(type) {
+	case *github.RateLimitError:
+		// Leave some room for clock skew between the plugin and GitHub
+		return e.Rate.Reset.Time.Add(time.Second), true
+	case *github.AbuseRateLimitError:
+		if e.RetryAfter != nil {
+			return time.Now().Add(e.GetRetryAfter()), true
+		}
+		return time.Now().Add(defaultRetryAfter), true
+	case *github.ErrorResponse:
+		return retryAfterResponse(e.Response, e.Message)
+	case *gitLabError:
+		return retryAfterResponse(e.Response, e.Message)
+	}
+	return time.Time{}, false
+}
+
+// retryAfterResponse checks if a failed response was caused by a rate limit, that isn't reported in a dedicated error type.
+func retryAfterResponse(resp *http.Response, message string) (time.Time, bool) {
+	if resp == nil {
+		return time.Time{}, false
+	}
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+
+	if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Now().Add(time.Duration(retryAfter) * time.Second), true
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || strings.Contains(strings.ToLower(message), "secondary rate limit") {
+		return time.Now().Add(defaultRetryAfter), true
+	}
+	return time.Time{}, false
+}
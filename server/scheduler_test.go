@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitedUntil(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	abuseRetryAfter := 30 * time.Second
+	response := func(statusCode int, retryAfter string) *http.Response {
+		resp := &http.Response{StatusCode: statusCode, Header: http.Header{}}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return resp
+	}
+
+	for name, tc := range map[string]struct {
+		err      error
+		limited  bool
+		expected time.Duration
+	}{
+		"primary rate limit": {
+			err:     &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: reset}}},
+			limited: true,
+		},
+		"secondary rate limit with retry after": {
+			err:      &github.AbuseRateLimitError{RetryAfter: &abuseRetryAfter},
+			limited:  true,
+			expected: 30 * time.Second,
+		},
+		"secondary rate limit without retry after": {
+			err:      &github.AbuseRateLimitError{},
+			limited:  true,
+			expected: defaultRetryAfter,
+		},
+		"GitHub 403 with retry after": {
+			err:      &github.ErrorResponse{Response: response(http.StatusForbidden, "20")},
+			limited:  true,
+			expected: 20 * time.Second,
+		},
+		"GitHub 403 of a secondary rate limit": {
+			err:      &github.ErrorResponse{Response: response(http.StatusForbidden, ""), Message: "You have exceeded a secondary rate limit."},
+			limited:  true,
+			expected: defaultRetryAfter,
+		},
+		"GitHub 403 without permission": {
+			err: &github.ErrorResponse{Response: response(http.StatusForbidden, ""), Message: "Resource not accessible by integration"},
+		},
+		"GitLab 429 with retry after": {
+			err:      &gitLabError{Response: response(http.StatusTooManyRequests, "10")},
+			limited:  true,
+			expected: 10 * time.Second,
+		},
+		"GitLab 429 without retry after": {
+			err:      &gitLabError{Response: response(http.StatusTooManyRequests, "")},
+			limited:  true,
+			expected: defaultRetryAfter,
+		},
+		"GitLab 404": {
+			err: &gitLabError{Response: response(http.StatusNotFound, "10")},
+		},
+		"any other error": {
+			err: errors.New("connection refused"),
+		},
+		"no error": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			retryAt, limited := rateLimitedUntil(tc.err)
+			assert.Equal(t, tc.limited, limited)
+
+			switch {
+			case !tc.limited:
+				assert.True(t, retryAt.IsZero())
+			case tc.expected == 0:
+				// Primary rate limits are retried a second after the reset
+				assert.True(t, reset.Add(time.Second).Equal(retryAt), "got %v", retryAt)
+			default:
+				assert.WithinDuration(t, now.Add(tc.expected), retryAt, time.Second)
+			}
+		})
+	}
+}
+
+func TestSchedulerDo(t *testing.T) {
+	// Limits that were reset in the past are retried right away
+	rateLimitErr := &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(-time.Hour)}}}
+
+	t.Run("requests are retried after rate limits", func(t *testing.T) {
+		s := newScheduler(1, func(string, ...interface{}) {})
+
+		calls := 0
+		err := s.do(context.Background(), func() (*github.Response, error) {
+			calls++
+			if calls < 3 {
+				return nil, rateLimitErr
+			}
+			return nil, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("retries stop after the maximum number of pauses", func(t *testing.T) {
+		s := newScheduler(1, func(string, ...interface{}) {})
+
+		calls := 0
+		err := s.do(context.Background(), func() (*github.Response, error) {
+			calls++
+			return nil, rateLimitErr
+		})
+		assert.Equal(t, rateLimitErr, err)
+		assert.Equal(t, maxRateLimitPauses+1, calls)
+	})
+
+	t.Run("other errors aren't retried", func(t *testing.T) {
+		s := newScheduler(1, func(string, ...interface{}) {})
+
+		calls := 0
+		err := s.do(context.Background(), func() (*github.Response, error) {
+			calls++
+			return nil, errors.New("not found")
+		})
+		assert.EqualError(t, err, "not found")
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("exhausted rate limits pause every request", func(t *testing.T) {
+		var logged []string
+		s := newScheduler(2, func(msg string, _ ...interface{}) {
+			logged = append(logged, msg)
+		})
+
+		err := s.do(context.Background(), func() (*github.Response, error) {
+			return &github.Response{Rate: github.Rate{Limit: 5000, Remaining: 0, Reset: github.Timestamp{Time: time.Now().Add(time.Hour)}}}, nil
+		})
+		require.NoError(t, err)
+		assert.Len(t, logged, 1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err = s.do(ctx, func() (*github.Response, error) {
+			t.Error("request was sent while the scheduler is paused")
+			return nil, nil
+		})
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("concurrent requests are bounded by the workers", func(t *testing.T) {
+		s := newScheduler(2, func(string, ...interface{}) {})
+
+		var lock sync.Mutex
+		running, maxRunning := 0, 0
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = s.do(context.Background(), func() (*github.Response, error) {
+					lock.Lock()
+					running++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					lock.Unlock()
+
+					time.Sleep(5 * time.Millisecond)
+
+					lock.Lock()
+					running--
+					lock.Unlock()
+					return nil, nil
+				})
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 2, maxRunning)
+	})
+}
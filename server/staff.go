@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	staffCacheKeyPrefix = "staff_"
+
+	// staffCacheTTL is the time after which the staff of an owner is fetched again.
+	staffCacheTTL = 24 * time.Hour
+)
+
+// cachedStaff are the lower case logins of the staff of an owner.
+type cachedStaff struct {
+	Logins    []string
+	FetchedAt time.Time
+}
+
+// getStaffCacheKey returns the KV key of the staff of an owner. Changing the staff teams invalidates it.
+func getStaffCacheKey(source, owner string, teams []string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(source + "/" + owner + "/" + strings.Join(teams, ","))))
+	return staffCacheKeyPrefix + hex.EncodeToString(hash[:])[:40]
+}
+
+// getStaffTeams returns the configured teams whose members count as staff.
+func (p *Plugin) getStaffTeams() []string {
+	var teams []string
+	for _, team := range strings.Split(p.getConfiguration().StaffTeams, ",") {
+		if team = strings.TrimSpace(team); team != "" {
+			teams = append(teams, team)
+		}
+	}
+	return teams
+}
+
+// staffSource is the part of a provider that staff is fetched from.
+type staffSource interface {
+	forgeInfo
+	memberFetcher
+}
+
+// fetchStaff returns the lower case logins of the staff of an owner: the members of an organization and of its
+// configured staff teams, or the user who owns the repositories. The result is cached for staffCacheTTL.
+func (p *Plugin) fetchStaff(ctx context.Context, forge staffSource, owner string, isOrg bool) (map[string]bool, error) {
+	staff := map[string]bool{strings.ToLower(owner): true}
+	if !isOrg {
+		return staff, nil
+	}
+
+	teams := p.getStaffTeams()
+	key := getStaffCacheKey(forge.id(), owner, teams)
+
+	var cache cachedStaff
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		p.API.LogWarn("Failed to load staff cache", "owner", owner, "error", appErr.Error())
+	} else if data != nil {
+		if err := json.Unmarshal(data, &cache); err != nil {
+			p.API.LogWarn("Failed to decode staff cache", "owner", owner, "error", err.Error())
+		}
+	}
+
+	if cache.FetchedAt.IsZero() || time.Since(cache.FetchedAt) > staffCacheTTL {
+		members, err := forge.fetchMembers(ctx, owner)
+		if err != nil {
+			return nil, err
+		}
+		if len(teams) > 0 {
+			teamMembers, err := forge.fetchTeamMembers(ctx, owner, teams)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, teamMembers...)
+		}
+
+		cache = cachedStaff{FetchedAt: time.Now()}
+		for _, member := range members {
+			cache.Logins = append(cache.Logins, strings.ToLower(member))
+		}
+
+		if data, err := json.Marshal(cache); err != nil {
+			p.API.LogWarn("Failed to encode staff cache", "owner", owner, "error", err.Error())
+		} else if appErr := p.API.KVSet(key, data); appErr != nil {
+			p.API.LogWarn("Failed to store staff cache", "owner", owner, "error", appErr.Error())
+		}
+	}
+
+	for _, login := range cache.Logins {
+		staff[login] = true
+	}
+	return staff, nil
+}
+
+// fetchStaffForReport is fetchStaff for reports that still work without the classification.
+// nil is returned if the staff can't be fetched, e.g. because the token can't read the members of the organization.
+func (p *Plugin) fetchStaffForReport(ctx context.Context, forge staffSource, owner string, isOrg bool) map[string]bool {
+	staff, err := p.fetchStaff(ctx, forge, owner, isOrg)
+	if err != nil {
+		p.API.LogWarn("Failed to fetch staff", "owner", owner, "error", err.Error())
+		return nil
+	}
+	return staff
+}
+
+// splitStaff splits logins into staff and community. The order of logins is kept.
+func splitStaff(logins []string, staff map[string]bool) (staffLogins, communityLogins []string) {
+	for _, login := range logins {
+		if staff[strings.ToLower(login)] {
+			staffLogins = append(staffLogins, login)
+		} else {
+			communityLogins = append(communityLogins, login)
+		}
+	}
+	return staffLogins, communityLogins
+}
+
+// formatRatio formats the share of part in total, e.g. "25% (5 of 20 commits)".
+func formatRatio(part, total int, unit string) string {
+	if total == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%v%% (%v of %v %v)", part*100/total, part, total, unit)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStaff(t *testing.T) {
+	staff := map[string]bool{"alice": true, "carol": true}
+
+	staffLogins, communityLogins := splitStaff([]string{"Alice", "bob", "carol", "dave"}, staff)
+	assert.Equal(t, []string{"Alice", "carol"}, staffLogins)
+	assert.Equal(t, []string{"bob", "dave"}, communityLogins)
+
+	staffCommitters, communityCommitters := splitCommitters([]committerCount{{login: "bob", commits: 5}, {login: "alice", commits: 3}, {login: "dave", commits: 1}}, staff)
+	assert.Equal(t, []committerCount{{login: "alice", commits: 3}}, staffCommitters)
+	assert.Equal(t, []committerCount{{login: "bob", commits: 5}, {login: "dave", commits: 1}}, communityCommitters)
+	assert.Equal(t, 6, sumCommits(communityCommitters))
+}
+
+func TestFormatRatio(t *testing.T) {
+	assert.Equal(t, "n/a", formatRatio(0, 0, "commits"))
+	assert.Equal(t, "25% (5 of 20 commits)", formatRatio(5, 20, "commits"))
+	assert.Equal(t, "33% (1 of 3 committers)", formatRatio(1, 3, "committers"))
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+
+	"github.com/mattermost/mattermost-plugin-community/server/util"
+)
+
+const (
+	commitStatsCacheKeyPrefix = "stats_"
+
+	// commitStatsCacheTTL is the time the stats of a commit are kept, so that the cache of repositories that aren't reported on anymore is removed.
+	commitStatsCacheTTL = 365 * 24 * time.Hour
+
+	// commitStatsWorkers is the number of commits whose stats are fetched concurrently, if a forge returns them one by one.
+	// The scheduler bounds the requests anyway, this only keeps long ranges from starting a goroutine per commit.
+	commitStatsWorkers = defaultMaxConcurrentRequests
+
+	// Measures the committer leaderboard can be sorted by.
+	measureCommits   = "commits"
+	measureAdditions = "additions"
+	measureDeletions = "deletions"
+	measureLines     = "lines"
+	measureFiles     = "files"
+)
+
+var committerMeasures = []string{measureCommits, measureAdditions, measureDeletions, measureLines, measureFiles}
+
+// needsCommitStats checks if the committer leaderboard sorted by measure shows the lines and files changed.
+func needsCommitStats(measure string) bool {
+	return measure != "" && measure != measureCommits
+}
+
+// commitStats are the lines added and deleted by a commit, and the number of files it changed.
+// Summed up, Files are file changes: a file changed by two commits counts twice.
+type commitStats struct {
+	Additions int
+	Deletions int
+	Files     int
+}
+
+func getCommitStatsCacheKey(source, owner, repo, sha string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(source + "/" + owner + "/" + repo + "/" + sha)))
+	return commitStatsCacheKeyPrefix + hex.EncodeToString(hash[:])[:40]
+}
+
+// fetchCommitStats returns the stats of commits by their SHA. Commits never change,
+// so the stats of every commit are kept in the KV store for commitStatsCacheTTL and only fetched once.
+// The number of commits whose stats couldn't be fetched is returned as well. An error is only returned if ctx is done.
+func (p *Plugin) fetchCommitStats(ctx context.Context, forge provider, owner string, commits []*github.RepositoryCommit) (map[string]commitStats, int, error) {
+	shasByRepo := map[string][]string{}
+	seen := map[string]bool{}
+	for _, c := range commits {
+		if seen[c.GetSHA()] {
+			continue
+		}
+		seen[c.GetSHA()] = true
+
+		repo := repoFromCommitURL(forge, owner, c.GetHTMLURL())
+		shasByRepo[repo] = append(shasByRepo[repo], c.GetSHA())
+	}
+
+	result := map[string]commitStats{}
+	for repo, shas := range shasByRepo {
+		if repo == "" {
+			continue
+		}
+
+		var missing []string
+		for _, sha := range shas {
+			if stats, ok := p.getCachedCommitStats(getCommitStatsCacheKey(forge.id(), owner, repo, sha)); ok {
+				result[sha] = stats
+			} else {
+				missing = append(missing, sha)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		fetched, err := forge.fetchCommitStats(ctx, owner, repo, missing)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, 0, ctx.Err()
+			}
+			p.API.LogWarn("Failed to fetch commit stats", "repo", owner+"/"+repo, "error", err.Error())
+		}
+
+		for sha, stats := range fetched {
+			result[sha] = stats
+			p.setCachedCommitStats(getCommitStatsCacheKey(forge.id(), owner, repo, sha), stats)
+		}
+	}
+
+	return result, len(seen) - len(result), nil
+}
+
+func (p *Plugin) getCachedCommitStats(key string) (commitStats, bool) {
+	var stats commitStats
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		p.API.LogWarn("Failed to load commit stats cache", "error", appErr.Error())
+		return stats, false
+	}
+	if data == nil {
+		return stats, false
+	}
+	if err := json.Unmarshal(data, &stats); err != nil {
+		p.API.LogWarn("Failed to decode commit stats cache", "error", err.Error())
+		return stats, false
+	}
+	return stats, true
+}
+
+func (p *Plugin) setCachedCommitStats(key string, stats commitStats) {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		p.API.LogWarn("Failed to encode commit stats cache", "error", err.Error())
+		return
+	}
+	if _, appErr := p.API.KVSetWithOptions(key, data, model.PluginKVSetOptions{
+		ExpireInSeconds: int64(commitStatsCacheTTL / time.Second),
+	}); appErr != nil {
+		p.API.LogWarn("Failed to store commit stats cache", "error", appErr.Error())
+	}
+}
+
+// forEachCommit calls fetch for every SHA from commitStatsWorkers goroutines and returns once all calls returned.
+func forEachCommit(shas []string, fetch func(sha string)) {
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < commitStatsWorkers && i < len(shas); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sha := range queue {
+				fetch(sha)
+			}
+		}()
+	}
+
+	for _, sha := range shas {
+		queue <- sha
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// countCommitterStats returns the number of commits, and the lines and files they changed, per author.
+// Committers are sorted by measure, and by their number of commits if they are equal.
+// stats can be nil, if the lines changed aren't reported.
+func countCommitterStats(commits []*github.RepositoryCommit, stats map[string]commitStats, measure string) []committerCount {
+	committers := map[string]*committerCount{}
+	for _, c := range commits {
+		author := c.GetAuthor()
+		if author == nil {
+			continue
+		}
+
+		committer, ok := committers[author.GetLogin()]
+		if !ok {
+			committer = &committerCount{login: author.GetLogin()}
+			committers[author.GetLogin()] = committer
+		}
+		committer.commits++
+		if changed, ok := stats[c.GetSHA()]; ok {
+			committer.changed.add(changed)
+			committer.hasStats = true
+		}
+	}
+
+	var result []committerCount
+	for _, c := range committers {
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].measure(measure), result[j].measure(measure)
+		if a != b {
+			return a > b
+		}
+		if result[i].commits != result[j].commits {
+			return result[i].commits > result[j].commits
+		}
+		return result[i].login < result[j].login
+	})
+	return result
+}
+
+func (c committerCount) measure(measure string) int {
+	switch measure {
+	case measureAdditions:
+		return c.changed.Additions
+	case measureDeletions:
+		return c.changed.Deletions
+	case measureLines:
+		return c.changed.Additions + c.changed.Deletions
+	case measureFiles:
+		return c.changed.Files
+	default:
+		return c.commits
+	}
+}
+
+func (s *commitStats) add(other commitStats) {
+	s.Additions += other.Additions
+	s.Deletions += other.Deletions
+	s.Files += other.Files
+}
+
+// sumCommitStats returns the lines and files changed by all commits, including the ones that aren't linked to a user account.
+func sumCommitStats(commits []*github.RepositoryCommit, stats map[string]commitStats) commitStats {
+	var sum commitStats
+	for _, c := range commits {
+		sum.add(stats[c.GetSHA()])
+	}
+	return sum
+}
+
+// formatLinesChanged renders the lines and files changed by commits, e.g. "+1,204 / -310 lines, 52 file changes".
+// Files aren't distinct, a file changed by two commits counts as two file changes.
+func formatLinesChanged(s commitStats) string {
+	changes := "file changes"
+	if s.Files == 1 {
+		changes = "file change"
+	}
+	return fmt.Sprintf("+%v / -%v lines, %v %v", util.FormatCount(s.Additions), util.FormatCount(s.Deletions), util.FormatCount(s.Files), changes)
+}
+
+// formatCommitterStats lists committers with their number of commits and, if they are known, the lines and files they changed.
+func formatCommitterStats(forge forgeInfo, committers []committerCount) string {
+	if len(committers) == 0 {
+		return "None"
+	}
+
+	var text string
+	for _, e := range committers {
+		c := "commits"
+		if e.commits == 1 {
+			c = "commit"
+		}
+		text += fmt.Sprintf("- [%s](%s): %v %v", e.login, forge.webURL(e.login), e.commits, c)
+		if e.hasStats {
+			text += ", " + formatLinesChanged(e.changed)
+		}
+		text += "\n"
+	}
+	return text
+}
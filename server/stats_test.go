@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (t *testProvider) fetchCommitStats(ctx context.Context, _, _ string, shas []string) (map[string]commitStats, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	result := map[string]commitStats{}
+	for _, sha := range shas {
+		if stats, ok := t.stats[sha]; ok {
+			result[sha] = stats
+		}
+	}
+	return result, nil
+}
+
+func TestParseNumstat(t *testing.T) {
+	out := "\x1eabc\n\n10\t2\tserver/plugin.go\n-\t-\tassets/icon.png\n3\t0\tREADME.md\n\x1edef\n\n\x1eghi\n\n1\t1\tgo.mod\n"
+
+	assert.Equal(t, map[string]commitStats{
+		"abc": {Additions: 13, Deletions: 2, Files: 3},
+		"def": {},
+		"ghi": {Additions: 1, Deletions: 1, Files: 1},
+	}, parseNumstat(out))
+}
+
+func TestCountCommitterStats(t *testing.T) {
+	commit := func(sha, login string) *github.RepositoryCommit {
+		c := &github.RepositoryCommit{SHA: github.String(sha)}
+		if login != "" {
+			c.Author = &github.User{Login: github.String(login)}
+		}
+		return c
+	}
+	commits := []*github.RepositoryCommit{
+		commit("a", "alice"),
+		commit("b", "alice"),
+		commit("c", "alice"),
+		commit("d", "bob"),
+		commit("e", ""),
+	}
+	stats := map[string]commitStats{
+		"a": {Additions: 1, Deletions: 1, Files: 1},
+		"b": {Additions: 2, Deletions: 0, Files: 1},
+		"d": {Additions: 500, Deletions: 20, Files: 12},
+		"e": {Additions: 7, Deletions: 3, Files: 2},
+	}
+
+	byCommits := countCommitterStats(commits, stats, measureCommits)
+	require.Len(t, byCommits, 2)
+	assert.Equal(t, committerCount{login: "alice", commits: 3, changed: commitStats{Additions: 3, Deletions: 1, Files: 2}, hasStats: true}, byCommits[0])
+	assert.Equal(t, "bob", byCommits[1].login)
+
+	byLines := countCommitterStats(commits, stats, measureLines)
+	assert.Equal(t, "bob", byLines[0].login)
+	assert.Equal(t, 520, byLines[0].measure(measureLines))
+
+	assert.Equal(t, commitStats{Additions: 510, Deletions: 24, Files: 16}, sumCommitStats(commits, stats))
+	assert.Equal(t, "+510 / -24 lines, 16 file changes", formatLinesChanged(sumCommitStats(commits, stats)))
+
+	forge := &testProvider{}
+	assert.Equal(t, "- [bob](https://forge.example.com/bob): 1 commit, +500 / -20 lines, 12 file changes\n"+
+		"- [alice](https://forge.example.com/alice): 3 commits, +3 / -1 lines, 2 file changes\n", formatCommitterStats(forge, byLines))
+
+	// Without stats, only the commits are listed
+	assert.Equal(t, "- [alice](https://forge.example.com/alice): 3 commits\n"+
+		"- [bob](https://forge.example.com/bob): 1 commit\n", formatCommitterStats(forge, countCommitterStats(commits, nil, measureCommits)))
+	assert.Equal(t, "None", formatCommitterStats(forge, nil))
+}
+
+func TestForEachCommit(t *testing.T) {
+	var lock sync.Mutex
+	var fetched []string
+	running, maxRunning := 0, 0
+
+	var shas []string
+	for i := 0; i < 3*commitStatsWorkers; i++ {
+		shas = append(shas, strconv.Itoa(i))
+	}
+	forEachCommit(shas, func(sha string) {
+		lock.Lock()
+		fetched = append(fetched, sha)
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+	})
+
+	assert.ElementsMatch(t, shas, fetched)
+	assert.LessOrEqual(t, maxRunning, commitStatsWorkers)
+}
+
+func TestFetchCommitStats(t *testing.T) {
+	api := &plugintest.API{}
+	allowLogs(api)
+	kv := newTestKVStore(api)
+	cached, err := json.Marshal(commitStats{Additions: 1, Deletions: 2, Files: 1})
+	require.NoError(t, err)
+	kv.set(getCommitStatsCacheKey("forge.example.com", "org", "server", "a"), cached)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	forge := &testProvider{stats: map[string]commitStats{
+		// Cached stats aren't fetched again
+		"a": {Additions: 100},
+		"b": {Additions: 5, Files: 1},
+	}}
+	commit := func(sha string) *github.RepositoryCommit {
+		return &github.RepositoryCommit{
+			SHA:     github.String(sha),
+			HTMLURL: github.String("https://forge.example.com/org/server/commit/" + sha),
+		}
+	}
+
+	stats, missing, err := p.fetchCommitStats(context.Background(), forge, "org", []*github.RepositoryCommit{commit("a"), commit("b"), commit("c")})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]commitStats{
+		"a": {Additions: 1, Deletions: 2, Files: 1},
+		"b": {Additions: 5, Files: 1},
+	}, stats)
+	assert.Equal(t, 1, missing)
+
+	// Every commit is cached on its own
+	var stored commitStats
+	require.NoError(t, json.Unmarshal(kv.get(getCommitStatsCacheKey("forge.example.com", "org", "server", "b")), &stored))
+	assert.Equal(t, commitStats{Additions: 5, Files: 1}, stored)
+	assert.Nil(t, kv.get(getCommitStatsCacheKey("forge.example.com", "org", "server", "c")))
+
+	// Cancelled reports don't get partial stats
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = p.fetchCommitStats(ctx, forge, "org", []*github.RepositoryCommit{commit("a"), commit("d")})
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-community/server/util"
+)
+
+const (
+	subscriptionKeyPrefix = "subscription_"
+	announcedKeyPrefix    = "announced_"
+
+	subscriptionIDLength = 8
+
+	// subscriptionPollInterval is the interval in which subscriptions are checked for new contributors,
+	// if they aren't served by webhook events.
+	subscriptionPollInterval = time.Hour
+)
+
+const featureNewContributors = "new-contributors"
+
+var subscriptionFeatures = []string{featureNewContributors}
+
+// subscription announces events of an organization or repository in a channel.
+type subscription struct {
+	ID        string
+	ChannelID string
+	UserID    string
+	// Target is the owner of the repositories, including the prefix of its forge.
+	Target        string
+	Repo          string
+	Feature       string
+	CreatedAt     time.Time
+	LastCheckedAt time.Time
+}
+
+func (s *subscription) topic() string {
+	if s.Repo == "" {
+		return s.Target
+	}
+	return s.Target + "/" + s.Repo
+}
+
+// matches checks if a webhook event of a GitHub repository belongs to the subscription.
+func (s *subscription) matches(fullName string) bool {
+	if strings.HasPrefix(s.Target, gitLabPrefix) {
+		return false
+	}
+	if s.Repo == "" {
+		return strings.EqualFold(strings.SplitN(fullName, "/", 2)[0], s.Target)
+	}
+	return strings.EqualFold(fullName, s.topic())
+}
+
+func (p *Plugin) getSubscription(subscriptionID string) (*subscription, error) {
+	data, appErr := p.API.KVGet(subscriptionKeyPrefix + subscriptionID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to load subscription")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	s := &subscription{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrap(err, "failed to decode subscription")
+	}
+	return s, nil
+}
+
+func (p *Plugin) saveSubscription(s *subscription) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode subscription")
+	}
+
+	if appErr := p.API.KVSet(subscriptionKeyPrefix+s.ID, data); appErr != nil {
+		return errors.Wrap(appErr, "failed to store subscription")
+	}
+	return p.addToIndex(subscriptionKeyPrefix, s.ID)
+}
+
+func (p *Plugin) listSubscriptions() ([]*subscription, error) {
+	subscriptionIDs, err := p.getIndex(subscriptionKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*subscription
+	for _, subscriptionID := range subscriptionIDs {
+		s, err := p.getSubscription(subscriptionID)
+		if err != nil {
+			p.API.LogWarn("Failed to load subscription", "subscription", subscriptionID, "error", err.Error())
+			continue
+		}
+		if s != nil {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// getAnnouncedKey returns the KV key that records the announcement of a contributor for a subscription.
+// The key is hashed, because logins can be too long for the length limit of keys.
+func getAnnouncedKey(subscriptionID, login string) string {
+	hash := sha256.Sum256([]byte(subscriptionID + "/" + strings.ToLower(login)))
+	return announcedKeyPrefix + hex.EncodeToString(hash[:])[:40]
+}
+
+// markAnnounced records that a contributor was announced for a subscription.
+// It returns false, if the contributor was already announced, e.g. by another node or by polling.
+func (p *Plugin) markAnnounced(s *subscription, login string) (bool, error) {
+	announced, appErr := p.API.KVSetWithOptions(getAnnouncedKey(s.ID, login), []byte("1"), model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: nil,
+	})
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to store announcement")
+	}
+	return announced, nil
+}
+
+// isAnnounced checks if a contributor was already announced for a subscription.
+func (p *Plugin) isAnnounced(s *subscription, login string) (bool, error) {
+	data, appErr := p.API.KVGet(getAnnouncedKey(s.ID, login))
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to load announcement")
+	}
+	return data != nil, nil
+}
+
+// handleContributionEvent announces the first merged pull request of a contributor in the subscribed channels.
+// It returns false, if the event couldn't be checked for one of the subscriptions.
+func (p *Plugin) handleContributionEvent(e *contributionEvent) bool {
+	if e.Type != eventTypePullRequest || e.Action != "closed" || !e.Merged || p.getBotFilter().isBotLogin(e.Author) {
+		return true
+	}
+
+	subscriptions, err := p.listSubscriptions()
+	if err != nil {
+		p.API.LogError("Failed to list subscriptions", "error", err.Error())
+		return false
+	}
+
+	handled := true
+
+	for _, s := range subscriptions {
+		if s.Feature != featureNewContributors || !s.matches(e.Repo) {
+			continue
+		}
+
+		forge, owner, appErr := p.getProvider(s.UserID, s.Target)
+		if appErr != nil {
+			p.API.LogWarn("Failed to check for earlier contributions", "subscription", s.ID, "error", appErr.Error())
+			handled = false
+			continue
+		}
+
+		isFirst, err := p.isFirstContribution(forge, owner, s.Repo, e)
+		if err != nil {
+			p.API.LogWarn("Failed to check for earlier contributions", "subscription", s.ID, "login", e.Author, "error", err.Error())
+			handled = false
+			continue
+		}
+		if !isFirst {
+			continue
+		}
+
+		p.announceNewContributor(s, e.Author, fmt.Sprintf("First contribution by [%s](%s) to [%s](%s): [#%d %s](%s)",
+			e.Author, forge.webURL(e.Author), e.Repo, forge.webURL(e.Repo), e.Number, e.Title, e.URL))
+	}
+	return handled
+}
+
+// isFirstContribution checks if the merged pull request of an event is the first contribution of its author
+// to repo, or to every repository of owner if repo is empty.
+func (p *Plugin) isFirstContribution(forge provider, owner, repo string, e *contributionEvent) (bool, error) {
+	switch e.AuthorAssociation {
+	case "OWNER", "MEMBER", "COLLABORATOR":
+		return false, nil
+	case "FIRST_TIME_CONTRIBUTOR", "FIRST_TIMER":
+		// The association only covers the repository of the pull request
+		if repo != "" {
+			return true, nil
+		}
+	}
+
+	// The association might already count the merged pull request, so check the commits before it was opened
+	ctx, cancel := context.WithTimeout(context.Background(), p.getJobTimeout())
+	defer cancel()
+
+	repos := []string{repo}
+	if repo == "" {
+		var err error
+		if repos, err = forge.listRepositories(ctx, owner, true); err != nil {
+			return false, err
+		}
+	}
+
+	earlier, err := forge.contributedBefore(ctx, owner, repos, []string{e.Author}, e.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	return !earlier[e.Author], nil
+}
+
+func (p *Plugin) announceNewContributor(s *subscription, login, message string) {
+	isNew, err := p.markAnnounced(s, login)
+	if err != nil {
+		p.API.LogWarn("Failed to announce new contributor", "subscription", s.ID, "error", err.Error())
+		return
+	}
+	if !isNew {
+		return
+	}
+
+	if _, appErr := p.API.CreatePost(&model.Post{
+		ChannelId: s.ChannelID,
+		UserId:    p.botUserID,
+		Message:   message,
+	}); appErr != nil {
+		p.API.LogError("failed to create post", "err", appErr.Error())
+	}
+}
+
+// pollSubscriptions checks subscriptions for new contributors. If a webhook is configured,
+// only GitLab subscriptions are polled, as GitHub subscriptions are served by webhook events.
+// Instead, the stored events that failed to be handled are retried.
+func (p *Plugin) pollSubscriptions() {
+	webhookConfigured := p.getConfiguration().WebhookSecret != ""
+
+	subscriptions, err := p.listSubscriptions()
+	if err != nil {
+		p.API.LogError("Failed to list subscriptions", "error", err.Error())
+		return
+	}
+
+	now := time.Now()
+	retryEvents := false
+	for _, s := range subscriptions {
+		if s.Feature != featureNewContributors || now.Sub(s.LastCheckedAt) < subscriptionPollInterval {
+			continue
+		}
+
+		since := s.LastCheckedAt
+		s.LastCheckedAt = now
+		if err = p.saveSubscription(s); err != nil {
+			p.API.LogError("Failed to update subscription", "subscription", s.ID, "error", err.Error())
+			continue
+		}
+
+		if webhookConfigured && !strings.HasPrefix(s.Target, gitLabPrefix) {
+			retryEvents = true
+			continue
+		}
+		go p.pollNewContributors(s, since)
+	}
+
+	if retryEvents {
+		go p.retryEvents()
+	}
+}
+
+func (p *Plugin) pollNewContributors(s *subscription, since time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.getJobTimeout())
+	defer cancel()
+
+	forge, owner, appErr := p.getProvider(s.UserID, s.Target)
+	if appErr != nil {
+		p.API.LogWarn("Failed to poll subscription", "subscription", s.ID, "error", appErr.Error())
+		return
+	}
+
+	if err := p.announceNewCommitters(ctx, forge, owner, s, since, time.Now()); err != nil {
+		p.API.LogWarn("Failed to poll subscription", "subscription", s.ID, "error", err.Error())
+	}
+}
+
+// announceNewCommitters announces the authors of the commits between since and until, who haven't committed before since.
+// The commits are read through the commit cache, so that only the commits since the last sync are fetched,
+// and only authors who weren't announced for the subscription yet are checked for earlier commits.
+func (p *Plugin) announceNewCommitters(ctx context.Context, forge provider, owner string, s *subscription, since, until time.Time) error {
+	commits, err := forge.fetchCommits(ctx, owner, s.Repo, true, since, until)
+	if err != nil {
+		return err
+	}
+
+	first := map[string]firstContributionInfo{}
+	for _, c := range commits {
+		login := c.GetAuthor().GetLogin()
+		if login == "" {
+			continue
+		}
+		if e, ok := first[login]; ok && !commitDate(c).Before(e.date) {
+			continue
+		}
+		first[login] = firstContributionInfo{login, commitDate(c), c.GetHTMLURL(), owner, repoFromCommitURL(forge, owner, c.GetHTMLURL())}
+	}
+
+	var candidates []firstContributionInfo
+	for _, e := range first {
+		announced, err := p.isAnnounced(s, e.author)
+		if err != nil {
+			return err
+		}
+		if !announced {
+			candidates = append(candidates, e)
+		}
+	}
+	candidates, _ = p.getBotFilter().filterFirstContributions(candidates)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	repos := []string{s.Repo}
+	if s.Repo == "" {
+		if repos, err = forge.listRepositories(ctx, owner, true); err != nil {
+			return err
+		}
+	}
+	var logins []string
+	for _, e := range candidates {
+		logins = append(logins, e.author)
+	}
+	earlier, err := forge.contributedBefore(ctx, owner, repos, logins, since)
+	if err != nil {
+		return err
+	}
+
+	var result []firstContributionInfo
+	for _, e := range candidates {
+		if !earlier[e.author] {
+			result = append(result, e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].date.Before(result[j].date)
+	})
+
+	for _, e := range result {
+		p.announceNewContributor(s, e.author, fmt.Sprintf("First contribution by [%s](%s) to [%s](%s): [commit](%s)",
+			e.author, forge.webURL(e.author), e.org+"/"+e.repo, forge.webURL(e.org+"/"+e.repo), e.commit))
+	}
+	return nil
+}
+
+// executeSubscribeCommand handles /community subscribe [organization]/[repo] [feature].
+// Without arguments, the subscriptions of the channel are listed.
+func (p *Plugin) executeSubscribeCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	if len(commandArgs) == 0 {
+		return p.listChannelSubscriptions(args)
+	}
+	if len(commandArgs) != 2 || !util.Contains(subscriptionFeatures, commandArgs[1]) {
+		return &model.AppError{
+			Id:         "Usage: /community subscribe [organization]/[repo] [" + strings.Join(subscriptionFeatures, "|") + "]",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	target, repo, err := util.ParseOwnerAndRepository(commandArgs[0])
+	if err != nil {
+		return &model.AppError{
+			Id:         err.Error(),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	subscriptions, err := p.listSubscriptions()
+	if err != nil {
+		p.API.LogError("Failed to list subscriptions", "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to list subscriptions",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	for _, s := range subscriptions {
+		if s.ChannelID == args.ChannelId && s.Feature == commandArgs[1] && strings.EqualFold(s.Target, target) && strings.EqualFold(s.Repo, repo) {
+			return &model.AppError{
+				Id:         fmt.Sprintf("This channel is already subscribed to %v of %v", s.Feature, s.topic()),
+				StatusCode: http.StatusBadRequest,
+				Where:      "p.ExecuteCommand",
+			}
+		}
+	}
+
+	forge, owner, appErr := p.getProvider(args.UserId, target)
+	if appErr != nil {
+		return appErr
+	}
+	isOrg, err := forge.verifyOwner(context.Background(), owner)
+	if err != nil {
+		return &model.AppError{
+			Id:         err.Error(),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+	if !isOrg {
+		return &model.AppError{
+			Id:         "New contributors can only be announced for organizations",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	now := time.Now()
+	s := &subscription{
+		ID:            model.NewId()[:subscriptionIDLength],
+		ChannelID:     args.ChannelId,
+		UserID:        args.UserId,
+		Target:        target,
+		Repo:          repo,
+		Feature:       commandArgs[1],
+		CreatedAt:     now,
+		LastCheckedAt: now,
+	}
+	if err = p.saveSubscription(s); err != nil {
+		p.API.LogError("Failed to add subscription", "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to add subscription",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, fmt.Sprintf("Subscribed this channel to %v of %v.", s.Feature, s.topic()))
+	return nil
+}
+
+func (p *Plugin) listChannelSubscriptions(args *model.CommandArgs) *model.AppError {
+	subscriptions, err := p.listSubscriptions()
+	if err != nil {
+		p.API.LogError("Failed to list subscriptions", "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to list subscriptions",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	var lines []string
+	for _, s := range subscriptions {
+		if s.ChannelID == args.ChannelId {
+			lines = append(lines, fmt.Sprintf("- %v of %v", s.Feature, s.topic()))
+		}
+	}
+	if len(lines) == 0 {
+		p.SendEphemeralPost(args.ChannelId, args.UserId, "This channel has no subscriptions.")
+		return nil
+	}
+
+	sort.Strings(lines)
+	p.SendEphemeralPost(args.ChannelId, args.UserId, "This channel is subscribed to:\n"+strings.Join(lines, "\n"))
+	return nil
+}
+
+// executeUnsubscribeCommand handles /community unsubscribe [organization]/[repo] [feature].
+// Without a feature, every subscription of the channel to the target is removed.
+func (p *Plugin) executeUnsubscribeCommand(commandArgs []string, args *model.CommandArgs) *model.AppError {
+	if len(commandArgs) != 1 && len(commandArgs) != 2 {
+		return &model.AppError{
+			Id:         "Usage: /community unsubscribe [organization]/[repo] [" + strings.Join(subscriptionFeatures, "|") + "]",
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	target, repo, err := util.ParseOwnerAndRepository(commandArgs[0])
+	if err != nil {
+		return &model.AppError{
+			Id:         err.Error(),
+			StatusCode: http.StatusBadRequest,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	subscriptions, err := p.listSubscriptions()
+	if err != nil {
+		p.API.LogError("Failed to list subscriptions", "error", err.Error())
+		return &model.AppError{
+			Id:         "Failed to list subscriptions",
+			StatusCode: http.StatusInternalServerError,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	removed := 0
+	for _, s := range subscriptions {
+		if s.ChannelID != args.ChannelId || !strings.EqualFold(s.Target, target) || !strings.EqualFold(s.Repo, repo) {
+			continue
+		}
+		if len(commandArgs) == 2 && s.Feature != commandArgs[1] {
+			continue
+		}
+
+		if appErr := p.API.KVDelete(subscriptionKeyPrefix + s.ID); appErr != nil {
+			return appErr
+		}
+		if err := p.removeFromIndex(subscriptionKeyPrefix, s.ID); err != nil {
+			p.API.LogWarn("Failed to remove subscription from index", "subscription", s.ID, "error", err.Error())
+		}
+		removed++
+	}
+
+	if removed == 0 {
+		return &model.AppError{
+			Id:         fmt.Sprintf("This channel isn't subscribed to %v", commandArgs[0]),
+			StatusCode: http.StatusNotFound,
+			Where:      "p.ExecuteCommand",
+		}
+	}
+
+	p.SendEphemeralPost(args.ChannelId, args.UserId, fmt.Sprintf("Unsubscribed this channel from %v.", commandArgs[0]))
+	return nil
+}
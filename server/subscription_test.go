@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAnnouncedKey(t *testing.T) {
+	long := strings.Repeat("a", 39)
+
+	key := getAnnouncedKey("abcdefgh", long)
+	assert.True(t, strings.HasPrefix(key, announcedKeyPrefix))
+	assert.LessOrEqual(t, utf8.RuneCountInString(key), 50)
+	assert.Equal(t, key, getAnnouncedKey("abcdefgh", strings.ToUpper(long)))
+	assert.NotEqual(t, key, getAnnouncedKey("abcdefgi", long))
+}
+
+// subscriptionTestProvider answers the checks for earlier contributions and records the repositories that were checked.
+type subscriptionTestProvider struct {
+	testProvider
+	repos   []string
+	earlier map[string]bool
+	commits []*github.RepositoryCommit
+
+	checkedRepos  []string
+	checkedLogins []string
+	checkedBefore time.Time
+}
+
+func (s *subscriptionTestProvider) fetchCommits(_ context.Context, _, _ string, _ bool, _, _ time.Time) ([]*github.RepositoryCommit, error) {
+	return s.commits, nil
+}
+
+func (s *subscriptionTestProvider) listRepositories(_ context.Context, _ string, _ bool) ([]string, error) {
+	return s.repos, nil
+}
+
+func (s *subscriptionTestProvider) contributedBefore(_ context.Context, _ string, repos, logins []string, before time.Time) (map[string]bool, error) {
+	s.checkedRepos = repos
+	s.checkedLogins = logins
+	s.checkedBefore = before
+	result := map[string]bool{}
+	for _, login := range logins {
+		if s.earlier[login] {
+			result[login] = true
+		}
+	}
+	return result, nil
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	org := &subscription{Target: "mattermost"}
+	assert.True(t, org.matches("mattermost/mattermost-server"))
+	assert.True(t, org.matches("Mattermost/mattermost-webapp"))
+	assert.False(t, org.matches("mattermost-community/mattermost-server"))
+
+	repo := &subscription{Target: "mattermost", Repo: "mattermost-server"}
+	assert.True(t, repo.matches("mattermost/Mattermost-Server"))
+	assert.False(t, repo.matches("mattermost/mattermost-webapp"))
+
+	// GitLab subscriptions are polled instead of served by GitHub events
+	gitLab := &subscription{Target: gitLabPrefix + "mattermost"}
+	assert.False(t, gitLab.matches("mattermost/mattermost-server"))
+}
+
+func TestIsFirstContribution(t *testing.T) {
+	p := &Plugin{}
+	p.SetAPI(&plugintest.API{})
+	createdAt := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		repo         string
+		association  string
+		earlier      bool
+		expected     bool
+		checkedRepos []string
+	}{
+		"members": {
+			repo:        "server",
+			association: "MEMBER",
+		},
+		"first time contributors to the subscribed repository": {
+			repo:        "server",
+			association: "FIRST_TIME_CONTRIBUTOR",
+			expected:    true,
+		},
+		"first time contributors to one of the repositories of an organization": {
+			association:  "FIRST_TIME_CONTRIBUTOR",
+			earlier:      true,
+			checkedRepos: []string{"server", "webapp"},
+		},
+		"contributors without earlier commits": {
+			repo:         "server",
+			association:  "CONTRIBUTOR",
+			expected:     true,
+			checkedRepos: []string{"server"},
+		},
+		"contributors with earlier commits": {
+			association:  "NONE",
+			earlier:      true,
+			checkedRepos: []string{"server", "webapp"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			forge := &subscriptionTestProvider{repos: []string{"server", "webapp"}, earlier: map[string]bool{"jane": tc.earlier}}
+			e := &contributionEvent{Author: "jane", AuthorAssociation: tc.association, CreatedAt: createdAt}
+
+			isFirst, err := p.isFirstContribution(forge, "org", tc.repo, e)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, isFirst)
+			assert.Equal(t, tc.checkedRepos, forge.checkedRepos)
+			if tc.checkedRepos != nil {
+				// Commits are checked up to the time the pull request was opened
+				assert.Equal(t, createdAt, forge.checkedBefore)
+			}
+		})
+	}
+}
+
+func TestAnnounceNewContributor(t *testing.T) {
+	api := &plugintest.API{}
+	newTestKVStore(api)
+	var channels []string
+	api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+		channels = append(channels, args.Get(0).(*model.Post).ChannelId)
+	}).Return(nil, (*model.AppError)(nil))
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	first := &subscription{ID: "first", ChannelID: "channel1"}
+	second := &subscription{ID: "second", ChannelID: "channel2"}
+
+	// A contributor is announced once per subscription, even if both the webhook and polling find them
+	p.announceNewContributor(first, "jane", "First contribution by jane")
+	p.announceNewContributor(first, "Jane", "First contribution by jane")
+	p.announceNewContributor(second, "jane", "First contribution by jane")
+	p.announceNewContributor(first, "john", "First contribution by john")
+
+	assert.Equal(t, []string{"channel1", "channel2", "channel1"}, channels)
+}
+
+func TestAnnounceNewCommitters(t *testing.T) {
+	since := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(sha, login, userType string, days int) *github.RepositoryCommit {
+		c := newTestCommit(sha, since.AddDate(0, 0, days))
+		c.HTMLURL = github.String("https://forge.example.com/org/server/commit/" + sha)
+		c.Author = &github.User{Login: github.String(login), Type: github.String(userType)}
+		return c
+	}
+
+	api := &plugintest.API{}
+	allowLogs(api)
+	newTestKVStore(api)
+	var messages []string
+	api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+		messages = append(messages, args.Get(0).(*model.Post).Message)
+	}).Return(nil, (*model.AppError)(nil))
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	s := &subscription{ID: "subscription", ChannelID: "channel"}
+	forge := &subscriptionTestProvider{
+		repos:   []string{"server", "webapp"},
+		earlier: map[string]bool{"jane": true},
+		commits: []*github.RepositoryCommit{
+			commit("a", "newbie", "User", 2),
+			commit("b", "newbie", "User", 1),
+			commit("c", "jane", "User", 1),
+			commit("d", "announced", "User", 1),
+			commit("e", "dependabot[bot]", gitHubBotType, 1),
+		},
+	}
+	_, err := p.markAnnounced(s, "announced")
+	require.NoError(t, err)
+
+	require.NoError(t, p.announceNewCommitters(context.Background(), forge, "org", s, since, since.AddDate(0, 0, 3)))
+
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0], "[newbie]")
+	assert.Contains(t, messages[0], "commit/b")
+	// Only the authors that weren't announced yet are checked for commits before the last poll
+	assert.ElementsMatch(t, []string{"newbie", "jane"}, forge.checkedLogins)
+	assert.Equal(t, []string{"server", "webapp"}, forge.checkedRepos)
+	assert.Equal(t, since, forge.checkedBefore)
+}
@@ -0,0 +1,39 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// FormatCount formats a number with commas as thousands separators, e.g. 5,210
+func FormatCount(n int) string {
+	s := strconv.Itoa(n)
+
+	start := 0
+	if n < 0 {
+		start = 1
+	}
+
+	for i := len(s) - 3; i > start; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// FormatDuration formats a duration with its two largest units, e.g. 3d 4h, 5h 12m or 12m.
+// Durations below a minute are formatted as 0m.
+func FormatDuration(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%vd %vh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%vh %vm", hours, minutes)
+	default:
+		return fmt.Sprintf("%vm", minutes)
+	}
+}
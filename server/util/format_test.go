@@ -0,0 +1,44 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatCount(t *testing.T) {
+	tcs := []struct {
+		Input    int
+		Expected string
+	}{
+		{Input: 0, Expected: "0"},
+		{Input: 999, Expected: "999"},
+		{Input: 1000, Expected: "1,000"},
+		{Input: 5210, Expected: "5,210"},
+		{Input: 1234567, Expected: "1,234,567"},
+		{Input: -100, Expected: "-100"},
+		{Input: -1000, Expected: "-1,000"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.Expected, FormatCount(tc.Input))
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tcs := []struct {
+		Input    time.Duration
+		Expected string
+	}{
+		{Input: 0, Expected: "0m"},
+		{Input: 30 * time.Second, Expected: "0m"},
+		{Input: 12 * time.Minute, Expected: "12m"},
+		{Input: 5*time.Hour + 12*time.Minute, Expected: "5h 12m"},
+		{Input: 76*time.Hour + 30*time.Minute, Expected: "3d 4h"},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.Expected, FormatDuration(tc.Input))
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // GitHub signs webhooks with SHA-1 as well as SHA-256
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	webhookPath = "/webhook"
+
+	eventKeyPrefix = "event_"
+
+	// eventRetention is the time webhook events, that weren't handled yet, are kept in the KV store.
+	// It leaves time for every retry, even if the plugin isn't running for a while. Handled events are deleted.
+	eventRetention = 3 * 24 * time.Hour
+
+	// eventRetryDelay is the time after which an event, that wasn't handled yet, is handled again.
+	// It leaves time for the handling that starts when the event is received.
+	eventRetryDelay = 10 * time.Minute
+
+	// maxEventAttempts is the number of times handling an event is tried, before it's given up.
+	maxEventAttempts = 3
+
+	// maxWebhookPayloadSize is the maximum size of a payload GitHub sends.
+	maxWebhookPayloadSize = 25 << 20
+)
+
+const (
+	eventTypePush        = "push"
+	eventTypePullRequest = "pull_request"
+	eventTypeIssues      = "issues"
+)
+
+// contributionEvent is the part of a GitHub webhook event that reports and announcements use.
+type contributionEvent struct {
+	DeliveryID string
+	Type       string
+	Action     string
+	// Repo is the full name of the repository, e.g. mattermost/mattermost-server.
+	Repo       string
+	Sender     string
+	ReceivedAt time.Time
+
+	// Only set for pull_request and issues events
+	Number            int
+	Title             string
+	URL               string
+	Author            string
+	AuthorAssociation string
+	Merged            bool
+	CreatedAt         time.Time
+	ClosedAt          time.Time
+
+	// Only set for push events
+	Ref     string
+	Commits []eventCommit
+
+	// Handled is set once the event was handled for every subscription, or handling it was given up.
+	Handled  bool
+	Attempts int
+}
+
+type eventCommit struct {
+	SHA         string
+	URL         string
+	AuthorLogin string
+	AuthorName  string
+	AuthorEmail string
+	Date        time.Time
+}
+
+// owner returns the owner of the repository of the event.
+func (e *contributionEvent) owner() string {
+	return strings.SplitN(e.Repo, "/", 2)[0]
+}
+
+// ServeHTTP receives GitHub webhook events on /plugins/com.mattermost.community/webhook
+func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case webhookPath:
+		p.handleWebhook(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Plugin) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := p.getConfiguration().WebhookSecret
+	if secret == "" {
+		http.Error(w, "Webhooks are disabled", http.StatusForbidden)
+		return
+	}
+
+	if contentType := r.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		http.Error(w, "Content type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayloadSize))
+	if err != nil {
+		http.Error(w, "Failed to read payload", http.StatusBadRequest)
+		return
+	}
+
+	if err = validateWebhookSignature(r.Header, payload, []byte(secret)); err != nil {
+		p.API.LogWarn("Rejected webhook", "error", err.Error())
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := parseContributionEvent(github.WebHookType(r), payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if event == nil {
+		// Other events, e.g. the ping that GitHub sends when a webhook is created, are acknowledged but not stored
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	event.DeliveryID = github.DeliveryID(r)
+	if event.DeliveryID == "" {
+		event.DeliveryID = model.NewId()
+	}
+	event.ReceivedAt = time.Now()
+
+	isNew, err := p.storeEvent(event)
+	if err != nil {
+		p.API.LogError("Failed to store webhook event", "delivery", event.DeliveryID, "error", err.Error())
+		http.Error(w, "Failed to store event", http.StatusInternalServerError)
+		return
+	}
+	// GitHub might deliver an event more than once, e.g. when a delivery is redelivered manually
+	if !isNew {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	go p.processEvent(event)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validateWebhookSignature checks the HMAC signature of a payload. The SHA-256 signature is preferred over the SHA-1 one.
+func validateWebhookSignature(header http.Header, payload, secret []byte) error {
+	signature, prefix, hashFunc := header.Get("X-Hub-Signature-256"), "sha256=", sha256.New
+	if signature == "" {
+		signature = header.Get("X-Hub-Signature")
+		prefix = "sha1="
+		hashFunc = sha1.New
+	}
+	if signature == "" {
+		return errors.New("missing signature")
+	}
+	if !strings.HasPrefix(signature, prefix) {
+		return errors.Errorf("unsupported signature %q", signature)
+	}
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode signature")
+	}
+
+	mac := hmac.New(hashFunc, secret)
+	_, _ = mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errors.New("signature doesn't match")
+	}
+	return nil
+}
+
+// parseContributionEvent converts the payload of a push, pull_request or issues event.
+// It returns nil for other events.
+func parseContributionEvent(eventType string, payload []byte) (*contributionEvent, error) {
+	switch eventType {
+	case eventTypePush, eventTypePullRequest, eventTypeIssues:
+	default:
+		return nil, nil
+	}
+
+	parsed, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse payload")
+	}
+
+	switch e := parsed.(type) {
+	case *github.PushEvent:
+		event := &contributionEvent{
+			Type:   eventTypePush,
+			Repo:   e.GetRepo().GetFullName(),
+			Sender: e.GetSender().GetLogin(),
+			Ref:    e.GetRef(),
+		}
+		for _, c := range e.Commits {
+			event.Commits = append(event.Commits, eventCommit{
+				SHA:         c.GetID(),
+				URL:         c.GetURL(),
+				AuthorLogin: c.GetAuthor().GetLogin(),
+				AuthorName:  c.GetAuthor().GetName(),
+				AuthorEmail: c.GetAuthor().GetEmail(),
+				Date:        c.GetTimestamp().Time,
+			})
+		}
+		return event, nil
+
+	case *github.PullRequestEvent:
+		pr := e.GetPullRequest()
+		return &contributionEvent{
+			Type:              eventTypePullRequest,
+			Action:            e.GetAction(),
+			Repo:              e.GetRepo().GetFullName(),
+			Sender:            e.GetSender().GetLogin(),
+			Number:            pr.GetNumber(),
+			Title:             pr.GetTitle(),
+			URL:               pr.GetHTMLURL(),
+			Author:            pr.GetUser().GetLogin(),
+			AuthorAssociation: pr.GetAuthorAssociation(),
+			Merged:            pr.GetMerged(),
+			CreatedAt:         pr.GetCreatedAt(),
+			ClosedAt:          pr.GetClosedAt(),
+		}, nil
+
+	case *github.IssuesEvent:
+		issue := e.GetIssue()
+		return &contributionEvent{
+			Type:              eventTypeIssues,
+			Action:            e.GetAction(),
+			Repo:              e.GetRepo().GetFullName(),
+			Sender:            e.GetSender().GetLogin(),
+			Number:            issue.GetNumber(),
+			Title:             issue.GetTitle(),
+			URL:               issue.GetHTMLURL(),
+			Author:            issue.GetUser().GetLogin(),
+			AuthorAssociation: issue.GetAuthorAssociation(),
+			CreatedAt:         issue.GetCreatedAt(),
+			ClosedAt:          issue.GetClosedAt(),
+		}, nil
+	}
+
+	return nil, errors.Errorf("unexpected payload for %v event", eventType)
+}
+
+// storeEvent stores a webhook event for eventRetention and adds it to the index of unhandled events.
+// It returns false, if the event was already stored.
+func (p *Plugin) storeEvent(e *contributionEvent) (bool, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to encode event")
+	}
+
+	stored, appErr := p.API.KVSetWithOptions(eventKeyPrefix+e.DeliveryID, data, model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(eventRetention / time.Second),
+	})
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to store event")
+	}
+	if !stored {
+		return false, nil
+	}
+	if err = p.addToIndex(eventKeyPrefix, e.DeliveryID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// updateEvent stores the changed state of an event, keeping its original expiry. Handled events are deleted.
+func (p *Plugin) updateEvent(e *contributionEvent) error {
+	expiry := eventRetention - time.Since(e.ReceivedAt)
+	if e.Handled || expiry < time.Second {
+		return p.deleteEvent(e.DeliveryID)
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+
+	if _, appErr := p.API.KVSetWithOptions(eventKeyPrefix+e.DeliveryID, data, model.PluginKVSetOptions{
+		ExpireInSeconds: int64(expiry / time.Second),
+	}); appErr != nil {
+		return errors.Wrap(appErr, "failed to store event")
+	}
+	return nil
+}
+
+// deleteEvent deletes a stored event and removes it from the index of unhandled events.
+func (p *Plugin) deleteEvent(deliveryID string) error {
+	if appErr := p.API.KVDelete(eventKeyPrefix + deliveryID); appErr != nil {
+		return errors.Wrap(appErr, "failed to delete event")
+	}
+	return p.removeFromIndex(eventKeyPrefix, deliveryID)
+}
+
+// listUnhandledEvents returns the stored webhook events that were received before the given time and aren't handled yet.
+// Events that expired, or were handled but not deleted, are removed from the index of unhandled events.
+func (p *Plugin) listUnhandledEvents(receivedBefore time.Time) ([]*contributionEvent, error) {
+	deliveryIDs, err := p.getIndex(eventKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*contributionEvent
+	for _, deliveryID := range deliveryIDs {
+		data, appErr := p.API.KVGet(eventKeyPrefix + deliveryID)
+		if appErr != nil {
+			p.API.LogWarn("Failed to load event", "delivery", deliveryID, "error", appErr.Error())
+			continue
+		}
+
+		e := &contributionEvent{}
+		if data != nil {
+			if err = json.Unmarshal(data, e); err != nil {
+				p.API.LogWarn("Failed to decode event", "delivery", deliveryID, "error", err.Error())
+				continue
+			}
+		}
+		if data == nil || e.Handled {
+			if err = p.deleteEvent(deliveryID); err != nil {
+				p.API.LogWarn("Failed to delete event", "delivery", deliveryID, "error", err.Error())
+			}
+			continue
+		}
+		if e.ReceivedAt.Before(receivedBefore) {
+			result = append(result, e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ReceivedAt.Before(result[j].ReceivedAt)
+	})
+	return result, nil
+}
+
+// processEvent handles a stored event for every subscription and records the outcome.
+// Events that fail to be handled are retried by retryEvents, until maxEventAttempts is reached.
+func (p *Plugin) processEvent(e *contributionEvent) {
+	e.Attempts++
+	e.Handled = p.handleContributionEvent(e)
+	if !e.Handled && e.Attempts >= maxEventAttempts {
+		p.API.LogWarn("Giving up on webhook event", "delivery", e.DeliveryID, "attempts", e.Attempts)
+		e.Handled = true
+	}
+
+	if err := p.updateEvent(e); err != nil {
+		p.API.LogWarn("Failed to update webhook event", "delivery", e.DeliveryID, "error", err.Error())
+	}
+}
+
+// retryEvents handles the stored events again, whose handling failed or was interrupted, e.g. by a restart of the plugin.
+func (p *Plugin) retryEvents() {
+	events, err := p.listUnhandledEvents(time.Now().Add(-eventRetryDelay))
+	if err != nil {
+		p.API.LogError("Failed to list webhook events", "error", err.Error())
+		return
+	}
+
+	for _, e := range events {
+		p.processEvent(e)
+	}
+}
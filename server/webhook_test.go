@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testWebhookSecret = "secret"
+
+func readWebhookPayload(t *testing.T, eventType string) []byte {
+	payload, err := ioutil.ReadFile(filepath.Join("testdata", "webhooks", eventType+".json"))
+	require.NoError(t, err)
+	return payload
+}
+
+func signWebhookPayload(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidateWebhookSignature(t *testing.T) {
+	payload := readWebhookPayload(t, eventTypePush)
+
+	header := http.Header{}
+	header.Set("X-Hub-Signature-256", signWebhookPayload(payload))
+	assert.NoError(t, validateWebhookSignature(header, payload, []byte(testWebhookSecret)))
+	assert.Error(t, validateWebhookSignature(header, payload, []byte("other")))
+	assert.Error(t, validateWebhookSignature(header, append(payload, ' '), []byte(testWebhookSecret)))
+
+	header = http.Header{}
+	header.Set("X-Hub-Signature", "sha1=0e21b2d0ea84cee7c4e1ae2d1c3cb4b98cc2b5f8")
+	assert.Error(t, validateWebhookSignature(header, payload, []byte(testWebhookSecret)))
+
+	assert.Error(t, validateWebhookSignature(http.Header{}, payload, []byte(testWebhookSecret)))
+}
+
+func TestParseContributionEvent(t *testing.T) {
+	t.Run("push", func(t *testing.T) {
+		event, err := parseContributionEvent(eventTypePush, readWebhookPayload(t, eventTypePush))
+		require.NoError(t, err)
+		require.NotNil(t, event)
+
+		assert.Equal(t, "mattermost/mattermost-plugin-community", event.Repo)
+		assert.Equal(t, "mattermost", event.owner())
+		assert.Equal(t, "refs/heads/master", event.Ref)
+		require.Len(t, event.Commits, 1)
+		assert.Equal(t, "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c", event.Commits[0].SHA)
+		assert.Equal(t, "janedoe", event.Commits[0].AuthorLogin)
+		assert.Equal(t, "jane@example.com", event.Commits[0].AuthorEmail)
+		assert.True(t, event.Commits[0].Date.Equal(time.Date(2020, 9, 14, 11, 42, 27, 0, time.UTC)))
+	})
+
+	t.Run("pull_request", func(t *testing.T) {
+		event, err := parseContributionEvent(eventTypePullRequest, readWebhookPayload(t, eventTypePullRequest))
+		require.NoError(t, err)
+		require.NotNil(t, event)
+
+		assert.Equal(t, "closed", event.Action)
+		assert.Equal(t, 42, event.Number)
+		assert.Equal(t, "janedoe", event.Author)
+		assert.Equal(t, "hanzei", event.Sender)
+		assert.Equal(t, "FIRST_TIME_CONTRIBUTOR", event.AuthorAssociation)
+		assert.True(t, event.Merged)
+	})
+
+	t.Run("issues", func(t *testing.T) {
+		event, err := parseContributionEvent(eventTypeIssues, readWebhookPayload(t, eventTypeIssues))
+		require.NoError(t, err)
+		require.NotNil(t, event)
+
+		assert.Equal(t, "opened", event.Action)
+		assert.Equal(t, 43, event.Number)
+		assert.Equal(t, "johndoe", event.Author)
+		assert.True(t, event.ClosedAt.IsZero())
+	})
+
+	t.Run("other events are ignored", func(t *testing.T) {
+		event, err := parseContributionEvent("ping", readWebhookPayload(t, "ping"))
+		require.NoError(t, err)
+		assert.Nil(t, event)
+	})
+}
+
+func TestServeHTTPWebhook(t *testing.T) {
+	post := func(p *Plugin, eventType string, payload []byte, signature string) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, webhookPath, bytes.NewReader(payload))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-GitHub-Event", eventType)
+		r.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+		r.Header.Set("X-Hub-Signature-256", signature)
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w.Result()
+	}
+
+	t.Run("stores signed events", func(t *testing.T) {
+		api := &plugintest.API{}
+		allowLogs(api)
+		kv := newTestKVStore(api)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{WebhookSecret: testWebhookSecret})
+
+		payload := readWebhookPayload(t, eventTypePullRequest)
+		resp := post(p, eventTypePullRequest, payload, signWebhookPayload(payload))
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		// The event is handled in the background. Without subscriptions, it's handled right away and deleted.
+		require.Eventually(t, func() bool {
+			return kv.get(eventKeyPrefix+"72d3162e-cc78-11e3-81ab-4c9367dc0958") == nil && string(kv.get(indexKeyPrefix+eventKeyPrefix)) == "[]"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("rejects invalid signatures", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything).Return()
+
+		p := &Plugin{}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{WebhookSecret: testWebhookSecret})
+
+		payload := readWebhookPayload(t, eventTypePullRequest)
+		resp := post(p, eventTypePullRequest, payload, signWebhookPayload([]byte("other")))
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("is disabled without secret", func(t *testing.T) {
+		p := &Plugin{}
+		p.setConfiguration(&configuration{})
+
+		payload := readWebhookPayload(t, eventTypePush)
+		resp := post(p, eventTypePush, payload, signWebhookPayload(payload))
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestRetryEvents(t *testing.T) {
+	now := time.Now()
+	events := []*contributionEvent{
+		{DeliveryID: "old", Type: eventTypePush, ReceivedAt: now.Add(-time.Hour)},
+		{DeliveryID: "handled", Type: eventTypePush, ReceivedAt: now.Add(-time.Hour), Handled: true},
+		{DeliveryID: "new", Type: eventTypePush, ReceivedAt: now},
+	}
+
+	api := &plugintest.API{}
+	allowLogs(api)
+	kv := newTestKVStore(api)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		require.NoError(t, err)
+		kv.set(eventKeyPrefix+e.DeliveryID, data)
+	}
+	// The index of unhandled events is built from the stored events once. It still lists an event that expired.
+	require.NoError(t, p.addToIndex(eventKeyPrefix, "expired"))
+
+	p.retryEvents()
+
+	// Events that were handled, or were handled before, are deleted. The new one isn't handled again yet.
+	assert.Nil(t, kv.get(eventKeyPrefix+"old"))
+	assert.Nil(t, kv.get(eventKeyPrefix+"handled"))
+	assert.NotNil(t, kv.get(eventKeyPrefix+"new"))
+	ids, err := p.getIndex(eventKeyPrefix)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"new"}, ids)
+}